@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// -------------------- Admission queue --------------------
+
+// Whole-request admission: bounds how many requests run the model pipeline
+// at once so bursts queue briefly instead of piling onto Ollama.
+
+var (
+	admitSlots     chan struct{} // nil = unlimited
+	queueDepth     atomic.Int64
+	inFlight       atomic.Int64
+	rejectedTotal  atomic.Int64
+	queueFullTotal atomic.Int64
+)
+
+func initAdmission(limit int) {
+	if limit > 0 {
+		admitSlots = make(chan struct{}, limit)
+	}
+}
+
+// missJitter sleeps a random fraction of cfg.MissJitter so that a burst of
+// identical cache misses doesn't hit Ollama in the same instant. It returns
+// false if ctx ends first.
+func missJitter(ctx context.Context) bool {
+	if cfg.MissJitter <= 0 {
+		return true
+	}
+	t := time.NewTimer(rand.N(time.Duration(cfg.MissJitter)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// admit waits for a pipeline slot. It gives up after cfg.QueueWait or when
+// ctx ends, and at once when cfg.MaxQueue requests are already waiting.
+func admit(ctx context.Context) (release func(), ok bool) {
+	if admitSlots == nil {
+		inFlight.Add(1)
+		return func() { inFlight.Add(-1) }, true
+	}
+
+	release = func() {
+		<-admitSlots
+		inFlight.Add(-1)
+	}
+
+	select {
+	case admitSlots <- struct{}{}:
+		inFlight.Add(1)
+		return release, true
+	default:
+	}
+
+	if n := queueDepth.Add(1); cfg.MaxQueue > 0 && n > int64(cfg.MaxQueue) {
+		queueDepth.Add(-1)
+		queueFullTotal.Add(1)
+		return nil, false
+	}
+	defer queueDepth.Add(-1)
+
+	t := time.NewTimer(time.Duration(cfg.QueueWait))
+	defer t.Stop()
+	select {
+	case admitSlots <- struct{}{}:
+		inFlight.Add(1)
+		return release, true
+	case <-t.C:
+	case <-ctx.Done():
+	}
+	rejectedTotal.Add(1)
+	return nil, false
+}
+
+// tryAdmit takes a spare pipeline slot for extra work a request that holds
+// one can do without, like a bake-off's further syntheses. It never waits,
+// so such a request can't block on the others, and it leaves free slots to
+// requests already queueing for one. in_flight still counts requests, not
+// slots.
+func tryAdmit() (release func(), ok bool) {
+	if admitSlots == nil {
+		return func() {}, true
+	}
+	if queueDepth.Load() > 0 {
+		return nil, false
+	}
+	select {
+	case admitSlots <- struct{}{}:
+		return func() { <-admitSlots }, true
+	default:
+		return nil, false
+	}
+}
+
+// Streams are capped separately (cfg.MaxStreams, 0 = unlimited): each
+// holds a goroutine for as long as the client keeps reading, cache hits
+// included, so slow readers can pile up even when the pipeline is idle.
+var (
+	activeStreams   atomic.Int64
+	streamsRejected atomic.Int64
+)
+
+// openStream claims a streaming slot; ok is false when the cap is reached.
+func openStream() (done func(), ok bool) {
+	if n := activeStreams.Add(1); cfg.MaxStreams > 0 && n > int64(cfg.MaxStreams) {
+		activeStreams.Add(-1)
+		streamsRejected.Add(1)
+		return nil, false
+	}
+	return func() { activeStreams.Add(-1) }, true
+}
+
+// writeStreamsBusy turns a stream away with a 503 whose body is a single
+// NDJSON error line, so stream clients can parse it like any other error.
+func writeStreamsBusy(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_ = writeNDJSON(w, streamMsg{Type: "error", Text: fmt.Sprintf("too many open streams (limit %d), retry later", cfg.MaxStreams)})
+}
+
+func writeBusy(w http.ResponseWriter) {
+	retry := int(time.Duration(cfg.QueueWait).Seconds())
+	if retry < 1 {
+		retry = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	writeJSON(w, http.StatusServiceUnavailable, errResp{Error: "server busy, retry later"})
+}
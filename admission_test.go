@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdmitRejectsWhenQueueFull(t *testing.T) {
+	testConfig(t, func(c *config) {
+		c.MaxInFlight = 1
+		c.MaxQueue = 1
+		c.QueueWait = duration(time.Second)
+	})
+	old := admitSlots
+	initAdmission(cfg.MaxInFlight)
+	t.Cleanup(func() { admitSlots = old })
+
+	release, ok := admit(context.Background())
+	if !ok {
+		t.Fatal("first request should get the free slot")
+	}
+	waited := make(chan bool)
+	go func() {
+		r, ok := admit(context.Background())
+		if ok {
+			r()
+		}
+		waited <- ok
+	}()
+	for queueDepth.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	if _, ok := admit(context.Background()); ok {
+		t.Fatal("a request past max_queue should be turned away")
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Errorf("a full queue should reject at once, took %v", time.Since(start))
+	}
+
+	release()
+	if !<-waited {
+		t.Error("the queued request should get the slot once it is released")
+	}
+}
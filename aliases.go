@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"slices"
+)
+
+// -------------------- Request field aliases --------------------
+
+// Clients built against other LLM APIs send "input" or "query" for the
+// prompt and "model" where this API has "mode". field_aliases maps such
+// names onto AnswerRequest's canonical ones, for POST bodies and GET
+// queries alike; a canonical field that is present wins over its aliases.
+// Without it, two aliases of the same field are a 400: nothing says which
+// one the client meant.
+// The canonical fields are requestFieldNames (AnswerRequest's JSON tags):
+// prompt, mode, explain, judge, synthesize, n_final, ... See AnswerRequest.
+
+var (
+	requestFieldNames   = jsonFieldNames(AnswerRequest{})
+	defaultFieldAliases = map[string]string{"input": "prompt", "query": "prompt", "question": "prompt", "model": "mode"}
+)
+
+// fieldAliases is config's field_aliases. encoding/json would merge a
+// configured map into the defaults, leaving operators no way to drop one
+// (say "model", when a client sends that meaning a model); this decodes
+// into a fresh map so the configured set replaces them.
+type fieldAliases map[string]string
+
+func (a *fieldAliases) UnmarshalJSON(b []byte) error {
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	*a = m
+	return nil
+}
+
+func checkFieldAliases(aliases fieldAliases) error {
+	for alias, canon := range aliases {
+		if !slices.Contains(requestFieldNames, canon) {
+			return fmt.Errorf("field_aliases: %q maps to unknown field %q", alias, canon)
+		}
+		if slices.Contains(requestFieldNames, alias) {
+			return fmt.Errorf("field_aliases: %q is already a request field", alias)
+		}
+	}
+	return nil
+}
+
+var errAliasConflict = errors.New("conflicting field aliases")
+
+// aliasConflict reports a canonical field that has reports unset but two of
+// its aliases set. Aliases are checked in sorted order, so the error is
+// the same on every call.
+func aliasConflict(has func(string) bool) error {
+	seen := map[string]string{}
+	for _, alias := range slices.Sorted(maps.Keys(cfg.FieldAliases)) {
+		canon := cfg.FieldAliases[alias]
+		if !has(alias) || has(canon) {
+			continue
+		}
+		if other, ok := seen[canon]; ok {
+			return fmt.Errorf("%w: %q and %q both set %s; send one", errAliasConflict, other, alias, canon)
+		}
+		seen[canon] = alias
+	}
+	return nil
+}
+
+// decodeError is the 400 message for a body decodeAnswerRequest rejected.
+func decodeError(err error) string {
+	if errors.Is(err, errAliasConflict) {
+		return err.Error()
+	}
+	return "bad json"
+}
+
+// decodeAnswerRequest decodes a POST body into req, renaming aliased fields.
+func decodeAnswerRequest(body io.Reader, req *AnswerRequest) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if len(cfg.FieldAliases) > 0 {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+		if err := aliasConflict(func(k string) bool { _, ok := fields[k]; return ok }); err != nil {
+			return err
+		}
+		renamed := false
+		for alias, canon := range cfg.FieldAliases {
+			if v, ok := fields[alias]; ok {
+				if _, set := fields[canon]; !set {
+					fields[canon] = v
+				}
+				delete(fields, alias)
+				renamed = true
+			}
+		}
+		if renamed {
+			if data, err = json.Marshal(fields); err != nil {
+				return err
+			}
+		}
+	}
+	return json.NewDecoder(bytes.NewReader(data)).Decode(req)
+}
+
+// aliasQuery renames aliased GET parameters in q.
+func aliasQuery(q url.Values) error {
+	if err := aliasConflict(q.Has); err != nil {
+		return err
+	}
+	for alias, canon := range cfg.FieldAliases {
+		if q.Has(alias) && !q.Has(canon) {
+			q.Set(canon, q.Get(alias))
+		}
+	}
+	return nil
+}
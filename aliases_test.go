@@ -0,0 +1,120 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDefaultFieldAliases(t *testing.T) {
+	testConfig(t, nil)
+	for _, tc := range []struct {
+		alias string
+		check func(AnswerRequest) string
+	}{
+		{"input", func(r AnswerRequest) string { return r.Prompt }},
+		{"query", func(r AnswerRequest) string { return r.Prompt }},
+		{"question", func(r AnswerRequest) string { return r.Prompt }},
+		{"model", func(r AnswerRequest) string { return r.Mode }},
+	} {
+		var req AnswerRequest
+		if err := decodeAnswerRequest(strings.NewReader(`{"`+tc.alias+`":"fast"}`), &req); err != nil {
+			t.Fatalf("%s: %v", tc.alias, err)
+		}
+		if got := tc.check(req); got != "fast" {
+			t.Errorf("body %s: got %q, want it on the canonical field", tc.alias, got)
+		}
+
+		q := url.Values{tc.alias: {"fast"}}
+		aliasQuery(q)
+		if got := q.Get(cfg.FieldAliases[tc.alias]); got != "fast" {
+			t.Errorf("query %s: got %q", tc.alias, got)
+		}
+	}
+}
+
+func TestCanonicalFieldWinsOverAlias(t *testing.T) {
+	testConfig(t, nil)
+	var req AnswerRequest
+	if err := decodeAnswerRequest(strings.NewReader(`{"prompt":"real","input":"alias"}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Prompt != "real" {
+		t.Errorf("prompt %q, want the canonical field's", req.Prompt)
+	}
+	q := url.Values{"prompt": {"real"}, "query": {"alias"}}
+	aliasQuery(q)
+	if q.Get("prompt") != "real" {
+		t.Errorf("query prompt %q", q.Get("prompt"))
+	}
+}
+
+func TestConfiguredFieldAliasesReplaceTheDefaults(t *testing.T) {
+	c, err := loadTestConfig(t, `{"field_aliases":{"q":"prompt"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(c.FieldAliases, fieldAliases{"q": "prompt"}) {
+		t.Fatalf("field_aliases %v, want only the configured one", c.FieldAliases)
+	}
+	testConfig(t, func(tc *config) { tc.FieldAliases = c.FieldAliases })
+	var req AnswerRequest
+	if err := decodeAnswerRequest(strings.NewReader(`{"q":"hi","model":"llama3.2"}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Prompt != "hi" || req.Mode != "" {
+		t.Errorf("got prompt %q mode %q, want model no longer aliased", req.Prompt, req.Mode)
+	}
+
+	for _, js := range []string{`{"field_aliases":{}}`, `{"field_aliases":null}`} {
+		c, err := loadTestConfig(t, js)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.FieldAliases) != 0 {
+			t.Errorf("%s left %v", js, c.FieldAliases)
+		}
+	}
+	if c, _ := loadTestConfig(t, `{}`); !maps.Equal(c.FieldAliases, fieldAliases(defaultFieldAliases)) {
+		t.Errorf("unset field_aliases gave %v, want the defaults", c.FieldAliases)
+	}
+}
+
+func TestFieldAliasesAreChecked(t *testing.T) {
+	for js, want := range map[string]string{
+		`{"field_aliases":{"q":"nope"}}`:      "unknown field",
+		`{"field_aliases":{"mode":"prompt"}}`: "already a request field",
+	} {
+		if _, err := loadTestConfig(t, js); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", js, err, want)
+		}
+	}
+}
+
+func TestConflictingFieldAliasesAreRejected(t *testing.T) {
+	testConfig(t, nil)
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/answer", strings.NewReader(`{"input":"one","query":"two"}`)),
+		httptest.NewRequest(http.MethodGet, "/answer?input=one&question=two", nil),
+	} {
+		rec := httptest.NewRecorder()
+		handleAnswer(rec, r)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "both set prompt") {
+			t.Errorf("%s: %d %s, want a 400 naming the conflict", r.Method, rec.Code, rec.Body)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handleAnswerStream(rec, httptest.NewRequest(http.MethodPost, "/answer/stream", strings.NewReader(`{"question":"one","query":"two"}`)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `\"query\" and \"question\" both set prompt`) {
+		t.Errorf("stream: %d %s, want a 400 naming the conflict", rec.Code, rec.Body)
+	}
+
+	// the canonical field settles it
+	var req AnswerRequest
+	if err := decodeAnswerRequest(strings.NewReader(`{"prompt":"real","input":"one","query":"two"}`), &req); err != nil || req.Prompt != "real" {
+		t.Errorf("got %q, %v; want the canonical prompt", req.Prompt, err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -------------------- Audit log --------------------
+//
+// With audit_log set, every answered request appends one JSON line to it
+// recording how the answer was reached. It is separate from the process log
+// and holds no answer text unless audit_include_text is on. Requests that
+// fail outright (4xx, 502, 504) are not audited, nor are background cache
+// refreshes.
+
+// audit paths: how Final was chosen
+const (
+	auditCache       = "cache"
+	auditFastPick    = "fast_pick"  // no judge ran (fast path, judge off or failed, single candidate)
+	auditJudgePick   = "judge_pick" // top judged candidate: synthesis off, skipped, or fell back
+	auditSynthesis   = "synthesis"
+	auditUnavailable = "unavailable" // no model answered; no_answer_message
+	auditRefused     = "refused"     // every model refused; no Final
+)
+
+type auditRecord struct {
+	Time       time.Time    `json:"time"`
+	RequestID  string       `json:"request_id,omitempty"`
+	Endpoint   string       `json:"endpoint"`
+	Mode       string       `json:"mode"`
+	Providers  []string     `json:"providers"`
+	Candidates int          `json:"candidates"`
+	Scores     []judgeScore `json:"scores,omitempty"`
+	Path       string       `json:"path"`
+	Source     string       `json:"source"`
+	Degraded   bool         `json:"degraded,omitempty"`
+	Notes      []string     `json:"notes,omitempty"`
+	TotalMs    int64        `json:"total_ms"`
+	Prompt     string       `json:"prompt,omitempty"` // audit_include_text only
+	Final      string       `json:"final,omitempty"`  // audit_include_text only
+}
+
+var (
+	auditMu  sync.Mutex
+	auditOut io.Writer // nil = off
+)
+
+// setupAudit opens cfg.AuditLog: "stderr", "stdout", or a file appended to.
+func setupAudit() error {
+	switch cfg.AuditLog {
+	case "":
+		return nil
+	case "stderr":
+		auditOut = os.Stderr
+	case "stdout":
+		auditOut = os.Stdout
+	default:
+		f, err := os.OpenFile(cfg.AuditLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		auditOut = f
+	}
+	log.Printf("audit log: %s", cfg.AuditLog)
+	return nil
+}
+
+// checkAuditFields rejects audit_fields that aren't auditRecord fields.
+func checkAuditFields(fields []string) error {
+	known := jsonFieldNames(auditRecord{})
+	for _, f := range fields {
+		if !slices.Contains(known, f) {
+			return fmt.Errorf("audit_fields: unknown field %q (known: %s)", f, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+func auditPath(resp AnswerResponse) string {
+	switch resp.Source {
+	case sourceCache:
+		return auditCache
+	case sourceSynthesis:
+		return auditSynthesis
+	case sourceUnavailable:
+		return auditUnavailable
+	case sourceRefused:
+		return auditRefused
+	}
+	if len(resp.scores) > 0 {
+		return auditJudgePick
+	}
+	return auditFastPick
+}
+
+// audit writes resp's decision trail, trimmed to cfg.AuditFields if set.
+func audit(r *http.Request, req AnswerRequest, resp AnswerResponse, start time.Time) {
+	if auditOut == nil || isRefresh(r.Context()) {
+		return
+	}
+	rec := auditRecord{
+		Time:       time.Now().UTC(),
+		RequestID:  r.Header.Get("X-Request-ID"),
+		Endpoint:   r.URL.Path,
+		Mode:       resp.Mode,
+		Candidates: len(resp.Candidates),
+		Scores:     judgeScores(resp.Candidates, resp.scores),
+		Path:       auditPath(resp),
+		Source:     resp.Source,
+		Degraded:   resp.Degraded,
+		Notes:      resp.Notes,
+		TotalMs:    time.Since(start).Milliseconds(),
+	}
+	for _, c := range resp.Candidates {
+		rec.Providers = append(rec.Providers, c.Provider)
+	}
+	if cfg.AuditIncludeText {
+		rec.Prompt, rec.Final = req.Prompt, resp.Final
+	}
+	b, err := json.Marshal(selectFields(rec, cfg.AuditFields))
+	if err != nil {
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if _, err := auditOut.Write(append(b, '\n')); err != nil {
+		log.Printf("audit log write: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// -------------------- Synthesis bake-off --------------------
+
+// maxBakeoffSubsets bounds synth_bakeoff: every subset is a full synthesis
+// pass, and all of them run at once. The first runs on the request's own
+// admission slot, each further one only on a spare slot (bakeoffSlots).
+const maxBakeoffSubsets = 3
+
+// bakeoffSubsets is the judge's top K candidates for each size in
+// cfg.SynthBakeoff, clamped to the candidates there are and without
+// repeats; nil unless that leaves at least two to compare.
+func bakeoffSubsets(cands []Candidate, scores []scored) [][]Candidate {
+	var out [][]Candidate
+	seen := map[int]bool{}
+	for _, k := range cfg.SynthBakeoff {
+		k = min(k, len(scores))
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		top := make([]Candidate, k)
+		for i := range top {
+			top[i] = cands[scores[i].Idx]
+		}
+		out = append(out, top)
+	}
+	if len(out) < 2 {
+		return nil
+	}
+	return out
+}
+
+// synthesizeBest is synthesizeTop over top, or with subsets a bake-off:
+// each subset is synthesized concurrently and the judge compares the
+// results pairwise, the winner of each round staying on. A comparison that
+// fails or runs out of time keeps the current leader. It returns the
+// winning subset (top without a bake-off) and a note naming the winner. spec
+// goes to the first pair, the only subset it can match. err is the first
+// subset's error, and only when every synthesis failed.
+func synthesizeBest(ctx context.Context, g Generator, model string, req AnswerRequest, top []Candidate, subsets [][]Candidate, scores []scored, spec *speculation) (won []Candidate, text, raw, note string, err error) {
+	if subsets != nil {
+		var release func()
+		subsets, release = bakeoffSlots(subsets)
+		defer release()
+		if len(subsets) < 2 {
+			subsets, note = nil, "synthesis bake-off skipped: no spare capacity"
+		}
+	}
+	if subsets == nil {
+		text, raw, err = synthesizeTop(ctx, g, model, req, top, scores, spec)
+		return top, text, raw, note, err
+	}
+	type result struct {
+		text, raw string
+		err       error
+	}
+	res := make([]result, len(subsets))
+	var wg sync.WaitGroup
+	for i, sub := range subsets {
+		s := (*speculation)(nil)
+		if len(sub) == 2 && spec != nil {
+			s, spec = spec, nil
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res[i].text, res[i].raw, res[i].err = synthesizeTop(ctx, g, model, req, sub, scores, s)
+		}()
+	}
+	spec.discard() // no pair to adopt it
+	wg.Wait()
+
+	best := -1
+	var labels []string
+	for i, r := range res {
+		labels = append(labels, fmt.Sprintf("top %d", len(subsets[i])))
+		if r.err != nil {
+			log.Printf("bake-off: %s synthesis failed: %v", labels[i], r.err)
+			continue
+		}
+		if best < 0 {
+			best = i
+			continue
+		}
+		if ctx.Err() != nil {
+			continue
+		}
+		a := Candidate{Provider: labels[best], Text: res[best].text}
+		b := Candidate{Provider: labels[i], Text: r.text}
+		if firstWon, err := compareCandidates(ctx, g, req.judgeModel(), req.groundedPrompt(), a, b); err != nil {
+			log.Printf("bake-off: comparing %s and %s failed: %v; keeping %s", a.Provider, b.Provider, err, a.Provider)
+		} else if !firstWon {
+			best = i
+		}
+	}
+	if best < 0 {
+		return subsets[0], "", res[0].raw, "", res[0].err
+	}
+	return subsets[best], res[best].text, res[best].raw, fmt.Sprintf("synthesis bake-off (%s): %s won", strings.Join(labels, ", "), labels[best]), nil
+}
+
+// bakeoffSlots keeps subsets[0], which runs on the request's own admission
+// slot, and every further subset tryAdmit finds a spare slot for; the rest
+// are left out. release gives the slots back.
+func bakeoffSlots(subsets [][]Candidate) (kept [][]Candidate, release func()) {
+	kept = [][]Candidate{subsets[0]}
+	var releases []func()
+	for _, sub := range subsets[1:] {
+		r, ok := tryAdmit()
+		if !ok {
+			log.Printf("bake-off: no spare admission slot; skipping top %d", len(sub))
+			continue
+		}
+		releases = append(releases, r)
+		kept = append(kept, sub)
+	}
+	return kept, func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// bakeoffRun answers a quality request with synth_bakeoff [1, 2, 3] and
+// max_in_flight slots, returning the response and how many syntheses ran.
+func bakeoffRun(t *testing.T, maxInFlight int) (AnswerResponse, int) {
+	t.Helper()
+	testConfig(t, func(c *config) {
+		c.SynthBakeoff = []int{1, 2, 3}
+		c.MaxInFlight = maxInFlight
+	})
+	old := admitSlots
+	admitSlots = nil
+	initAdmission(cfg.MaxInFlight)
+	t.Cleanup(func() { admitSlots = old })
+	g := ensemble(
+		map[string]string{"llama3.2": "Paris is the capital of France.", "qwen2.5": "The capital is Paris.", "mistral": "Paris."},
+		map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 5},
+		"Paris is the capital of France.",
+	)
+	useGenerator(t, g)
+
+	_, resp := postAnswer(t, handleAnswer, `{"prompt":"capital of France?","mode":"quality"}`)
+	if len(admitSlots) != 0 {
+		t.Errorf("%d admission slots still taken after the request", len(admitSlots))
+	}
+	return resp, len(g.prompts("Combine the best parts"))
+}
+
+func TestBakeoffRunsEverySubsetWithCapacity(t *testing.T) {
+	for _, limit := range []int{0, 3} {
+		resp, n := bakeoffRun(t, limit)
+		if n != 3 || !slices.ContainsFunc(resp.Notes, func(s string) bool { return strings.HasPrefix(s, "synthesis bake-off (top 1, top 2, top 3)") }) {
+			t.Errorf("max_in_flight %d: %d syntheses, notes %q", limit, n, resp.Notes)
+		}
+	}
+}
+
+func TestBakeoffIsCappedBySpareSlots(t *testing.T) {
+	resp, n := bakeoffRun(t, 2)
+	if n != 2 || !slices.ContainsFunc(resp.Notes, func(s string) bool { return strings.HasPrefix(s, "synthesis bake-off (top 1, top 2)") }) {
+		t.Errorf("%d syntheses, notes %q; want the two subsets there were slots for", n, resp.Notes)
+	}
+
+	resp, n = bakeoffRun(t, 1)
+	if n != 1 || !slices.Contains(resp.Notes, "synthesis bake-off skipped: no spare capacity") {
+		t.Errorf("%d syntheses, notes %q; want a single synthesis", n, resp.Notes)
+	}
+	if resp.Source != sourceSynthesis {
+		t.Errorf("source %q, want the plain synthesis", resp.Source)
+	}
+}
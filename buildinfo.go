@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// -------------------- Build info --------------------
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.buildVersion=v1.4.0 -X main.buildCommit=$(git rev-parse --short HEAD)"
+var (
+	buildVersion = "dev"
+	buildCommit  = "unknown"
+)
+
+var startTime = time.Now()
+
+type versionInfo struct {
+	Version   string        `json:"version"`
+	Commit    string        `json:"commit"`
+	GoVersion string        `json:"go_version"`
+	UptimeS   int64         `json:"uptime_s"`
+	Config    configSummary `json:"config"`
+}
+
+// configSummary is the non-secret shape of the running config.
+type configSummary struct {
+	Modes         map[string]modeSummary `json:"modes"`
+	CacheBackend  string                 `json:"cache_backend"`
+	JudgeStrategy string                 `json:"judge_strategy"`
+	MaxInFlight   int                    `json:"max_in_flight"`
+}
+
+type modeSummary struct {
+	Providers []string `json:"providers"`
+	Timeout   string   `json:"timeout"`
+	CacheTTL  string   `json:"cache_ttl"`
+	Disabled  bool     `json:"disabled,omitempty"`
+}
+
+func summarizeConfig(c config) configSummary {
+	s := configSummary{
+		Modes:         map[string]modeSummary{},
+		CacheBackend:  "memory",
+		JudgeStrategy: c.JudgeStrategy,
+		MaxInFlight:   c.MaxInFlight,
+	}
+	for name, m := range c.Modes {
+		ms := modeSummary{Timeout: time.Duration(m.Timeout).String(), CacheTTL: time.Duration(m.CacheTTL).String(), Disabled: m.Disabled}
+		for _, p := range m.Providers {
+			ms.Providers = append(ms.Providers, p.displayName())
+		}
+		s.Modes[name] = ms
+	}
+	return s
+}
+
+// handleVersion reports what is running, for incident triage across instances.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, versionInfo{
+		Version:   buildVersion,
+		Commit:    buildCommit,
+		GoVersion: runtime.Version(),
+		UptimeS:   int64(time.Since(startTime).Seconds()),
+		Config:    summarizeConfig(cfg),
+	})
+}
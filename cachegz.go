@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// -------------------- Compressed cache entries --------------------
+//
+// With compress_cache, entries are stored as gzipped JSON. Candidate texts
+// overlap each other and the final answer a lot: three ~1.5KB candidates
+// plus a synthesis (6KB of text) stored in 1.6KB, about a quarter of the
+// size, for roughly a millisecond on cacheSet and 0.2ms on cacheGet.
+
+// gzEntry is the JSON a compressed entry holds. encoding/json only sees
+// exported fields, so the unexported ones a cache hit still relies on (the
+// judge ranking behind include_scores, the fallback flag, the candidates'
+// bookkeeping) are carried next to the response.
+type gzEntry struct {
+	Response   AnswerResponse `json:"response"`
+	Scores     []scored       `json:"scores,omitempty"`
+	Fallback   bool           `json:"fallback,omitempty"`
+	Candidates []gzCandidate  `json:"candidates,omitempty"` // by index into Response.Candidates
+}
+
+// gzCandidate holds a Candidate's unexported fields.
+type gzCandidate struct {
+	Raw             string          `json:"raw,omitempty"`
+	PromptTruncated bool            `json:"prompt_truncated,omitempty"`
+	Model           string          `json:"model,omitempty"`
+	JudgeSelf       bool            `json:"judge_self,omitempty"`
+	JudgeSkip       string          `json:"judge_skip,omitempty"`
+	Group           string          `json:"group,omitempty"`
+	AltModel        bool            `json:"alt_model,omitempty"`
+	Priority        int             `json:"priority,omitempty"`
+	Refusal         bool            `json:"refusal,omitempty"`
+	OllamaRaw       json.RawMessage `json:"ollama_raw,omitempty"`
+	SelfRating      *float64        `json:"self_rating,omitempty"`
+}
+
+func gzipResponse(v AnswerResponse) ([]byte, error) {
+	e := gzEntry{Response: v, Scores: v.scores, Fallback: v.fallback}
+	for _, c := range v.Candidates {
+		e.Candidates = append(e.Candidates, gzCandidate{
+			Raw: c.raw, PromptTruncated: c.promptTruncated, Model: c.model, JudgeSelf: c.judgeSelf,
+			JudgeSkip: c.judgeSkip, Group: c.group, AltModel: c.altModel, Priority: c.priority,
+			Refusal: c.refusal, OllamaRaw: c.ollamaRaw, SelfRating: c.selfRating,
+		})
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(e); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipResponse(b []byte) (AnswerResponse, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return AnswerResponse{}, err
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return AnswerResponse{}, err
+	}
+	var e gzEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return AnswerResponse{}, err
+	}
+	v := e.Response
+	v.scores, v.fallback = e.Scores, e.Fallback
+	for i, c := range e.Candidates {
+		if i >= len(v.Candidates) {
+			break
+		}
+		vc := &v.Candidates[i]
+		vc.raw, vc.promptTruncated, vc.model, vc.judgeSelf = c.Raw, c.PromptTruncated, c.Model, c.JudgeSelf
+		vc.judgeSkip, vc.group, vc.altModel, vc.priority = c.JudgeSkip, c.Group, c.AltModel, c.Priority
+		vc.refusal, vc.ollamaRaw, vc.selfRating = c.Refusal, c.OllamaRaw, c.SelfRating
+	}
+	return v, nil
+}
+
+// With cache_compress_workers, the cache writer stores the entry
+// uncompressed, so a racing identical request hits it straight away, and
+// queues it here; a worker swaps in the gzipped form unless the entry was
+// replaced meanwhile. With the queue full the writer compresses it inline
+// instead.
+
+type compressJob struct {
+	key    string
+	stored time.Time
+}
+
+var compressQueue chan compressJob
+
+func startCacheCompressors() {
+	if !cfg.CompressCache || cfg.CacheCompressWorkers == 0 {
+		return
+	}
+	compressQueue = make(chan compressJob, 256)
+	for range cfg.CacheCompressWorkers {
+		go func() {
+			for job := range compressQueue {
+				compressEntry(job)
+			}
+		}()
+	}
+}
+
+// queueCompress hands a stored entry to the workers, compressing it on the
+// caller's goroutine when they're backed up.
+func queueCompress(key string, stored time.Time) {
+	select {
+	case compressQueue <- compressJob{key, stored}:
+	default:
+		compressEntry(compressJob{key, stored})
+	}
+}
+
+func compressEntry(job compressJob) {
+	cacheMu.RLock()
+	it, ok := cacheMap[job.key]
+	cacheMu.RUnlock()
+	if !ok || it.gz != nil || !it.stored.Equal(job.stored) {
+		return
+	}
+	gz, err := gzipResponse(it.val)
+	if err != nil {
+		return
+	}
+	cacheMu.Lock()
+	if cur, ok := cacheMap[job.key]; ok && cur.gz == nil && cur.stored.Equal(job.stored) {
+		cacheMap[job.key] = cacheItem{gz: gz, exp: cur.exp, stored: cur.stored, seq: cur.seq}
+	}
+	cacheMu.Unlock()
+}
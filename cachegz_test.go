@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestGzipResponseKeepsUnexportedFields(t *testing.T) {
+	rating := 0.8
+	v := AnswerResponse{
+		Final:    "Paris.",
+		Mode:     "quality",
+		Source:   "llama3.2",
+		scores:   []scored{{Idx: 1, Score: 9, Notes: "best"}, {Idx: 0, Score: 4}},
+		fallback: true,
+		Candidates: []Candidate{
+			{Provider: "qwen2.5", Text: "Paris", raw: "<think>hm</think>Paris", model: "qwen2.5", judgeSkip: "too short", refusal: true},
+			{Provider: "llama3.2", Text: "Paris.", model: "llama3.1", altModel: true, priority: 2, group: "meta",
+				promptTruncated: true, judgeSelf: true, ollamaRaw: json.RawMessage(`{"done":true}`), selfRating: &rating},
+		},
+	}
+	gz, err := gzipResponse(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := gunzipResponse(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("round trip lost data:\n got %+v\nwant %+v", got, v)
+	}
+}
+
+func TestCompressedCacheHitKeepsScoresAndFallback(t *testing.T) {
+	testConfig(t, func(c *config) {
+		c.CompressCache = true
+		c.CacheCompressWorkers = 0
+	})
+	useGenerator(t, ensemble(
+		map[string]string{"llama3.2": "Paris.", "qwen2.5": "It is Paris.", "mistral": "Paris, France."},
+		map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 5},
+		"Paris is the capital of France.",
+	))
+
+	postAnswer(t, handleAnswer, `{"prompt":"capital?","mode":"quality"}`)
+	_, resp := postAnswer(t, handleAnswer, `{"prompt":"capital?","mode":"quality","include_scores":true}`)
+	if !resp.Cached || len(resp.Scores) != 3 || resp.Scores[0].Provider != "llama3.2" {
+		t.Errorf("compressed hit: cached=%v scores=%+v", resp.Cached, resp.Scores)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+)
+
+// -------------------- Asynchronous cache writes --------------------
+//
+// cacheSet doesn't store anything itself: it hands the entry to a single
+// writer goroutine (startCacheWriter), so the response doesn't wait on the
+// cache lock, or on gzip with compress_cache. Until the writer commits it
+// the entry sits in cachePending, where cacheGet looks first, so an
+// identical request racing right behind still hits.
+//
+// Writes are numbered as they are handed over, and an entry only replaces
+// one with a lower number: the last cacheSet for a key wins, even when a
+// full queue makes a write apply inline, ahead of older queued ones.
+// Without the writer (tests and tools that don't start it) writes apply
+// inline, in the same order.
+
+type cacheWrite struct {
+	key  string
+	it   cacheItem
+	done chan struct{} // a flush marker rather than a write, see flushCacheWrites
+}
+
+var (
+	pendingMu    sync.Mutex
+	cachePending = map[string]cacheItem{}
+	cacheSeq     uint64 // last number handed out, under pendingMu
+
+	cacheWrites     chan cacheWrite
+	cacheWriterOnce sync.Once
+)
+
+// startCacheWriter starts the goroutine that commits cache writes.
+func startCacheWriter() {
+	cacheWriterOnce.Do(func() {
+		ch := make(chan cacheWrite, 256)
+		go func() {
+			for w := range ch {
+				if w.done != nil {
+					close(w.done)
+					continue
+				}
+				applyCacheWrite(w)
+			}
+		}()
+		pendingMu.Lock()
+		cacheWrites = ch
+		pendingMu.Unlock()
+	})
+}
+
+// queueCacheWrite numbers it, makes it visible in cachePending and hands it
+// to the writer, applying it inline when there is none or it's backed up.
+func queueCacheWrite(key string, it cacheItem) {
+	pendingMu.Lock()
+	cacheSeq++
+	it.seq = cacheSeq
+	cachePending[key] = it
+	ch := cacheWrites
+	pendingMu.Unlock()
+
+	w := cacheWrite{key: key, it: it}
+	select {
+	case ch <- w:
+	default:
+		applyCacheWrite(w)
+	}
+}
+
+// applyCacheWrite commits w unless a newer write for its key got there
+// first, and clears its pending entry.
+func applyCacheWrite(w cacheWrite) {
+	it := w.it
+	background := cfg.CompressCache && compressQueue != nil
+	if cfg.CompressCache && !background {
+		if gz, err := gzipResponse(it.val); err == nil {
+			it = cacheItem{gz: gz, exp: it.exp, stored: it.stored, seq: it.seq}
+		}
+	}
+	cacheMu.Lock()
+	cur, ok := cacheMap[w.key]
+	stale := ok && cur.seq > it.seq
+	if !stale {
+		cacheMap[w.key] = it
+	}
+	cacheMu.Unlock()
+
+	pendingMu.Lock()
+	if p, ok := cachePending[w.key]; ok && p.seq <= it.seq {
+		delete(cachePending, w.key)
+	}
+	pendingMu.Unlock()
+
+	if background && !stale {
+		queueCompress(w.key, it.stored)
+	}
+}
+
+// pendingCacheItem returns a write for key the writer hasn't committed yet.
+func pendingCacheItem(key string) (cacheItem, bool) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	it, ok := cachePending[key]
+	return it, ok
+}
+
+// flushCacheWrites waits until the writes handed over so far are committed.
+func flushCacheWrites() {
+	pendingMu.Lock()
+	ch := cacheWrites
+	pendingMu.Unlock()
+	if ch == nil {
+		return
+	}
+	done := make(chan struct{})
+	ch <- cacheWrite{done: done}
+	<-done
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheWritesKeepTheirOrder(t *testing.T) {
+	testConfig(t, nil)
+	startCacheWriter()
+
+	const writes = 500
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			last := -1
+			for range writes {
+				v, ok := cacheGet("k", nil)
+				if !ok {
+					continue
+				}
+				n, _ := strconv.Atoi(v.Final)
+				if n < last {
+					t.Errorf("read %d after %d: a newer write was undone", n, last)
+					return
+				}
+				last = n
+			}
+		}()
+	}
+	for i := range writes {
+		cacheSet("k", AnswerResponse{Final: strconv.Itoa(i)}, time.Minute)
+	}
+	wg.Wait()
+	flushCacheWrites()
+
+	cacheMu.RLock()
+	got := cacheMap["k"].val.Final
+	cacheMu.RUnlock()
+	if got != strconv.Itoa(writes-1) {
+		t.Errorf("committed %q, want the last write", got)
+	}
+	if _, ok := pendingCacheItem("k"); ok {
+		t.Error("a committed write is still pending")
+	}
+}
+
+func TestCacheWriteNeverReplacesANewerOne(t *testing.T) {
+	testConfig(t, nil)
+	now := time.Now()
+	applyCacheWrite(cacheWrite{key: "k", it: cacheItem{val: AnswerResponse{Final: "new"}, exp: now.Add(time.Minute), stored: now, seq: 2}})
+	applyCacheWrite(cacheWrite{key: "k", it: cacheItem{val: AnswerResponse{Final: "old"}, exp: now.Add(time.Minute), stored: now, seq: 1}})
+	if v, _ := cacheGet("k", nil); v.Final != "new" {
+		t.Errorf("got %q, want the newer write to stay", v.Final)
+	}
+}
+
+func TestRacingIdenticalRequestsHitPendingWrites(t *testing.T) {
+	testConfig(t, nil)
+	startCacheWriter()
+	useGenerator(t, ensemble(
+		map[string]string{"llama3.2": "Paris.", "qwen2.5": "It is Paris.", "mistral": "Paris, France."},
+		map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 5},
+		"Paris is the capital of France.",
+	))
+
+	postAnswer(t, handleAnswer, `{"prompt":"capital?","mode":"quality"}`)
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, resp := postAnswer(t, handleAnswer, `{"prompt":"capital?","mode":"quality"}`); !resp.Cached {
+				t.Error("a request right behind the first missed the cache")
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// -------------------- Candidate cache --------------------
+//
+// With candidate_cache_ttl set, each provider's raw answer is cached by
+// model, options and rendered prompt, below the answer cache. The same
+// prompt run again under another mode, judge or synthesis setting (which
+// all miss the answer cache) then reuses candidates instead of
+// regenerating them. Session-context requests bypass it: their answers
+// depend on the conversation so far.
+
+type candidateEntry struct {
+	raw       string
+	latencyMs int64 // of the original generation, so latency ordering holds
+	exp       time.Time
+}
+
+var (
+	candMu    sync.Mutex
+	candCache = map[string]candidateEntry{}
+)
+
+func candidateKey(model, prompt string, o genOptions) string {
+	opts, _ := json.Marshal(struct {
+		Options map[string]any
+		Format  json.RawMessage
+		Images  []string
+	}{o.Options, o.Format, o.Images})
+	h := sha256.New()
+	h.Write([]byte(model + "\n"))
+	h.Write(opts)
+	h.Write([]byte("\n" + prompt))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func candidateGet(key string) (candidateEntry, bool) {
+	candMu.Lock()
+	defer candMu.Unlock()
+	e, ok := candCache[key]
+	if !ok || time.Now().After(e.exp) {
+		delete(candCache, key)
+		return candidateEntry{}, false
+	}
+	return e, true
+}
+
+// candidateSet stores a successful raw answer, dropping expired entries.
+func candidateSet(key, raw string, latencyMs int64) {
+	now := time.Now()
+	candMu.Lock()
+	defer candMu.Unlock()
+	for k, e := range candCache {
+		if now.After(e.exp) {
+			delete(candCache, k)
+		}
+	}
+	candCache[key] = candidateEntry{raw: raw, latencyMs: latencyMs, exp: now.Add(time.Duration(cfg.CandidateCacheTTL))}
+}
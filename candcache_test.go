@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCandidateCacheHitsAreCountedPerProvider(t *testing.T) {
+	testConfig(t, func(c *config) { c.CandidateCacheTTL = duration(time.Minute) })
+	candMu.Lock()
+	old := candCache
+	candCache = map[string]candidateEntry{}
+	candMu.Unlock()
+	t.Cleanup(func() {
+		candMu.Lock()
+		candCache = old
+		candMu.Unlock()
+	})
+	g := ensemble(map[string]string{"llama3.2": "Paris.", "qwen2.5": "Paris."}, nil, "Paris.")
+	useGenerator(t, g)
+
+	counts := func() (answers, hits int64) {
+		providerCounts.Lock()
+		defer providerCounts.Unlock()
+		return providerCounts.answers["qwen2.5"], providerCounts.cacheHits["qwen2.5"]
+	}
+	postAnswer(t, handleAnswer, `{"prompt":"capital of France?","mode":"fast"}`)
+	answers, hits := counts()
+	calls := len(g.prompts("capital of France?"))
+
+	// another judge setting misses the answer cache but not the candidate one
+	if _, resp := postAnswer(t, handleAnswer, `{"prompt":"capital of France?","mode":"fast","judge":false}`); resp.Cached {
+		t.Fatal("the second request hit the answer cache")
+	}
+	if len(g.prompts("capital of France?")) != calls {
+		t.Fatal("candidates were generated again")
+	}
+	if a, h := counts(); a != answers || h != hits+1 {
+		t.Errorf("answers %d -> %d, cache hits %d -> %d; want one cache hit and no new answer", answers, a, hits, h)
+	}
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `llm_provider_cache_hits_total{provider="qwen2.5"}`) {
+		t.Error("no llm_provider_cache_hits_total series for qwen2.5 in /metrics")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// -------------------- Returned candidate cap --------------------
+
+// candidateCap is how many candidates the response may list: the request's
+// max_candidates, else cfg.MaxCandidates; 0 lists them all.
+func (r AnswerRequest) candidateCap() int {
+	if r.MaxCandidates > 0 {
+		return r.MaxCandidates
+	}
+	return cfg.MaxCandidates
+}
+
+// capCandidates trims resp.Candidates to n for the client, keeping the
+// candidate Final came from and then the best-scored (or, unjudged, the
+// fastest) ones, in their original order. Judging, synthesis, the cache and
+// the debug trace all work from the full set; this only shapes the output.
+func capCandidates(resp AnswerResponse, n int) AnswerResponse {
+	total := len(resp.Candidates)
+	if n <= 0 || total <= n {
+		return resp
+	}
+	order := make([]int, 0, total)
+	for _, s := range resp.scores {
+		if s.Idx < total && !slices.Contains(order, s.Idx) {
+			order = append(order, s.Idx)
+		}
+	}
+	for i := range resp.Candidates {
+		if !slices.Contains(order, i) {
+			order = append(order, i)
+		}
+	}
+	if src := slices.IndexFunc(resp.Candidates, func(c Candidate) bool { return c.Provider == resp.Source }); src >= 0 {
+		order = slices.DeleteFunc(order, func(i int) bool { return i == src })
+		order = slices.Insert(order, 0, src)
+	}
+	keep := order[:n]
+	slices.Sort(keep)
+	kept := make([]Candidate, 0, n)
+	for _, i := range keep {
+		kept = append(kept, resp.Candidates[i])
+	}
+	resp.Candidates = kept
+	resp.Notes = append(slices.Clip(resp.Notes), fmt.Sprintf("listing %d of %d candidates", n, total))
+	return resp
+}
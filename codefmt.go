@@ -0,0 +1,33 @@
+package main
+
+import "strings"
+
+// -------------------- Code formatting --------------------
+
+// codeFenceRule is added to candidate and synthesis prompts for requests
+// with code_formatting set.
+const codeFenceRule = "Put all code in triple-backtick fences tagged with its language (e.g. ```go), and close every fence.\n"
+
+func (r AnswerRequest) codeRule() string {
+	if !r.CodeFormatting {
+		return ""
+	}
+	return codeFenceRule
+}
+
+// fencesOK reports whether every code fence in s is closed and every
+// opening fence names a language.
+func fencesOK(s string) bool {
+	open := false
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "```") {
+			continue
+		}
+		if !open && strings.TrimSpace(strings.TrimLeft(line, "`")) == "" {
+			return false
+		}
+		open = !open
+	}
+	return !open
+}
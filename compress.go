@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// -------------------- Prompt compression --------------------
+
+// compressPrompt shortens prompts over cfg.CompressPromptChars with one
+// call to cfg.CompressModel, so long inputs fit small-context providers and
+// cost less across candidates, judge and synthesis. The cache key is taken
+// from the original prompt before this runs. A failed or unhelpful pass
+// leaves the prompt as it was, and so does one Ollama stopped at a length
+// limit, since the rewrite would silently lose the end of the request. The
+// note is for the response; empty when the prompt wasn't compressed.
+func compressPrompt(ctx context.Context, g Generator, req AnswerRequest) (AnswerRequest, string) {
+	n := len([]rune(req.Prompt))
+	if cfg.CompressModel == "" || cfg.CompressPromptChars <= 0 || n <= cfg.CompressPromptChars {
+		return req, ""
+	}
+	var done struct {
+		Reason string `json:"done_reason"`
+	}
+	o := compressOptions()
+	o.OnRaw = func(m json.RawMessage) { _ = json.Unmarshal(m, &done) }
+	out, err := g.Generate(ctx, cfg.CompressModel, compressionPrompt(req.Prompt), o)
+	out = strings.TrimSpace(stripReasoning(out))
+	if err == nil && done.Reason == "length" {
+		log.Printf("prompt compression with %s was cut off at its length limit; using the full prompt", cfg.CompressModel)
+		return req, ""
+	}
+	if err != nil || out == "" || len([]rune(out)) >= n {
+		log.Printf("prompt compression with %s gave nothing shorter (err=%v); using the full prompt", cfg.CompressModel, err)
+		return req, ""
+	}
+	req.Prompt = out
+	return req, fmt.Sprintf("prompt compressed from %d to %d chars by %s", n, len([]rune(out)), cfg.CompressModel)
+}
+
+// compressOptions lifts any num_predict cap (-1 is unlimited to Ollama):
+// synth_num_predict is sized for answers, not for a rewrite of a long prompt.
+func compressOptions() genOptions {
+	return genOptions{KeepAlive: cfg.KeepAlive, Options: map[string]any{"num_predict": -1}}
+}
+
+func compressionPrompt(prompt string) string {
+	return "Rewrite the request below as briefly as possible for another assistant to answer. Keep every question, " +
+		"instruction, constraint, name, number and code snippet; drop repetition and filler. Do not answer it. " +
+		"Reply with the rewritten request only.\n\nRequest:\n" + prompt
+}
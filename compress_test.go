@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// compressServer stands in for Ollama, answering every generate call with
+// response and doneReason and keeping the options it was sent.
+func compressServer(t *testing.T, response, doneReason string) *map[string]any {
+	t.Helper()
+	var opts map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body ollamaGenerateReq
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		opts = body.Options
+		_ = json.NewEncoder(w).Encode(map[string]any{"response": response, "done": true, "done_reason": doneReason})
+	}))
+	t.Cleanup(srv.Close)
+	old := ollamaGenerateURL
+	ollamaGenerateURL = srv.URL + "/api/generate"
+	t.Cleanup(func() { ollamaGenerateURL = old })
+	return &opts
+}
+
+func compressConfig(c *config) {
+	c.CompressModel = "small"
+	c.CompressPromptChars = 50
+	c.SynthNumPredict = 10
+}
+
+func TestCompressPromptIgnoresSynthNumPredict(t *testing.T) {
+	testConfig(t, compressConfig)
+	opts := compressServer(t, "Summarize the report.", "stop")
+
+	req := AnswerRequest{Prompt: strings.Repeat("Please summarize the attached report for me. ", 5)}
+	got, note := compressPrompt(context.Background(), ollamaClient{}, req)
+	if got.Prompt != "Summarize the report." || note == "" {
+		t.Fatalf("got %q with note %q, want the rewrite", got.Prompt, note)
+	}
+	if n, _ := (*opts)["num_predict"].(float64); n != -1 {
+		t.Errorf("num_predict %v, want -1 (no cap)", (*opts)["num_predict"])
+	}
+}
+
+func TestCompressPromptRejectsCutOffRewrite(t *testing.T) {
+	testConfig(t, compressConfig)
+	compressServer(t, "Summarize the attached", "length")
+
+	req := AnswerRequest{Prompt: strings.Repeat("Please summarize the attached report for me. ", 5)}
+	got, note := compressPrompt(context.Background(), ollamaClient{}, req)
+	if got.Prompt != req.Prompt || note != "" {
+		t.Errorf("got %q with note %q, want the full prompt", got.Prompt, note)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// -------------------- Confidence --------------------
+
+// agreement is the mean pairwise word-set overlap (Jaccard) between
+// candidates, in [0,1]. It's a cheap proxy for whether the models are saying
+// the same thing; with fewer than two candidates it is 1.
+func agreement(cands []Candidate) float64 {
+	if len(cands) < 2 {
+		return 1
+	}
+	sets := make([]map[string]struct{}, len(cands))
+	for i, c := range cands {
+		sets[i] = wordSet(c.Text)
+	}
+	var sum float64
+	var pairs int
+	for i := range sets {
+		for j := i + 1; j < len(sets); j++ {
+			sum += jaccard(sets[i], sets[j])
+			pairs++
+		}
+	}
+	return sum / float64(pairs)
+}
+
+func wordSet(s string) map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		set[w] = struct{}{}
+	}
+	return set
+}
+
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	inter := 0
+	for w := range a {
+		if _, ok := b[w]; ok {
+			inter++
+		}
+	}
+	return float64(inter) / float64(len(a)+len(b)-inter)
+}
+
+// confidence combines the top judge score (when judging ran) and candidate
+// agreement into [0,1] by taking the weaker of the two signals.
+func confidence(resp AnswerResponse) float64 {
+	c := agreement(resp.Candidates)
+	if len(resp.scores) > 0 {
+		c = min(c, float64(resp.scores[0].Score)/10)
+	}
+	return min(max(c, 0), 1)
+}
+
+// cache_decision values: whether a fresh answer was cached, or why not
+const (
+	cacheStored        = "cached"
+	cacheSkipTTL       = "no_ttl"        // the mode's cache_ttl (or its confidence-scaled share) is 0
+	cacheSkipRefused   = "refused"       // every model refused
+	cacheSkipEscalated = "needs_human"   // the confidence gate escalated it
+	cacheSkipAgreement = "low_agreement" // candidates agree less than cache_min_agreement
+	cacheSkipFallback  = "fallback"      // a fallback or degraded answer, without cache_fallbacks
+	cacheSkipUnjudged  = "unjudged"      // no judge ran, without cache_unjudged
+	cacheSkipScore     = "low_score"     // the top judge score is under cache_min_score
+)
+
+// answerTTL is the mode's TTL, scaled down for low-confidence answers when
+// ConfidenceTTL is on. It never drops below ConfidenceTTLFloor of base, but
+// is 0 (don't cache) for answers below CacheMinScore.
+func answerTTL(resp AnswerResponse, base time.Duration) time.Duration {
+	if cacheSkip(resp) != "" {
+		return 0
+	}
+	if !cfg.ConfidenceTTL {
+		return base
+	}
+	f := max(confidence(resp), cfg.ConfidenceTTLFloor)
+	return time.Duration(f * float64(base))
+}
+
+// cacheSkip is why resp must not be cached, or "" if it may be. It applies
+// CacheMinScore: a judged answer needs a top score of at least that much to
+// be cached, and an unjudged one (fast path, judge failed or disabled) is
+// cached only with CacheUnjudged. An answer every model refused is never
+// cached, nor one whose candidates agree less than CacheMinAgreement, nor
+// (without CacheFallbacks) a fallback or degraded one, nor an escalation
+// from the confidence gate.
+func cacheSkip(resp AnswerResponse) string {
+	_, low := lowAgreement(resp)
+	switch {
+	case resp.Refused:
+		return cacheSkipRefused
+	case resp.NeedsHuman:
+		return cacheSkipEscalated
+	case low:
+		return cacheSkipAgreement
+	case uncachedFallback(resp):
+		return cacheSkipFallback
+	case cfg.CacheMinScore <= 0:
+		return ""
+	case len(resp.scores) == 0:
+		if cfg.CacheUnjudged {
+			return ""
+		}
+		return cacheSkipUnjudged
+	case resp.scores[0].Score < cfg.CacheMinScore:
+		return cacheSkipScore
+	}
+	return ""
+}
+
+// cacheDecision is resp's cache_decision given the TTL answerTTL chose.
+func cacheDecision(resp AnswerResponse, ttl time.Duration) string {
+	if ttl > 0 {
+		return cacheStored
+	}
+	if why := cacheSkip(resp); why != "" {
+		return why
+	}
+	return cacheSkipTTL
+}
+
+// lowAgreement reports the candidates' agreement and whether it is under
+// CacheMinAgreement, which keeps the answer out of the cache.
+func lowAgreement(resp AnswerResponse) (float64, bool) {
+	a := agreement(resp.Candidates)
+	return a, cfg.CacheMinAgreement > 0 && a < cfg.CacheMinAgreement
+}
+
+// uncachedFallback reports whether CacheFallbacks keeps resp out of the
+// cache: it came from a fallback (judge failed or skipped, synthesis failed,
+// stalled or regressed) or from fewer providers than the mode requires.
+func uncachedFallback(resp AnswerResponse) bool {
+	return !cfg.CacheFallbacks && (resp.fallback || resp.Degraded)
+}
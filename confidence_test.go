@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAgreementAndCacheDecisionAreReported(t *testing.T) {
+	testConfig(t, func(c *config) { c.CacheMinAgreement = 0.5 })
+	useGenerator(t, ensemble(map[string]string{"llama3.2": "Paris is the capital.", "qwen2.5": "Paris is the capital."}, nil, "Paris."))
+
+	body := `{"prompt":"capital of France?","mode":"fast"}`
+	_, resp := postAnswer(t, handleAnswer, body)
+	if resp.Agreement == nil || *resp.Agreement != 1 || resp.CacheDecision != cacheStored {
+		t.Fatalf("agreement %v decision %q, want 1 and cached", resp.Agreement, resp.CacheDecision)
+	}
+	if _, hit := postAnswer(t, handleAnswer, body); !hit.Cached || hit.CacheDecision != cacheStored || hit.Agreement == nil {
+		t.Errorf("hit: cached=%v decision %q agreement %v", hit.Cached, hit.CacheDecision, hit.Agreement)
+	}
+}
+
+func TestLowAgreementIsReportedAsNotCached(t *testing.T) {
+	testConfig(t, func(c *config) { c.CacheMinAgreement = 0.5 })
+	useGenerator(t, ensemble(map[string]string{"llama3.2": "Paris is the capital.", "qwen2.5": "Lyon, surely."}, nil, "Paris."))
+
+	body := `{"prompt":"capital of France?","mode":"fast"}`
+	_, resp := postAnswer(t, handleAnswer, body)
+	if resp.Agreement == nil || *resp.Agreement >= 0.5 || resp.CacheDecision != cacheSkipAgreement {
+		t.Fatalf("agreement %v decision %q, want under 0.5 and low_agreement", resp.Agreement, resp.CacheDecision)
+	}
+	if _, again := postAnswer(t, handleAnswer, body); again.Cached {
+		t.Error("a low-agreement answer was cached")
+	}
+}
+
+func TestCacheDecisionReasons(t *testing.T) {
+	testConfig(t, func(c *config) {
+		c.CacheMinScore = 7
+		c.CacheUnjudged = false
+		c.CacheFallbacks = false
+	})
+	judged := func(score int) AnswerResponse { return AnswerResponse{scores: []scored{{Score: score}}} }
+	for _, tc := range []struct {
+		name string
+		resp AnswerResponse
+		want string
+	}{
+		{"good score", judged(8), ""},
+		{"low score", judged(5), cacheSkipScore},
+		{"unjudged", AnswerResponse{}, cacheSkipUnjudged},
+		{"escalated", AnswerResponse{NeedsHuman: true}, cacheSkipEscalated},
+		{"fallback", AnswerResponse{fallback: true, scores: []scored{{Score: 9}}}, cacheSkipFallback},
+	} {
+		if got := cacheSkip(tc.resp); got != tc.want {
+			t.Errorf("%s: cacheSkip = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+	if got := cacheDecision(judged(8), 0); got != cacheSkipTTL {
+		t.Errorf("zero TTL: %q", got)
+	}
+}
+
+func TestAgreementNeedsVersion13(t *testing.T) {
+	a := 0.4
+	resp := AnswerResponse{Agreement: &a, CacheDecision: cacheSkipAgreement}
+	for version, want := range map[int]bool{12: false, 13: true} {
+		b, _ := json.Marshal(shapeResponse(resp, version))
+		if got := strings.Contains(string(b), `"agreement"`) && strings.Contains(string(b), `"cache_decision"`); got != want {
+			t.Errorf("version %d: %s", version, b)
+		}
+	}
+}
+
+func TestCacheFallbacksPerPath(t *testing.T) {
+	answers := map[string]string{"llama3.2": "Paris is the capital of France.", "qwen2.5": "The capital of France is Paris.", "mistral": "Paris, the capital of France."}
+	scores := map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 6}
+	paths := []struct {
+		name     string
+		fallback bool // a fallback or degraded answer
+		gen      func() *fakeGenerator
+	}{
+		{"synthesis", false, func() *fakeGenerator { return ensemble(answers, scores, "Paris is the capital of France.") }},
+		{"judge failed", true, func() *fakeGenerator {
+			g := ensemble(answers, scores, "Paris is the capital of France.")
+			answer := g.respond
+			g.respond = func(model, prompt string) (string, error) {
+				if strings.HasPrefix(prompt, "You are a strict evaluator.") {
+					return "no idea", nil
+				}
+				return answer(model, prompt)
+			}
+			return g
+		}},
+		{"synthesis failed", true, func() *fakeGenerator {
+			g := ensemble(answers, scores, "")
+			answer := g.respond
+			g.respond = func(model, prompt string) (string, error) {
+				if strings.HasPrefix(prompt, "Combine the best parts") {
+					return "", errors.New("synthesis model is down")
+				}
+				return answer(model, prompt)
+			}
+			return g
+		}},
+		{"degraded", true, func() *fakeGenerator {
+			return ensemble(map[string]string{"llama3.2": answers["llama3.2"], "qwen2.5": answers["qwen2.5"]}, scores, "Paris is the capital of France.")
+		}},
+	}
+	for _, cacheFallbacks := range []bool{true, false} {
+		for _, p := range paths {
+			for _, stream := range []bool{false, true} {
+				testConfig(t, func(c *config) { c.CacheFallbacks = cacheFallbacks })
+				useGenerator(t, p.gen())
+				name := fmt.Sprintf("%s (cache_fallbacks %v, stream %v)", p.name, cacheFallbacks, stream)
+
+				body := `{"prompt":"capital of France?","mode":"quality"}`
+				var first AnswerResponse
+				if stream {
+					msgs := postStream(t, body)
+					b, _ := json.Marshal(msgs[len(msgs)-1].Meta)
+					_ = json.Unmarshal(b, &first)
+				} else {
+					_, first = postAnswer(t, handleAnswer, body)
+				}
+				if first.Cached || first.Final == "" {
+					t.Fatalf("%s: first answer %+v", name, first)
+				}
+				want := cacheFallbacks || !p.fallback
+				wantDecision := cacheStored
+				if !want {
+					wantDecision = cacheSkipFallback
+				}
+				if first.CacheDecision != wantDecision {
+					t.Errorf("%s: cache_decision %q, want %q", name, first.CacheDecision, wantDecision)
+				}
+				if _, second := postAnswer(t, handleAnswer, body); second.Cached != want {
+					t.Errorf("%s: repeat cached=%v, want %v", name, second.Cached, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,944 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -------------------- Config --------------------
+
+// config is loaded once at startup from the JSON file named by CONFIG_FILE,
+// with environment variables taking precedence over the file (see applyEnv).
+// Every field is optional; anything left out keeps its default.
+type config struct {
+	// Modes holds the provider ensemble, timeout, and cache TTL for "fast"
+	// and "quality". Fields left out of a mode keep their defaults.
+	Modes map[string]modeConfig `json:"modes"`
+
+	// ProviderLabels gives unnamed providers a clean name by model, e.g.
+	// {"llama3.2:latest": "llama"}, used in candidates, the judge prompt and
+	// the per-provider metrics. Providers with a name keep it; others
+	// default to the model string. At most maxProviderLabels distinct
+	// provider names are allowed across modes. See labels.go.
+	ProviderLabels map[string]string `json:"provider_labels"`
+
+	// ReasoningTags lists tag pairs (e.g. <think>...</think>) whose contents
+	// are stripped from candidates before judging and from the final answer.
+	// Empty disables stripping.
+	ReasoningTags []tagPair `json:"reasoning_tags"`
+
+	// MaxInFlight caps how many uncached requests run the pipeline at once
+	// (0 = unlimited). Requests over the cap wait up to QueueWait for a slot,
+	// then get a 503 with Retry-After. At most MaxQueue (default 64, 0 =
+	// unbounded) wait at once; past that they get the 503 straight away.
+	MaxInFlight int      `json:"max_in_flight"`
+	QueueWait   duration `json:"queue_wait"`
+	MaxQueue    int      `json:"max_queue"`
+
+	// HealthProbeInterval, when set, probes every configured model with a
+	// one-token generation at that interval. Providers whose model failed
+	// its last probe are skipped by fan-out (unless that would skip them all).
+	// Results are at /admin/providers. Off by default.
+	HealthProbeInterval duration `json:"health_probe_interval"`
+
+	// MaxStreams caps open /answer/stream connections, cache hits included
+	// (0 = unlimited). Streams over the cap get a 503 straight away.
+	MaxStreams int `json:"max_streams"`
+
+	// MaxRequestTimeout bounds the X-Timeout-Ms header clients can send to
+	// replace a mode's timeout (0 = no bound). Longer requests are clamped.
+	MaxRequestTimeout duration `json:"max_request_timeout"`
+
+	// DiagnosticsToken enables /admin/diagnostics (goroutines, in-flight
+	// requests, streams, cache size) for callers presenting it as a bearer
+	// token. Empty (the default) leaves the endpoint off.
+	DiagnosticsToken string `json:"diagnostics_token"`
+
+	// BasePath mounts every route under a prefix, e.g. "/llm" serves
+	// /llm/answer, /llm/metrics and /llm/admin/providers, for gateways that
+	// can't rewrite paths. Empty (the default) serves them at the root.
+	BasePath string `json:"base_path"`
+
+	// AuditLog records each answered request's decision trail (mode,
+	// providers, scores, path taken, source) as JSON lines: "stderr",
+	// "stdout", or a file path. Empty (the default) disables it.
+	// AuditFields limits each line to those fields; prompt and answer text
+	// are only included with AuditIncludeText. See audit.go.
+	AuditLog         string   `json:"audit_log"`
+	AuditFields      []string `json:"audit_fields"`
+	AuditIncludeText bool     `json:"audit_include_text"`
+
+	// SessionContext reuses each provider's Ollama context across requests
+	// with the same session_id, kept for SessionTTL after the last turn
+	// (default 30m). Clients then send only the new turn as the prompt; see
+	// sessions.go for when that's safe. At most MaxSessions contexts (one
+	// per session and provider) are kept; the least recently used go first.
+	SessionContext bool     `json:"session_context"`
+	SessionTTL     duration `json:"session_ttl"`
+	MaxSessions    int      `json:"max_sessions"`
+
+	// MissJitter delays each cache miss by a random amount up to this bound
+	// before admission, smoothing stampedes of identical uncached prompts.
+	// 0 (the default) disables it.
+	MissJitter duration `json:"miss_jitter"`
+
+	// ConfidenceTTL scales each mode's cache TTL by answer confidence (the
+	// lower of top judge score/10 and candidate agreement), never below
+	// ConfidenceTTLFloor of the TTL. Off by default: a flat TTL.
+	ConfidenceTTL      bool    `json:"confidence_ttl"`
+	ConfidenceTTLFloor float64 `json:"confidence_ttl_floor"`
+
+	// CacheMinScore (0-10) keeps answers whose top judge score is lower out
+	// of the cache, so the next identical request gets a fresh attempt; 0
+	// (the default) caches everything. Answers that were never judged (the
+	// fast path, or a failed or disabled judge) have no score: CacheUnjudged
+	// (default true) decides whether they are cached while a threshold is set.
+	CacheMinScore int  `json:"cache_min_score"`
+	CacheUnjudged bool `json:"cache_unjudged"`
+
+	// CacheMinAgreement (0-1) keeps answers out of the cache when the
+	// candidates behind them agree less than this (mean word-set overlap),
+	// with a note saying so; 0 (the default) caches regardless.
+	CacheMinAgreement float64 `json:"cache_min_agreement"`
+
+	// CacheFallbacks (default true) caches answers that fell back to a
+	// candidate because judging or synthesis failed, stalled, regressed or
+	// was skipped, and degraded ones. Off, they are served with a note but
+	// the next identical request gets a fresh attempt.
+	CacheFallbacks bool `json:"cache_fallbacks"`
+
+	// ConfidenceGate (0-1) asks every model to rate its own confidence and
+	// reports the answer's overall confidence; when that is below the gate
+	// the client gets EscalationMessage with needs_human instead of the
+	// answer (never cached). Streamed answers then arrive whole. 0 (the
+	// default) turns it off. See gate.go.
+	ConfidenceGate    float64 `json:"confidence_gate"`
+	EscalationMessage string  `json:"escalation_message"`
+
+	// CompressCache stores cache entries gzipped: much less memory for a
+	// little CPU on every set and hit. Off by default; see cachegz.go.
+	CompressCache bool `json:"compress_cache"`
+	// CacheCompressWorkers compresses new entries in the background (default
+	// 2) so a response isn't held up gzipping itself; 0 compresses inline.
+	CacheCompressWorkers int `json:"cache_compress_workers"`
+
+	// CandidateCacheTTL caches individual provider answers by model,
+	// options and prompt, so re-running a prompt under another mode or
+	// judge setting reuses them; 0 (the default) disables it. See
+	// candcache.go.
+	CandidateCacheTTL duration `json:"candidate_cache_ttl"`
+
+	// NormalizeOutput applies the whitespace normalization used for candidate
+	// comparisons to Final as well. Off by default so answers keep the
+	// model's formatting; streamed deltas are never rewritten.
+	NormalizeOutput bool `json:"normalize_output"`
+
+	// DedupeThreshold (0-1) removes a paragraph or sentence of Final whose
+	// word-pair overlap with the one just before it is at least this much, e.g.
+	// 0.9; lists, code and short text are left alone. 0 (the default) keeps
+	// the answer as generated. See dedupe.go.
+	DedupeThreshold float64 `json:"dedupe_threshold"`
+
+	// JudgeTemperature is sent to the judge with a fixed seed (default 0);
+	// a negative value leaves the model's own default. DeterministicSynth does
+	// the same for synthesis at temperature 0. Deterministic stages mean a
+	// fresh run picks the same winner the cache holds, so cache hits and
+	// misses stay consistent; with sampling on, a cached answer is just one
+	// draw among several plausible ones.
+	JudgeTemperature   float64 `json:"judge_temperature"`
+	DeterministicSynth bool    `json:"deterministic_synth"`
+
+	// JudgeStrategy is "absolute" (one call scoring every answer 0-10, the
+	// default) or "pairwise" (a round-robin of A-vs-B calls; steadier
+	// rankings for n*(n-1)/2 judge calls).
+	JudgeStrategy string `json:"judge_strategy"`
+
+	// JudgeSelf decides what happens when a candidate comes from the judge's
+	// own model: "allow" (the default) scores it anyway, with a startup
+	// warning; "exclude" leaves it out of judging so the judge never ranks
+	// its own answer. Either way the overlap is listed in the debug trace.
+	JudgeSelf string `json:"judge_self"`
+
+	// JudgePrefilter narrows the candidates the judge sees (fastest K,
+	// minimum length). Skipped ones can't win and are listed in the debug
+	// trace; see judgePrefilter.
+	JudgePrefilter judgePrefilter `json:"judge_prefilter"`
+
+	// MinCandidateChars drops candidates shorter than this from the
+	// ensemble altogether (unlike judge_prefilter's min_chars, they don't
+	// count towards min_providers either); if every answer is that short
+	// the longest is kept. 0 (the default) keeps everything non-empty.
+	MinCandidateChars int `json:"min_candidate_chars"`
+
+	// JudgeRepairModel, when set, gets one chance to rewrite malformed
+	// judge output as valid JSON before the request falls back to fastPick.
+	// A small fast model is enough; empty (the default) disables repair.
+	JudgeRepairModel string `json:"judge_repair_model"`
+
+	// JudgeMetadata adds each candidate's provider, latency and length to
+	// the judge prompt as a JSON meta line, marked informational, so the
+	// judge can spot e.g. a suspiciously short refusal. Off by default.
+	JudgeMetadata bool `json:"judge_metadata"`
+
+	// Personas are the voices a request can pick with "voice", by name;
+	// "friendly" and "formal" are predefined and "terse" is the built-in
+	// default. Entries here add to or replace the predefined ones.
+	Personas map[string]persona `json:"personas"`
+
+	// FieldAliases maps alternate request field names onto AnswerRequest's
+	// (alias -> canonical), for clients written against other LLM APIs.
+	// Defaults: input, query and question -> prompt; model -> mode. Setting
+	// it replaces the defaults rather than adding to them, so {} (or null)
+	// turns aliasing off. See aliases.go.
+	FieldAliases fieldAliases `json:"field_aliases"`
+
+	// MaxCandidates caps the candidates listed in a response, for big
+	// ensembles; requests can set their own with max_candidates. 0 (the
+	// default) lists all of them.
+	MaxCandidates int `json:"max_candidates"`
+
+	// FastVerifierModel, when set, gets a one-word "is this plausibly
+	// correct?" check on fast mode's unjudged answers; a "no" sends the
+	// request through the full judge path. A small model keeps it cheap.
+	FastVerifierModel string `json:"fast_verifier_model"`
+
+	// CompressModel rewrites prompts longer than CompressPromptChars into a
+	// shorter one before any provider sees it (the original still keys the
+	// cache), and the response notes it. Off unless both are set; a small,
+	// long-context model suits it. See compress.go.
+	CompressModel       string `json:"compress_model"`
+	CompressPromptChars int    `json:"compress_prompt_chars"`
+
+	// MaxContextChars bounds a request's context_docs in total; the
+	// lowest-priority (last) docs are dropped to fit. 0 means no bound.
+	MaxContextChars int `json:"max_context_chars"`
+
+	// LanguageCheck looks for candidates answering in a different language
+	// than most of the others, which makes for mixed-language syntheses:
+	// "ignore" (the default), "flag" (a note, and the debug trace), or
+	// "drop" (also removes them before judging).
+	LanguageCheck string `json:"language_check"`
+
+	// RefusalPatterns are phrases ("I can't help with") that mark an answer
+	// as a refusal when it opens with one, case-insensitively. Refusals are
+	// kept out of judging and fast-pick while any real answer exists; see
+	// refusal.go for the defaults. An empty list turns detection off.
+	RefusalPatterns []string `json:"refusal_patterns"`
+
+	// JudgeMinBudget skips the judge (falling back to fast-pick) when less
+	// than this fraction of the mode's timeout remains once candidates are in.
+	// 0 disables the check.
+	JudgeMinBudget float64 `json:"judge_min_budget"`
+
+	// SynthRankHints labels each answer in the synthesis prompt with its rank
+	// and judge score (on by default); SynthPreferTop additionally tells the
+	// synthesizer to side with the top answer when sources conflict.
+	SynthRankHints bool `json:"synth_rank_hints"`
+	SynthPreferTop bool `json:"synth_prefer_top"`
+
+	// SynthMinRatio rejects a synthesis shorter than this fraction of the top
+	// candidate it merged and returns that candidate instead (0 disables).
+	// With SynthRegressionRejudge, the judge must also prefer the candidate
+	// in a head-to-head comparison before the synthesis is discarded.
+	SynthMinRatio          float64 `json:"synth_min_ratio"`
+	SynthRegressionRejudge bool    `json:"synth_regression_rejudge"`
+
+	// SynthRetries is how many extra attempts a synthesis that errors or
+	// comes back empty gets (same prompt, within the deadline) before
+	// falling back to the best candidate.
+	SynthRetries int `json:"synth_retries"`
+
+	// PipelineRetries re-runs the fan-out when no model answered at all,
+	// e.g. through a brief Ollama restart, waiting PipelineRetryBackoff
+	// (doubled each time, default 500ms) first and never past the request
+	// deadline. 0 (the default) fails straight away.
+	PipelineRetries      int      `json:"pipeline_retries"`
+	PipelineRetryBackoff duration `json:"pipeline_retry_backoff"`
+
+	// SpeculativeSynth (quality mode, off by default) starts synthesizing the
+	// two longest candidates while the judge scores them. If the judge's top
+	// two are the same pair the running synthesis is used, saving the judge's
+	// latency; otherwise it is cancelled and synthesis starts over. The
+	// speculative prompt has no rank hints. Hit rate and time saved are on
+	// /metrics.
+	SpeculativeSynth bool `json:"speculative_synth"`
+
+	// SynthBakeoff lists top-K subset sizes (e.g. [2, 3]) to synthesize
+	// concurrently, the judge then picking the better merged answer; the
+	// response notes which won. Each subset is a full synthesis, so it is off
+	// (empty) by default, at most maxBakeoffSubsets, and skipped when the
+	// deadline is near. Past the first, each subset needs a spare admission
+	// slot (max_in_flight) and is left out without one. Streamed answers
+	// arrive whole. See bakeoff.go.
+	SynthBakeoff []int `json:"synth_bakeoff"`
+
+	// SynthNumPredict caps synthesis length in tokens (Ollama num_predict;
+	// 0 leaves the model default). StreamProgress sends a status message
+	// about once a second during streamed synthesis: a percentage of
+	// SynthNumPredict when set, otherwise a rough ETA from the token rate.
+	SynthNumPredict int  `json:"synth_num_predict"`
+	StreamProgress  bool `json:"stream_progress"`
+
+	// StreamDeltaGrouping regroups a streamed answer's deltas, which Ollama
+	// sends as sub-word token fragments, for clients that render them
+	// awkwardly: "token" (the default) passes them through, "word" and
+	// "sentence" hold each delta back until it ends on that boundary.
+	StreamDeltaGrouping string `json:"stream_delta_grouping"`
+
+	// SynthStallTimeout abandons a streamed synthesis that sends nothing for
+	// this long: the Ollama call is cancelled and the best judged candidate
+	// is streamed instead. 0 (the default) waits out the mode timeout.
+	SynthStallTimeout duration `json:"synth_stall_timeout"`
+
+	// SynthMaxInputChars bounds the synthesis prompt so verbose candidates
+	// don't overflow the synth model's context window. Lower-ranked answers
+	// are cut first and the top answer is always sent whole; 0 = no bound.
+	SynthMaxInputChars int `json:"synth_max_input_chars"`
+
+	// DisagreementThreshold is the candidate agreement (mean word-set
+	// overlap, 0-1) below which a surface_disagreement request's synthesis
+	// presents conflicting claims side by side. Default 0.25.
+	DisagreementThreshold float64 `json:"disagreement_threshold"`
+
+	// ValidateRetries is how many more syntheses a request's validate rules
+	// get after the first fails them (default 2).
+	ValidateRetries int `json:"validate_retries"`
+
+	// MaxAnswerChars is the largest max_answer_chars a request may ask for
+	// (0 = no server bound).
+	MaxAnswerChars int `json:"max_answer_chars"`
+
+	// KeepAlive is sent as Ollama's keep_alive on every generate call unless
+	// a provider sets its own: a duration string ("30s", "10m", "0" to unload
+	// right away) or a number of seconds, where a negative number keeps the
+	// model loaded indefinitely. Unset leaves Ollama's default of 5m.
+	KeepAlive any `json:"keep_alive"`
+
+	// OllamaHeaders are sent on every Ollama request; OllamaAPIKey, if set,
+	// goes out as "Authorization: Bearer <key>". Both are secrets and are
+	// redacted wherever the config is printed.
+	OllamaHeaders map[string]string `json:"ollama_headers"`
+	OllamaAPIKey  string            `json:"ollama_api_key"`
+
+	// AnswerPrefix and AnswerSuffix (e.g. a compliance disclaimer) are added
+	// verbatim to every final answer after synthesis and before caching;
+	// include any separating newlines yourself. Streaming sends them as the
+	// first and last deltas. Empty by default.
+	AnswerPrefix string `json:"answer_prefix"`
+	AnswerSuffix string `json:"answer_suffix"`
+
+	// NoAnswerMessage, if set, is returned as a normal degraded answer (source
+	// "unavailable", never cached) when no model responds, instead of a 502
+	// (or an error line on the stream).
+	NoAnswerMessage string `json:"no_answer_message"`
+
+	// Streamed cache hits are replayed in chunks of about CacheReplayChunk
+	// bytes, CacheReplayPacing apart. A chunk size of 0 sends one delta.
+	CacheReplayChunk  int      `json:"cache_replay_chunk"`
+	CacheReplayPacing duration `json:"cache_replay_pacing"`
+
+	// JudgeGuardrails fences untrusted text in the judge prompt and defuses
+	// judge-manipulation phrases (on by default). JudgeInjectionPatterns adds
+	// extra regular expressions to neutralize.
+	JudgeGuardrails        bool     `json:"judge_guardrails"`
+	JudgeInjectionPatterns []string `json:"judge_injection_patterns"`
+
+	// NormalizeCacheKey lowercases the prompt and collapses its whitespace
+	// before hashing, so "What is Go?" and "what  is go?" share a cache
+	// entry. Only the key is affected; models still see the prompt as sent.
+	// Off by default, since case can matter (code, proper nouns).
+	NormalizeCacheKey bool `json:"normalize_cache_key"`
+
+	// CacheScope is "global" (the default: identical prompts share answers
+	// across all callers) or "tenant", which keys the cache by the value of
+	// the CacheTenantHeader request header (default X-API-Key) so one tenant
+	// never gets an answer computed for another. Per-tenant caching lowers
+	// the hit rate roughly by the number of active tenants. Requests without
+	// the header share one anonymous bucket.
+	CacheScope        string `json:"cache_scope"`
+	CacheTenantHeader string `json:"cache_tenant_header"`
+
+	// RecordingMode "replay" answers every model call from RecordingsFile
+	// instead of Ollama (for demos and tests without a GPU); "record" calls
+	// Ollama as usual and appends each response to the file. Empty (the
+	// default) does neither. See recording for the file format.
+	RecordingMode  string `json:"recording_mode"`
+	RecordingsFile string `json:"recordings_file"`
+
+	// Requests may attach up to MaxImages images of at most MaxImageBytes
+	// each (decoded).
+	MaxImages     int `json:"max_images"`
+	MaxImageBytes int `json:"max_image_bytes"`
+
+	// DisabledMode decides what happens to a request for a disabled mode:
+	// "downgrade" (the default) runs it in the other mode, reported in the
+	// response's mode field; "reject" answers 400.
+	DisabledMode string `json:"disabled_mode"`
+
+	// Recorded stream transcripts live for TranscriptTTL; at most
+	// MaxTranscripts are retained.
+	TranscriptTTL  duration `json:"transcript_ttl"`
+	MaxTranscripts int      `json:"max_transcripts"`
+
+	judgeInjectionRe []*regexp.Regexp
+}
+
+type modeConfig struct {
+	Providers []provider `json:"providers"`
+	Timeout   duration   `json:"timeout"`
+	CacheTTL  duration   `json:"cache_ttl"`
+
+	// MinProviders is how many candidates the mode needs to keep its quality
+	// contract. When fewer answer, FallbackProviders are tried; if still
+	// short, the response is marked degraded.
+	MinProviders      int        `json:"min_providers"`
+	FallbackProviders []provider `json:"fallback_providers"`
+
+	// SampleSize > 0 sends each request to only that many of Providers,
+	// chosen by SampleStrategy: "random" (the default), "round_robin",
+	// "lru" (least recently picked first), or "weighted" (random, in
+	// proportion to each provider's weight). Over time every provider gets
+	// used without paying for all of them on every request. The cache key
+	// includes the providers drawn, so a repeated prompt only hits the cache
+	// when the same subset comes up again: with round_robin that happens
+	// once per cycle, with random rarely for large ensembles, and with
+	// weighted mostly for the subsets the heavy providers make up. Only
+	// requests that run the pipeline move round_robin and lru along; cache
+	// hits don't. It can't be below MinProviders.
+	SampleSize     int    `json:"sample_size"`
+	SampleStrategy string `json:"sample_strategy"`
+
+	// Disabled turns the mode off; see config.DisabledMode.
+	Disabled bool `json:"disabled"`
+
+	// Groups sets a selection policy per provider group, e.g. a tier of
+	// small models where only the best answer is kept ("best_of") next to
+	// large models that all feed synthesis ("all"). Providers without a
+	// group keep every answer, so a flat list works as before.
+	Groups map[string]providerGroup `json:"groups"`
+
+	// MaxTokens caps the tokens one request may generate across candidates,
+	// judge and synthesis (0 = no cap). Once spent, no new generation starts
+	// and the answer is built from what's done, flagged budget_exceeded.
+	MaxTokens int `json:"max_tokens"`
+
+	// RefreshProbability is the chance (0-1) that a cache hit also
+	// regenerates the answer in the background; 0 (the default) never does.
+	// See refresh.go.
+	RefreshProbability float64 `json:"refresh_probability"`
+}
+
+type tagPair struct {
+	Open  string `json:"open"`
+	Close string `json:"close"`
+}
+
+var cfg = defaultConfig()
+
+// disabled_mode values
+const (
+	disabledModeDowngrade = "downgrade"
+	disabledModeReject    = "reject"
+)
+
+const redactedValue = "[redacted]"
+
+func defaultConfig() config {
+	return config{
+		Modes: map[string]modeConfig{
+			"fast": {
+				Providers: []provider{
+					{Name: "llama3.2", Model: "llama3.2"},
+					{Name: "qwen2.5", Model: "qwen2.5"},
+				},
+				Timeout:  duration(45 * time.Second),
+				CacheTTL: duration(10 * time.Minute),
+			},
+			"quality": {
+				Providers: []provider{
+					{Name: "llama3.2", Model: "llama3.2"},
+					{Name: "qwen2.5", Model: "qwen2.5"},
+					{Name: "mistral", Model: "mistral"},
+				},
+				Timeout:      duration(120 * time.Second),
+				CacheTTL:     duration(30 * time.Minute),
+				MinProviders: 3,
+			},
+		},
+		QueueWait:          duration(2 * time.Second),
+		MaxQueue:           64,
+		MaxRequestTimeout:  duration(5 * time.Minute),
+		ConfidenceTTLFloor: 0.1,
+		CacheUnjudged:      true,
+		CacheFallbacks:     true,
+		EscalationMessage:  "I'm not sure enough to answer this one, so I'm passing it to a person who can help.",
+		MaxAnswerChars:     100000,
+
+		CacheReplayChunk:  48,
+		CacheReplayPacing: duration(10 * time.Millisecond),
+
+		JudgeStrategy:         judgeAbsolute,
+		JudgeSelf:             judgeSelfAllow,
+		LanguageCheck:         languageCheckIgnore,
+		StreamDeltaGrouping:   groupToken,
+		RefusalPatterns:       defaultRefusalPatterns,
+		JudgeMinBudget:        0.2,
+		SynthRankHints:        true,
+		SynthMinRatio:         0.3,
+		SynthRetries:          1,
+		PipelineRetryBackoff:  duration(500 * time.Millisecond),
+		ValidateRetries:       2,
+		DisagreementThreshold: 0.25,
+		CacheCompressWorkers:  2,
+		JudgeGuardrails:       true,
+
+		MaxImages:         4,
+		MaxContextChars:   16000,
+		MaxImageBytes:     10 << 20,
+		DisabledMode:      disabledModeDowngrade,
+		CacheScope:        cacheScopeGlobal,
+		CacheTenantHeader: "X-API-Key",
+
+		FieldAliases: maps.Clone(defaultFieldAliases),
+		Personas: map[string]persona{
+			"friendly": {Synthesis: "Write in a warm, conversational tone; explain jargon briefly and keep it easy to follow."},
+			"formal":   {Synthesis: "Write in a formal, precise register suitable for documentation; no colloquialisms."},
+		},
+
+		TranscriptTTL:  duration(10 * time.Minute),
+		SessionTTL:     duration(30 * time.Minute),
+		MaxSessions:    10000,
+		MaxTranscripts: 100,
+	}
+}
+
+// loadConfig builds the effective config: defaults, then the JSON file at
+// path (if any), then environment overrides (see applyEnv).
+func loadConfig(path string, getenv func(string) string) (config, error) {
+	c := defaultConfig()
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return c, err
+		}
+		if err := json.Unmarshal(b, &c); err != nil {
+			return c, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	if err := c.applyEnv(getenv); err != nil {
+		return c, fmt.Errorf("config env: %v", err)
+	}
+	if err := c.validate(); err != nil {
+		return c, fmt.Errorf("config: %v", err)
+	}
+	return c, nil
+}
+
+// applyEnv overrides the file config from the environment, for deployments
+// that can't mount a file:
+//
+//	PROVIDERS                            JSON provider array used by both modes
+//	FAST_PROVIDERS, QUALITY_PROVIDERS    JSON provider array for one mode (wins over PROVIDERS)
+//	FAST_TIMEOUT, QUALITY_TIMEOUT        duration, e.g. "45s"
+//	FAST_TTL, QUALITY_TTL                cache TTL duration
+//	MAX_IN_FLIGHT, QUEUE_WAIT, MAX_QUEUE admission queue
+//	MAX_STREAMS                          open stream cap
+//	MAX_REQUEST_TIMEOUT                  upper bound for X-Timeout-Ms
+//	DIAGNOSTICS_TOKEN                    bearer token for /admin/diagnostics
+//	BASE_PATH                            route prefix, e.g. "/llm"
+//	KEEP_ALIVE                           duration string or seconds
+//	JUDGE_STRATEGY                       "absolute" or "pairwise"
+//	CACHE_SCOPE                          "global" or "tenant"
+//	RECORDING_MODE, RECORDINGS_FILE      "record" or "replay", and the file
+//	OLLAMA_API_KEY                       bearer token for Ollama
+//	OLLAMA_HEADERS                       JSON object of extra headers for Ollama
+func (c *config) applyEnv(getenv func(string) string) error {
+	var shared []provider
+	if v := getenv("PROVIDERS"); v != "" {
+		if err := json.Unmarshal([]byte(v), &shared); err != nil {
+			return fmt.Errorf("PROVIDERS: %v", err)
+		}
+	}
+	for _, mode := range []string{"fast", "quality"} {
+		prefix := strings.ToUpper(mode) + "_"
+		m := c.Modes[mode]
+		if shared != nil {
+			m.Providers = shared
+		}
+		if v := getenv(prefix + "PROVIDERS"); v != "" {
+			m.Providers = nil
+			if err := json.Unmarshal([]byte(v), &m.Providers); err != nil {
+				return fmt.Errorf("%sPROVIDERS: %v", prefix, err)
+			}
+		}
+		if err := envDuration(getenv, prefix+"TIMEOUT", &m.Timeout); err != nil {
+			return err
+		}
+		if err := envDuration(getenv, prefix+"TTL", &m.CacheTTL); err != nil {
+			return err
+		}
+		c.Modes[mode] = m
+	}
+
+	if v := getenv("MAX_IN_FLIGHT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("MAX_IN_FLIGHT: %v", err)
+		}
+		c.MaxInFlight = n
+	}
+	if v := getenv("MAX_QUEUE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("MAX_QUEUE: %v", err)
+		}
+		c.MaxQueue = n
+	}
+	if v := getenv("MAX_STREAMS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("MAX_STREAMS: %v", err)
+		}
+		c.MaxStreams = n
+	}
+	if err := envDuration(getenv, "QUEUE_WAIT", &c.QueueWait); err != nil {
+		return err
+	}
+	if err := envDuration(getenv, "MAX_REQUEST_TIMEOUT", &c.MaxRequestTimeout); err != nil {
+		return err
+	}
+	if v := getenv("KEEP_ALIVE"); v != "" {
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			c.KeepAlive = n
+		} else {
+			c.KeepAlive = v
+		}
+	}
+	if v := getenv("DIAGNOSTICS_TOKEN"); v != "" {
+		c.DiagnosticsToken = v
+	}
+	if v := getenv("BASE_PATH"); v != "" {
+		c.BasePath = v
+	}
+	if v := getenv("JUDGE_STRATEGY"); v != "" {
+		c.JudgeStrategy = v
+	}
+	if v := getenv("RECORDING_MODE"); v != "" {
+		c.RecordingMode = v
+	}
+	if v := getenv("RECORDINGS_FILE"); v != "" {
+		c.RecordingsFile = v
+	}
+	if v := getenv("CACHE_SCOPE"); v != "" {
+		c.CacheScope = v
+	}
+	if v := getenv("OLLAMA_API_KEY"); v != "" {
+		c.OllamaAPIKey = v
+	}
+	if v := getenv("OLLAMA_HEADERS"); v != "" {
+		c.OllamaHeaders = nil
+		if err := json.Unmarshal([]byte(v), &c.OllamaHeaders); err != nil {
+			return fmt.Errorf("OLLAMA_HEADERS: %v", err)
+		}
+	}
+	return nil
+}
+
+func envDuration(getenv func(string) string, name string, dst *duration) error {
+	v := getenv(name)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	*dst = duration(d)
+	return nil
+}
+
+// validate checks the merged config and fills per-mode gaps from defaults.
+func (c *config) validate() error {
+	if c.BasePath = strings.TrimRight(c.BasePath, "/"); c.BasePath != "" && !strings.HasPrefix(c.BasePath, "/") {
+		return fmt.Errorf("base_path must start with /")
+	}
+	defaults := defaultConfig().Modes
+	for name, m := range c.Modes {
+		d, ok := defaults[name]
+		if !ok {
+			return fmt.Errorf("unknown mode %q (want fast or quality)", name)
+		}
+		if len(m.Providers) == 0 {
+			m.Providers = d.Providers
+		}
+		if m.Timeout <= 0 {
+			m.Timeout = d.Timeout
+		}
+		if m.CacheTTL <= 0 {
+			m.CacheTTL = d.CacheTTL
+		}
+		m.Providers = labelProviders(m.Providers, c.ProviderLabels)
+		m.FallbackProviders = labelProviders(m.FallbackProviders, c.ProviderLabels)
+		// candidates, judge indices and the UI all go by provider name
+		seen := map[string]bool{}
+		for _, p := range slices.Concat(m.Providers, m.FallbackProviders) {
+			if p.Model == "" {
+				return fmt.Errorf("mode %s has a provider without a model", name)
+			}
+			if seen[p.displayName()] {
+				return fmt.Errorf("mode %s lists provider %q more than once; give each a distinct name", name, p.displayName())
+			}
+			seen[p.displayName()] = true
+			if p.Weight < 0 {
+				return fmt.Errorf("mode %s: provider %q has a negative weight", name, p.displayName())
+			}
+			if err := checkKeepAlive(p.KeepAlive); err != nil {
+				return fmt.Errorf("provider %s: %v", p.displayName(), err)
+			}
+		}
+		switch m.SampleStrategy {
+		case "":
+			m.SampleStrategy = sampleRandom
+		case sampleRandom, sampleRoundRobin, sampleLRU, sampleWeighted:
+		default:
+			return fmt.Errorf("mode %s: sample_strategy must be %q, %q, %q, or %q", name, sampleRandom, sampleRoundRobin, sampleLRU, sampleWeighted)
+		}
+		if m.SampleSize < 0 {
+			return fmt.Errorf("mode %s: sample_size must be >= 0", name)
+		}
+		if m.SampleSize > 0 && m.SampleSize < m.MinProviders {
+			return fmt.Errorf("mode %s: sample_size %d is below min_providers %d, so every sampled request would be degraded", name, m.SampleSize, m.MinProviders)
+		}
+		if m.MaxTokens < 0 {
+			return fmt.Errorf("mode %s: max_tokens must be >= 0", name)
+		}
+		if m.RefreshProbability < 0 || m.RefreshProbability > 1 {
+			return fmt.Errorf("mode %s: refresh_probability must be between 0 and 1", name)
+		}
+		if err := checkGroups(name, m); err != nil {
+			return err
+		}
+		c.Modes[name] = m
+	}
+	if err := checkProviderLabels(c); err != nil {
+		return err
+	}
+	for _, t := range c.ReasoningTags {
+		if t.Open == "" || t.Close == "" {
+			return fmt.Errorf("reasoning_tags entries need both open and close")
+		}
+	}
+	if err := checkKeepAlive(c.KeepAlive); err != nil {
+		return err
+	}
+	c.judgeInjectionRe = nil
+	for _, p := range c.JudgeInjectionPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("judge_injection_patterns: %v", err)
+		}
+		c.judgeInjectionRe = append(c.judgeInjectionRe, re)
+	}
+	if c.JudgeStrategy != judgeAbsolute && c.JudgeStrategy != judgePairwise {
+		return fmt.Errorf("judge_strategy must be %q or %q", judgeAbsolute, judgePairwise)
+	}
+	if c.Modes["fast"].Disabled && c.Modes["quality"].Disabled {
+		return fmt.Errorf("at least one mode must stay enabled")
+	}
+	if c.DisabledMode != disabledModeDowngrade && c.DisabledMode != disabledModeReject {
+		return fmt.Errorf("disabled_mode must be %q or %q", disabledModeDowngrade, disabledModeReject)
+	}
+	if c.JudgeSelf != judgeSelfAllow && c.JudgeSelf != judgeSelfExclude {
+		return fmt.Errorf("judge_self must be %q or %q", judgeSelfAllow, judgeSelfExclude)
+	}
+	if c.JudgePrefilter.FastestK < 0 || c.JudgePrefilter.MinChars < 0 {
+		return fmt.Errorf("judge_prefilter values must be >= 0")
+	}
+	if c.CompressPromptChars < 0 {
+		return fmt.Errorf("compress_prompt_chars must be >= 0")
+	}
+	if c.MaxContextChars < 0 {
+		return fmt.Errorf("max_context_chars must be >= 0")
+	}
+	if c.MinCandidateChars < 0 {
+		return fmt.Errorf("min_candidate_chars must be >= 0")
+	}
+	switch c.LanguageCheck {
+	case languageCheckIgnore, languageCheckFlag, languageCheckDrop:
+	default:
+		return fmt.Errorf("language_check must be %q, %q, or %q", languageCheckIgnore, languageCheckFlag, languageCheckDrop)
+	}
+	switch c.StreamDeltaGrouping {
+	case groupToken, groupWord, groupSentence:
+	default:
+		return fmt.Errorf("stream_delta_grouping must be %q, %q, or %q", groupToken, groupWord, groupSentence)
+	}
+	if c.CacheScope != cacheScopeGlobal && c.CacheScope != cacheScopeTenant {
+		return fmt.Errorf("cache_scope must be %q or %q", cacheScopeGlobal, cacheScopeTenant)
+	}
+	if c.CacheScope == cacheScopeTenant && c.CacheTenantHeader == "" {
+		return fmt.Errorf("cache_scope %q needs cache_tenant_header", cacheScopeTenant)
+	}
+	switch c.RecordingMode {
+	case "":
+	case recordingRecord, recordingReplay:
+		if c.RecordingsFile == "" {
+			return fmt.Errorf("recording_mode %q needs recordings_file", c.RecordingMode)
+		}
+	default:
+		return fmt.Errorf("recording_mode must be %q, %q, or empty", recordingRecord, recordingReplay)
+	}
+	if c.MaxTranscripts < 1 {
+		return fmt.Errorf("max_transcripts must be >= 1")
+	}
+	if c.SynthNumPredict < 0 {
+		return fmt.Errorf("synth_num_predict must be >= 0")
+	}
+	if c.CacheMinScore < 0 || c.CacheMinScore > 10 {
+		return fmt.Errorf("cache_min_score must be between 0 and 10")
+	}
+	if c.PipelineRetries < 0 || c.PipelineRetryBackoff < 0 {
+		return fmt.Errorf("pipeline_retries and pipeline_retry_backoff must be >= 0")
+	}
+	if c.DedupeThreshold < 0 || c.DedupeThreshold > 1 {
+		return fmt.Errorf("dedupe_threshold must be between 0 and 1")
+	}
+	if c.MaxCandidates < 0 {
+		return fmt.Errorf("max_candidates must be >= 0")
+	}
+	if err := checkFieldAliases(c.FieldAliases); err != nil {
+		return err
+	}
+	if _, ok := c.Personas[personaTerse]; ok {
+		return fmt.Errorf("personas: %q is built in and can't be redefined", personaTerse)
+	}
+	for name, p := range c.Personas {
+		if strings.TrimSpace(p.Synthesis) == "" {
+			return fmt.Errorf("personas: %q needs a synthesis instruction", name)
+		}
+	}
+	if c.CacheMinAgreement < 0 || c.CacheMinAgreement > 1 {
+		return fmt.Errorf("cache_min_agreement must be between 0 and 1")
+	}
+	if c.SessionTTL <= 0 {
+		return fmt.Errorf("session_ttl must be > 0")
+	}
+	if c.MaxSessions < 1 {
+		return fmt.Errorf("max_sessions must be >= 1")
+	}
+	if err := checkAuditFields(c.AuditFields); err != nil {
+		return err
+	}
+	if c.CandidateCacheTTL < 0 {
+		return fmt.Errorf("candidate_cache_ttl must be >= 0")
+	}
+	if c.CacheCompressWorkers < 0 {
+		return fmt.Errorf("cache_compress_workers must be >= 0")
+	}
+	if c.ValidateRetries < 0 {
+		return fmt.Errorf("validate_retries must be >= 0")
+	}
+	if c.ConfidenceGate < 0 || c.ConfidenceGate > 1 {
+		return fmt.Errorf("confidence_gate must be between 0 and 1")
+	}
+	if c.ConfidenceGate > 0 && strings.TrimSpace(c.EscalationMessage) == "" {
+		return fmt.Errorf("confidence_gate needs an escalation_message")
+	}
+	if c.DisagreementThreshold < 0 || c.DisagreementThreshold > 1 {
+		return fmt.Errorf("disagreement_threshold must be between 0 and 1")
+	}
+	if c.SynthMaxInputChars < 0 {
+		return fmt.Errorf("synth_max_input_chars must be >= 0")
+	}
+	if len(c.SynthBakeoff) == 1 || len(c.SynthBakeoff) > maxBakeoffSubsets {
+		return fmt.Errorf("synth_bakeoff needs 2 to %d subset sizes", maxBakeoffSubsets)
+	}
+	for i, k := range c.SynthBakeoff {
+		if k < 1 || slices.Contains(c.SynthBakeoff[:i], k) {
+			return fmt.Errorf("synth_bakeoff sizes must be distinct and >= 1")
+		}
+	}
+	if c.SynthStallTimeout < 0 {
+		return fmt.Errorf("synth_stall_timeout must be >= 0")
+	}
+	if c.MaxInFlight < 0 {
+		return fmt.Errorf("max_in_flight must be >= 0")
+	}
+	if c.MaxQueue < 0 {
+		return fmt.Errorf("max_queue must be >= 0")
+	}
+	if c.MaxStreams < 0 {
+		return fmt.Errorf("max_streams must be >= 0")
+	}
+	if c.MaxRequestTimeout < 0 {
+		return fmt.Errorf("max_request_timeout must be >= 0")
+	}
+	return nil
+}
+
+// String renders the effective config as JSON for the startup log, with
+// secrets redacted.
+func (c config) String() string {
+	if c.OllamaAPIKey != "" {
+		c.OllamaAPIKey = redactedValue
+	}
+	if c.DiagnosticsToken != "" {
+		c.DiagnosticsToken = redactedValue
+	}
+	if len(c.OllamaHeaders) > 0 {
+		h := make(map[string]string, len(c.OllamaHeaders))
+		for k := range c.OllamaHeaders {
+			h[k] = redactedValue
+		}
+		c.OllamaHeaders = h
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("<unprintable config: %v>", err)
+	}
+	return string(b)
+}
+
+// duration reads Go duration strings ("1.5s", "2m") from JSON.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"2s\": %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func checkKeepAlive(v any) error {
+	switch v := v.(type) {
+	case nil, float64:
+		return nil
+	case string:
+		if v == "0" || v == "-1" {
+			return nil
+		}
+		if _, err := time.ParseDuration(v); err != nil {
+			return fmt.Errorf("keep_alive %q: %v", v, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("keep_alive must be a duration string or a number of seconds")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// loadTestConfig loads the defaults with js on top, as a config file would.
+func loadTestConfig(t *testing.T, js string) (config, error) {
+	t.Helper()
+	path := t.TempDir() + "/config.json"
+	if err := os.WriteFile(path, []byte(js), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return loadConfig(path, func(string) string { return "" })
+}
+
+func TestValidateRejectsSampleBelowMinProviders(t *testing.T) {
+	_, err := loadTestConfig(t, `{"modes":{"quality":{"providers":[{"model":"a"},{"model":"b"},{"model":"c"},{"model":"d"}],"min_providers":3,"sample_size":2}}}`)
+	if err == nil || !strings.Contains(err.Error(), "sample_size") {
+		t.Fatalf("err = %v, want sample_size below min_providers rejected", err)
+	}
+	if _, err := loadTestConfig(t, `{"modes":{"quality":{"providers":[{"model":"a"},{"model":"b"},{"model":"c"},{"model":"d"}],"min_providers":3,"sample_size":3}}}`); err != nil {
+		t.Fatalf("sample_size equal to min_providers: %v", err)
+	}
+}
+
+func TestValidateRejectsDuplicateProviderNames(t *testing.T) {
+	tests := []struct{ name, js string }{
+		{"same model twice", `{"modes":{"fast":{"providers":[{"model":"a"},{"model":"a"}]}}}`},
+		{"name clashes with a model", `{"modes":{"fast":{"providers":[{"model":"a"},{"model":"b","name":"a"}]}}}`},
+		{"fallback repeats a provider", `{"modes":{"fast":{"providers":[{"model":"a"},{"model":"b"}],"fallback_providers":[{"model":"b"}]}}}`},
+		{"labels collide", `{"provider_labels":{"a:latest":"a"},"modes":{"fast":{"providers":[{"model":"a"},{"model":"a:latest"}]}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadTestConfig(t, tt.js); err == nil || !strings.Contains(err.Error(), "more than once") {
+				t.Fatalf("err = %v, want a duplicate provider error", err)
+			}
+		})
+	}
+
+	// distinct names for the same model are the self-ensemble setup
+	if _, err := loadTestConfig(t, `{"modes":{"fast":{"providers":[{"model":"a","name":"a-cold"},{"model":"a","name":"a-hot"}]}}}`); err != nil {
+		t.Fatalf("named copies of one model: %v", err)
+	}
+}
+
+func TestValidateRejectsDuplicateProviderNamesFromEnv(t *testing.T) {
+	env := map[string]string{"FAST_PROVIDERS": `[{"model":"a"},{"model":"b"},{"model":"a"}]`}
+	_, err := loadConfig("", func(k string) string { return env[k] })
+	if err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Fatalf("err = %v, want a duplicate provider error", err)
+	}
+}
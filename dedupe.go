@@ -0,0 +1,109 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// -------------------- Repeated paragraph removal --------------------
+
+// Synthesis sometimes says the same paragraph twice in a row when merging
+// similar candidates. dedupeRepeats drops a paragraph, or a sentence within
+// one, that nearly repeats the one just before it. It errs towards keeping
+// text: only prose of at least dedupeMinWords words is compared, list items
+// and code are never touched, and both overlap and length must be close.
+// Overlap is of word bigrams rather than words, so word order counts: "A
+// beats B" after "B beats A" says something new.
+
+const (
+	dedupeMinWords = 8
+	dedupeMinRatio = 0.8 // shorter/longer length of a repeat
+)
+
+// sentenceEnd splits prose after ., ! or ? followed by a space.
+var sentenceEnd = regexp.MustCompile(`[.!?]\s+`)
+
+func dedupeRepeats(text string, threshold float64) string {
+	paras := strings.Split(text, "\n\n")
+	out := paras[:0]
+	fenced := false
+	prev := ""
+	for _, p := range paras {
+		code := fenced || strings.Contains(p, "```")
+		if strings.Count(p, "```")%2 == 1 {
+			fenced = !fenced
+		}
+		if code || hasListItem(p) { // list items are often alike on purpose
+			out = append(out, p)
+			prev = ""
+			continue
+		}
+		p = dedupeSentences(p, threshold)
+		if prev != "" && nearRepeat(prev, p, threshold) {
+			continue
+		}
+		out = append(out, p)
+		prev = p
+	}
+	return strings.Join(out, "\n\n")
+}
+
+// dedupeSentences drops sentences that nearly repeat the one before them.
+func dedupeSentences(para string, threshold float64) string {
+	if strings.Contains(para, "\n") {
+		return para // line breaks are formatting (a table, a poem); leave it be
+	}
+	var kept []string
+	prev := ""
+	rest := para
+	for rest != "" {
+		s := rest
+		if loc := sentenceEnd.FindStringIndex(rest); loc != nil {
+			s, rest = rest[:loc[1]], rest[loc[1]:]
+		} else {
+			rest = ""
+		}
+		if prev != "" && nearRepeat(prev, s, threshold) {
+			continue
+		}
+		kept = append(kept, s)
+		prev = s
+	}
+	return strings.Join(kept, "")
+}
+
+// hasListItem reports whether any line of para is a list item; a list
+// often follows a lead-in line in the same paragraph.
+func hasListItem(para string) bool {
+	for line := range strings.SplitSeq(para, "\n") {
+		if listItem.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// nearRepeat reports whether b is close enough to a to be a repeat of it.
+func nearRepeat(a, b string, threshold float64) bool {
+	if len(strings.Fields(a)) < dedupeMinWords || len(strings.Fields(b)) < dedupeMinWords {
+		return false
+	}
+	la, lb := float64(len(a)), float64(len(b))
+	if min(la, lb)/max(la, lb) < dedupeMinRatio {
+		return false
+	}
+	return jaccard(bigrams(a), bigrams(b)) >= threshold
+}
+
+// bigrams is the set of s's adjacent word pairs, lowercased.
+func bigrams(s string) map[string]struct{} {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	set := map[string]struct{}{}
+	for i := 1; i < len(words); i++ {
+		set[words[i-1]+" "+words[i]] = struct{}{}
+	}
+	return set
+}
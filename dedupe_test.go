@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestDedupeRemovesRepeats(t *testing.T) {
+	for name, tc := range map[string]struct{ in, want string }{
+		"paragraph": {
+			"Paris is the capital of France and its largest city by far.\n\nParis is the capital of France and its largest city, by far.\n\nIt sits on the Seine.",
+			"Paris is the capital of France and its largest city by far.\n\nIt sits on the Seine.",
+		},
+		"sentence": {
+			"The service restarts every worker when the config file changes on disk. The service restarts every worker when the config file changes on disk! Nothing else restarts.",
+			"The service restarts every worker when the config file changes on disk. Nothing else restarts.",
+		},
+	} {
+		if got := dedupeRepeats(tc.in, 0.8); got != tc.want {
+			t.Errorf("%s: got %q, want %q", name, got, tc.want)
+		}
+	}
+}
+
+func TestDedupeKeepsLegitimateRepetition(t *testing.T) {
+	for name, in := range map[string]string{
+		"word order": "Rust is faster than Go for this CPU-bound benchmark on our hardware.\n\n" +
+			"Go is faster than Rust for this CPU-bound benchmark on our hardware.",
+		"swapped sentence": "Alice sent the signed contract to Bob on Monday before the deadline. " +
+			"Bob sent the signed contract to Alice on Monday before the deadline.",
+		"list after a lead-in": "For the staging cluster:\n- restart the web servers one at a time and wait for health checks\n\n" +
+			"For the production cluster:\n- restart the web servers one at a time and wait for health checks",
+		"list items": "1. Back up the database to the primary storage bucket tonight.\n\n" +
+			"2. Back up the database to the secondary storage bucket tonight.",
+		"code": "```sh\nkubectl rollout restart deployment/web --namespace prod now\n```\n\n" +
+			"```sh\nkubectl rollout restart deployment/web --namespace prod now\n```",
+		"short": "Yes, it works.\n\nYes, it works.",
+	} {
+		if got := dedupeRepeats(in, 0.8); got != in {
+			t.Errorf("%s: changed to %q", name, got)
+		}
+	}
+}
+
+func TestStreamSendsDedupedAnswer(t *testing.T) {
+	const repeated = "Paris is the capital of France and its largest city by far.\n\n" +
+		"Paris is the capital of France and its largest city, by far.\n\nIt sits on the Seine."
+	for _, edit := range []func(*config){
+		func(c *config) { c.DedupeThreshold = 0.8 },
+		func(c *config) { c.NormalizeOutput = true },
+	} {
+		testConfig(t, edit)
+		useGenerator(t, ensemble(
+			map[string]string{"llama3.2": "Paris.", "qwen2.5": "It is Paris.", "mistral": "Paris, France."},
+			map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 5},
+			repeated+"  \n\n\n",
+		))
+		msgs := postStream(t, `{"prompt":"capital?","mode":"quality"}`)
+		final, _ := msgs[len(msgs)-1].Meta.(map[string]any)["final"].(string)
+		if got := deltas(msgs); got != final || got == repeated+"  \n\n\n" {
+			t.Errorf("dedupe=%v normalize=%v: streamed %q, meta final %q", cfg.DedupeThreshold, cfg.NormalizeOutput, got, final)
+		}
+	}
+}
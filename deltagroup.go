@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// -------------------- Delta grouping --------------------
+
+// stream_delta_grouping values
+const (
+	groupToken    = "token" // pass Ollama's fragments through as they come (the default)
+	groupWord     = "word"
+	groupSentence = "sentence"
+)
+
+// maxGroupBytes bounds how much a grouper holds back when no boundary shows
+// up (a long URL, text without spaces); it then releases whole runes.
+const maxGroupBytes = 256
+
+// deltaGrouper regroups streamed fragments so each delta ends on a word or
+// sentence boundary. Boundaries are ASCII whitespace and punctuation (plus
+// CJK sentence ends), so a multibyte character split across fragments is
+// held back until it is complete.
+type deltaGrouper struct {
+	mode string
+	buf  string
+}
+
+func newDeltaGrouper(mode string) *deltaGrouper {
+	return &deltaGrouper{mode: mode}
+}
+
+// Write feeds a fragment and returns whatever is ready to send, possibly "".
+func (g *deltaGrouper) Write(chunk string) string {
+	if g.mode == "" || g.mode == groupToken {
+		return chunk
+	}
+	g.buf += chunk
+	cut := -1
+	if g.mode == groupSentence {
+		cut = lastSentenceEnd(g.buf)
+	} else if i := strings.LastIndexAny(g.buf, " \t\n"); i >= 0 {
+		cut = i + 1
+	}
+	if cut < 0 && len(g.buf) > maxGroupBytes {
+		cut = len(g.buf)
+		for cut > 0 && !utf8.ValidString(g.buf[:cut]) {
+			cut--
+		}
+	}
+	if cut <= 0 {
+		return ""
+	}
+	out := g.buf[:cut]
+	g.buf = g.buf[cut:]
+	return out
+}
+
+// Flush returns everything still held back, at the end of the stream.
+func (g *deltaGrouper) Flush() string {
+	out := g.buf
+	g.buf = ""
+	return out
+}
+
+// lastSentenceEnd is the index just past the last sentence end in s that is
+// followed by whitespace (so "3.14" or "e.g" isn't one), or past a newline
+// or a CJK full stop; -1 if there is none.
+func lastSentenceEnd(s string) int {
+	end := -1
+	if i := strings.LastIndexAny(s, "。！？"); i >= 0 {
+		_, n := utf8.DecodeRuneInString(s[i:])
+		end = i + n
+	}
+	for i := len(s) - 1; i >= end && i >= 0; i-- {
+		switch s[i] {
+		case '\n':
+			return i + 1
+		case ' ', '\t':
+			if i > 0 && strings.IndexByte(".!?:;", s[i-1]) >= 0 {
+				return i + 1
+			}
+		}
+	}
+	return end
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// feed runs chunks through a grouper for mode and returns the non-empty
+// deltas it released, the final flush included.
+func feed(mode string, chunks []string) []string {
+	g := newDeltaGrouper(mode)
+	var out []string
+	for _, c := range chunks {
+		if d := g.Write(c); d != "" {
+			out = append(out, d)
+		}
+	}
+	if d := g.Flush(); d != "" {
+		out = append(out, d)
+	}
+	return out
+}
+
+// byteChunks splits s every n bytes, cutting through multibyte runes.
+func byteChunks(s string, n int) []string {
+	var out []string
+	for len(s) > n {
+		out = append(out, s[:n])
+		s = s[n:]
+	}
+	return append(out, s)
+}
+
+func TestDeltaGroupingKeepsRunesWhole(t *testing.T) {
+	text := "Café crème, s'il vous plaît. 東京は日本の首都です。Ça va? Größe: 5 m² — naïve 😀 ok.\nEnd"
+	for _, mode := range []string{groupWord, groupSentence} {
+		for n := 1; n <= 7; n++ {
+			out := feed(mode, byteChunks(text, n))
+			if got := strings.Join(out, ""); got != text {
+				t.Fatalf("%s/%d: reassembled %q", mode, n, got)
+			}
+			for _, d := range out[:len(out)-1] {
+				if !utf8.ValidString(d) {
+					t.Errorf("%s/%d: delta %q splits a rune", mode, n, d)
+				}
+			}
+		}
+	}
+}
+
+func TestDeltaGroupingSplitRuneAtBoundary(t *testing.T) {
+	e := "é" // 0xc3 0xa9
+	got := feed(groupWord, []string{"caf" + e[:1], e[1:] + " au", " lait"})
+	want := []string{"café ", "au ", "lait"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDeltaGroupingCapCutsBetweenRunes(t *testing.T) {
+	e := "é"
+	long := strings.Repeat("a", maxGroupBytes)
+	g := newDeltaGrouper(groupWord)
+	if d := g.Write(long + e[:1]); d != long {
+		t.Fatalf("released %d bytes past the cap, want the %d before the split rune", len(d), len(long))
+	}
+	if d := g.Write(e[1:]); d != "" {
+		t.Errorf("released %q without a boundary", d)
+	}
+	if d := g.Flush(); d != e {
+		t.Errorf("flushed %q, want the completed rune", d)
+	}
+}
+
+func TestDeltaGroupingSentences(t *testing.T) {
+	got := feed(groupSentence, []string{"Pi is 3.", "14. It is ", "irrational! 東京", "です。", "Done"})
+	want := []string{"Pi is 3.14. ", "It is irrational! ", "東京です。", "Done"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDeltaGroupingTokenPassesThrough(t *testing.T) {
+	chunks := []string{"ca", "f\xc3", "\xa9"}
+	if got := feed(groupToken, chunks); strings.Join(got, "|") != strings.Join(chunks, "|") {
+		t.Errorf("token mode regrouped: %q", got)
+	}
+}
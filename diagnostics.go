@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// -------------------- Diagnostics --------------------
+
+// diagnostics is a point-in-time view of resources that should return to
+// baseline once load stops; a goroutine count that keeps climbing after
+// clients go away points at a leak.
+type diagnostics struct {
+	Goroutines    int    `json:"goroutines"`
+	InFlight      int64  `json:"in_flight"` // requests running the model pipeline
+	Queued        int64  `json:"queued"`    // requests waiting for admission
+	ActiveStreams int64  `json:"active_streams"`
+	CacheEntries  int    `json:"cache_entries"`
+	Transcripts   int    `json:"transcripts"`
+	HeapBytes     uint64 `json:"heap_bytes"`
+}
+
+func readDiagnostics() diagnostics {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	cacheMu.RLock()
+	entries := len(cacheMap)
+	cacheMu.RUnlock()
+	transcriptMu.Lock()
+	nt := len(transcripts)
+	transcriptMu.Unlock()
+	return diagnostics{
+		Goroutines:    runtime.NumGoroutine(),
+		InFlight:      inFlight.Load(),
+		Queued:        queueDepth.Load(),
+		ActiveStreams: activeStreams.Load(),
+		CacheEntries:  entries,
+		Transcripts:   nt,
+		HeapBytes:     ms.HeapAlloc,
+	}
+}
+
+// handleDiagnostics serves /admin/diagnostics, which needs
+// "Authorization: Bearer <diagnostics_token>" and is off (404) without a
+// token configured. ?interval=1s streams a fresh NDJSON snapshot at that
+// rate until the client disconnects, for watching a load test live.
+func handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if cfg.DiagnosticsToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.DiagnosticsToken)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, errResp{Error: "bad or missing diagnostics token"})
+		return
+	}
+	iv := r.URL.Query().Get("interval")
+	if iv == "" {
+		writeJSON(w, http.StatusOK, readDiagnostics())
+		return
+	}
+	d, err := time.ParseDuration(iv)
+	if err != nil || d < 100*time.Millisecond {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: "interval must be a duration of at least 100ms"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	tick := time.NewTicker(d)
+	defer tick.Stop()
+	enc := json.NewEncoder(w)
+	for {
+		if err := enc.Encode(readDiagnostics()); err != nil {
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
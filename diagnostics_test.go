@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hangingGenerator answers like g, except that the models in hang and any
+// streamed synthesis keep going until ctx ends, as a slow Ollama would.
+type hangingGenerator struct {
+	*fakeGenerator
+	hang map[string]bool
+}
+
+func (h hangingGenerator) Generate(ctx context.Context, model, prompt string, o genOptions) (string, error) {
+	if h.hang[model] && !strings.HasPrefix(prompt, "You are a strict evaluator") {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	return h.fakeGenerator.Generate(ctx, model, prompt, o)
+}
+
+func (h hangingGenerator) GenerateStream(ctx context.Context, model, prompt string, o genOptions, onDelta func(string) error) (string, error) {
+	t := time.NewTicker(2 * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-t.C:
+			if err := onDelta("more "); err != nil {
+				return "", err
+			}
+		}
+	}
+}
+
+// syncRecorder is a ResponseRecorder that is safe to read while the handler
+// is still writing, and reports each write on wrote.
+type syncRecorder struct {
+	mu    sync.Mutex
+	rec   *httptest.ResponseRecorder
+	wrote chan struct{}
+}
+
+func (s *syncRecorder) Header() http.Header { return s.rec.Header() }
+func (s *syncRecorder) WriteHeader(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec.WriteHeader(code)
+}
+func (s *syncRecorder) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	n, err := s.rec.Write(b)
+	s.mu.Unlock()
+	select {
+	case s.wrote <- struct{}{}:
+	default:
+	}
+	return n, err
+}
+func (s *syncRecorder) Flush() {}
+
+func TestStreamDisconnectsLeakNoGoroutines(t *testing.T) {
+	testConfig(t, func(c *config) { c.SynthStallTimeout = 0 })
+	answers := map[string]string{"llama3.2": "Paris.", "qwen2.5": "It is Paris.", "mistral": "Paris, France."}
+	scores := map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 5}
+
+	stages := []struct {
+		name string
+		hang map[string]bool
+	}{
+		{"during fan-out", map[string]bool{"qwen2.5": true, "mistral": true}},
+		{"during synthesis", nil},
+	}
+	for _, st := range stages {
+		t.Run(st.name, func(t *testing.T) {
+			useGenerator(t, hangingGenerator{ensemble(answers, scores, ""), st.hang})
+			runtime.GC()
+			base := runtime.NumGoroutine()
+
+			var wg sync.WaitGroup
+			for i := range 20 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					ctx, cancel := context.WithCancel(context.Background())
+					defer cancel()
+					body := strings.NewReader(`{"prompt":"capital ` + strings.Repeat("?", i+1) + `","mode":"quality"}`)
+					req := httptest.NewRequest(http.MethodPost, "/answer/stream", body).WithContext(ctx)
+					w := &syncRecorder{rec: httptest.NewRecorder(), wrote: make(chan struct{}, 1)}
+					done := make(chan struct{})
+					go func() {
+						handleAnswerStream(w, req)
+						close(done)
+					}()
+					// let the pipeline get going, then drop the client
+					select {
+					case <-w.wrote:
+					case <-done:
+					}
+					time.Sleep(20 * time.Millisecond)
+					cancel()
+					select {
+					case <-done:
+					case <-time.After(5 * time.Second):
+						t.Error("handler still running 5s after the client went away")
+					}
+				}()
+			}
+			wg.Wait()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for runtime.NumGoroutine() > base && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if n := runtime.NumGoroutine(); n > base {
+				buf := make([]byte, 1<<16)
+				t.Errorf("%d goroutines after the disconnects, %d before:\n%s", n, base, buf[:runtime.Stack(buf, true)])
+			}
+			if d := readDiagnostics(); d.ActiveStreams != 0 || d.InFlight != 0 || d.Queued != 0 {
+				t.Errorf("diagnostics after the disconnects: %+v", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "fmt"
+
+// -------------------- Surfacing disagreement --------------------
+
+const disagreementRule = "The answers disagree. Where they make conflicting claims, do not pick one silently or blend them: " +
+	"say so (\"Sources differ: ...\") and set out each position.\n"
+
+// disagrees reports whether a surface_disagreement request's synthesis
+// input agrees less than disagreement_threshold (see agreement).
+func (r AnswerRequest) disagrees(top []Candidate) bool {
+	return r.SurfaceDisagreement && len(top) > 1 && agreement(top) < cfg.DisagreementThreshold
+}
+
+// disagreementNote is the response note for a synthesis told to present
+// the candidates' disagreement; empty when it wasn't.
+func disagreementNote(req AnswerRequest, top []Candidate) string {
+	if !req.disagrees(top) {
+		return ""
+	}
+	return fmt.Sprintf("candidates disagree (agreement %.2f); conflicting claims are presented side by side", agreement(top))
+}
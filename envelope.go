@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// -------------------- Response envelope versions --------------------
+
+// responseVersion is the AnswerResponse shape this build produces. Bump it
+// whenever a field is added, removed, or changes meaning, and note it here:
+//
+//	1: final, candidates, cached, mode
+//	2: adds version, source, truncated, debug
+//	3: adds notes
+//	4: adds finals
+//	5: adds cache_ttl_s
+//	6: adds degraded
+//	7: adds scores
+//	8: adds timings
+//	9: adds budget_exceeded
+//	10: adds refused
+//	11: adds variations
+//	12: adds confidence, needs_human
+//	13: adds agreement, cache_decision
+//
+// Clients can pin an older shape with an Accept-Version header; fields newer
+// than the pinned version are left out.
+const responseVersion = 13
+
+type answerResponseV1 struct {
+	Final      string      `json:"final"`
+	Candidates []Candidate `json:"candidates"`
+	Cached     bool        `json:"cached"`
+	Mode       string      `json:"mode"`
+}
+
+// requestedVersion reads Accept-Version, defaulting to the current version.
+func requestedVersion(r *http.Request) (int, error) {
+	h := strings.TrimSpace(r.Header.Get("Accept-Version"))
+	if h == "" {
+		return responseVersion, nil
+	}
+	v, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(h), "v"))
+	if err != nil || v < 1 || v > responseVersion {
+		return 0, fmt.Errorf("unsupported Accept-Version %q (supported: 1-%d)", h, responseVersion)
+	}
+	return v, nil
+}
+
+// shapeResponse renders resp in the requested envelope version.
+func shapeResponse(resp AnswerResponse, version int) any {
+	if version == 1 {
+		return answerResponseV1{Final: resp.Final, Candidates: resp.Candidates, Cached: resp.Cached, Mode: resp.Mode}
+	}
+	if version < 3 {
+		resp.Notes = nil
+	}
+	if version < 4 {
+		resp.Finals = nil
+	}
+	if version < 5 {
+		resp.CacheTTLs = 0
+	}
+	if version < 6 {
+		resp.Degraded = false
+	}
+	if version < 7 {
+		resp.Scores = nil
+	}
+	if version < 8 {
+		resp.Timings = nil
+	}
+	if version < 9 {
+		resp.BudgetExceeded = false
+	}
+	if version < 10 {
+		resp.Refused = false
+	}
+	if version < 11 {
+		resp.Variations = nil
+	}
+	if version < 12 {
+		resp.Confidence = nil
+		resp.NeedsHuman = false
+	}
+	if version < 13 {
+		resp.Agreement = nil
+		resp.CacheDecision = ""
+	}
+	resp.Version = version
+	return resp
+}
+
+// responseFields is every top-level field name AnswerResponse can carry.
+var responseFields = jsonFieldNames(AnswerResponse{})
+
+// jsonFieldNames lists the JSON names of struct v's tagged fields.
+func jsonFieldNames(v any) []string {
+	var names []string
+	t := reflect.TypeOf(v)
+	for i := range t.NumField() {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// requestFields is the request's field selection: the fields query
+// parameter (comma-separated) if present, else the body's fields list.
+func requestFields(r *http.Request, req AnswerRequest) ([]string, error) {
+	fields := req.Fields
+	if q := r.URL.Query().Get("fields"); q != "" {
+		fields = strings.Split(q, ",")
+	}
+	for i, f := range fields {
+		fields[i] = strings.TrimSpace(f)
+		if !slices.Contains(responseFields, fields[i]) {
+			return nil, fmt.Errorf("unknown field %q in fields (known: %s)", fields[i], strings.Join(responseFields, ", "))
+		}
+	}
+	return fields, nil
+}
+
+// selectFields trims a shaped response to the named top-level fields; with
+// none named it returns v unchanged. Fields that are absent in v (omitted
+// as empty, or newer than the pinned version) stay absent.
+func selectFields(v any, fields []string) any {
+	if len(fields) == 0 {
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return v
+	}
+	out := make(map[string]json.RawMessage, len(fields))
+	for _, f := range fields {
+		if raw, ok := all[f]; ok {
+			out[f] = raw
+		}
+	}
+	return out
+}
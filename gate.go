@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// -------------------- Confidence gating --------------------
+
+// With confidence_gate set, every model ends its answer with a self-rating,
+// which is taken off the text and shown to the judge. The answer's overall
+// confidence is the weakest of candidate agreement, the top judge score and
+// the mean self-rating; below the gate, the client gets escalation_message
+// and needs_human instead of a guess.
+
+const (
+	selfRatingRule      = "On a last line of its own, rate how sure you are of this answer as \"Confidence: N/10\".\n"
+	selfRatingJudgeRule = "Some answers carry the model's own confidence rating. A model unsure of itself is more often wrong: " +
+		"weigh a low rating in, but don't reward a high one on its own.\n"
+)
+
+// selfRatingLine matches the trailing rating, allowing for markdown bold and
+// a "/10" or "out of 10" scale.
+var selfRatingLine = regexp.MustCompile(`(?i)(?:^|\n)[ \t*_]*confidence[ \t*_]*[:=-]?[ \t*_]*(\d+(?:\.\d+)?)[ \t]*(?:/[ \t]*10|out of 10)?[ \t*_.]*$`)
+
+// selfRatingPrompt is the candidate prompt's rating rule; empty without
+// confidence_gate.
+func selfRatingPrompt() string {
+	if cfg.ConfidenceGate <= 0 {
+		return ""
+	}
+	return selfRatingRule
+}
+
+// takeSelfRating strips the trailing rating from a candidate's text and
+// returns it scaled to [0,1]; nil when gating is off or there was none (or
+// it was off the 0-10 scale), and the text is then left alone.
+func takeSelfRating(text string) (string, *float64) {
+	if cfg.ConfidenceGate <= 0 {
+		return text, nil
+	}
+	trimmed := strings.TrimSpace(text)
+	m := selfRatingLine.FindStringSubmatchIndex(trimmed)
+	if m == nil {
+		return text, nil
+	}
+	n, err := strconv.ParseFloat(trimmed[m[2]:m[3]], 64)
+	if err != nil || n < 0 || n > 10 {
+		return text, nil
+	}
+	rest := strings.TrimSpace(trimmed[:m[0]])
+	if rest == "" {
+		return text, nil
+	}
+	r := n / 10
+	return rest, &r
+}
+
+// selfRatingNote is the judge prompt's line for c's self-rating; empty when
+// it gave none.
+func selfRatingNote(c Candidate) string {
+	if c.selfRating == nil {
+		return ""
+	}
+	return fmt.Sprintf("self-assessed confidence: %.0f/10\n", *c.selfRating*10)
+}
+
+// selfRating is the mean self-rating of the candidates that gave one.
+func selfRating(cands []Candidate) (float64, bool) {
+	var sum float64
+	n := 0
+	for _, c := range cands {
+		if c.selfRating != nil {
+			sum += *c.selfRating
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+// gateConfidence sets resp.Confidence under confidence_gate and, when it
+// falls below the gate, replaces the answer with escalation_message and sets
+// NeedsHuman. It runs after finalizeAnswer, so the message goes out as
+// configured. The candidates stay on resp for the audit log; the client
+// doesn't get them (withholdCandidates).
+func gateConfidence(resp *AnswerResponse) {
+	if cfg.ConfidenceGate <= 0 {
+		return
+	}
+	c := confidence(*resp)
+	if s, ok := selfRating(resp.Candidates); ok {
+		c = min(c, s)
+	}
+	resp.Confidence = &c
+	if c >= cfg.ConfidenceGate {
+		return
+	}
+	resp.Final = cfg.EscalationMessage
+	resp.Finals = nil
+	resp.Truncated = false
+	resp.NeedsHuman = true
+	resp.Notes = append(resp.Notes, fmt.Sprintf("escalated: confidence %.2f is below %.2f", c, cfg.ConfidenceGate))
+}
+
+// withholdCandidates leaves the candidate answers, and the judge's scores
+// and raw texts that would give them away, out of an escalated response:
+// the client should get the escalation, not the guesses behind it. Like
+// capCandidates it only shapes the output.
+func withholdCandidates(resp AnswerResponse) AnswerResponse {
+	if !resp.NeedsHuman || len(resp.Candidates) == 0 {
+		return resp
+	}
+	resp.Candidates = []Candidate{}
+	resp.Scores = nil
+	if resp.Debug != nil {
+		d := *resp.Debug
+		d.RawCandidates, d.RawFinal, d.OllamaResponses = nil, "", nil
+		resp.Debug = &d
+	}
+	resp.Notes = append(slices.Clip(resp.Notes), "candidates withheld: escalated to a human")
+	return resp
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// gateEnsemble answers with every model rating itself rating/10.
+func gateEnsemble(rating string) *fakeGenerator {
+	return ensemble(
+		map[string]string{"llama3.2": "Paris is the capital.\nConfidence: " + rating + "/10", "qwen2.5": "Paris is the capital.\nConfidence: " + rating + "/10"},
+		map[string]int{"llama3.2": 9, "qwen2.5": 8},
+		"Paris is the capital.",
+	)
+}
+
+func TestEscalationWithholdsCandidates(t *testing.T) {
+	testConfig(t, func(c *config) { c.ConfidenceGate = 0.6 })
+	useGenerator(t, gateEnsemble("2"))
+
+	_, resp := postAnswer(t, handleAnswer, `{"prompt":"capital of France?","mode":"fast","include_scores":true,"explain":true}`)
+	if !resp.NeedsHuman || resp.Final != cfg.EscalationMessage {
+		t.Fatalf("got %q needs_human=%v, want an escalation", resp.Final, resp.NeedsHuman)
+	}
+	if len(resp.Candidates) != 0 || len(resp.Scores) != 0 {
+		t.Errorf("escalation listed %d candidates and %d scores", len(resp.Candidates), len(resp.Scores))
+	}
+	if resp.Debug != nil && (len(resp.Debug.RawCandidates) != 0 || resp.Debug.RawFinal != "") {
+		t.Errorf("escalation's debug trace carries the answers: %+v", resp.Debug)
+	}
+	if !slices.Contains(resp.Notes, "candidates withheld: escalated to a human") {
+		t.Errorf("notes %q", resp.Notes)
+	}
+}
+
+func TestEscalationStreamsNoCandidates(t *testing.T) {
+	testConfig(t, func(c *config) { c.ConfidenceGate = 0.6 })
+	useGenerator(t, gateEnsemble("2"))
+
+	msgs := postStream(t, `{"prompt":"capital of France?","mode":"fast","stream_candidates":true}`)
+	for _, m := range msgs {
+		if m.Type == "candidate_delta" || m.Type == "candidate_done" {
+			t.Fatalf("escalated stream sent %+v", m)
+		}
+	}
+	meta, _ := msgs[len(msgs)-1].Meta.(map[string]any)
+	if meta["needs_human"] != true || len(meta["candidates"].([]any)) != 0 {
+		t.Errorf("meta %v, want an escalation without candidates", meta)
+	}
+	if d := deltas(msgs); d != cfg.EscalationMessage {
+		t.Errorf("streamed %q, want the escalation message", d)
+	}
+}
+
+func TestConfidentAnswerStreamsHeldCandidates(t *testing.T) {
+	testConfig(t, func(c *config) { c.ConfidenceGate = 0.6 })
+	useGenerator(t, gateEnsemble("9"))
+
+	msgs := postStream(t, `{"prompt":"capital of France?","mode":"fast","stream_candidates":true}`)
+	done := map[string]bool{}
+	firstDelta := slices.IndexFunc(msgs, func(m streamMsg) bool { return m.Type == "delta" })
+	for i, m := range msgs {
+		if m.Type == "candidate_done" {
+			done[m.Provider] = true
+			if firstDelta >= 0 && i > firstDelta {
+				t.Errorf("candidate lines came after the answer")
+			}
+		}
+	}
+	if !done["llama3.2"] || !done["qwen2.5"] {
+		t.Errorf("candidates done for %v, want both", done)
+	}
+	meta, _ := msgs[len(msgs)-1].Meta.(map[string]any)
+	if meta["needs_human"] == true || len(meta["candidates"].([]any)) != 2 {
+		t.Errorf("meta %v, want the answer with its candidates", meta)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// -------------------- Retrieved context (RAG) --------------------
+
+// groundedAnswerRule goes into candidate and synthesis prompts when the
+// request carries context_docs.
+const groundedAnswerRule = "Answer using the reference documents provided. Cite the ones you rely on by number, like [1]. " +
+	"If they don't cover the question, say so before answering from general knowledge.\n"
+
+// fitContext bounds the request's context_docs to cfg.MaxContextChars.
+// Docs come in priority order (as retrieved), so the last ones are dropped
+// first; a first doc that alone is over the limit is cut to fit. The note
+// is for the response; empty when every doc was kept whole.
+func fitContext(req AnswerRequest) (AnswerRequest, string) {
+	limit := cfg.MaxContextChars
+	if limit <= 0 || len(req.ContextDocs) == 0 {
+		return req, ""
+	}
+	total := 0
+	for i, d := range req.ContextDocs {
+		n := len([]rune(d))
+		if total+n <= limit {
+			total += n
+			continue
+		}
+		kept := req.ContextDocs[:i:i]
+		if i == 0 {
+			kept = []string{string([]rune(d)[:limit])}
+		}
+		note := fmt.Sprintf("context_docs over %d chars: kept %d of %d", limit, len(kept), len(req.ContextDocs))
+		if i == 0 {
+			note += " (cut)"
+		}
+		req.ContextDocs = kept
+		return req, note
+	}
+	return req, ""
+}
+
+// contextBlock fences each doc and numbers it for citation. Docs are
+// retrieved text, so fence look-alikes inside them are broken up as for
+// the judge.
+func (r AnswerRequest) contextBlock() string {
+	if len(r.ContextDocs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Reference documents:\n")
+	for i, d := range r.ContextDocs {
+		d = strings.ReplaceAll(d, fenceOpen, "< < <")
+		d = strings.ReplaceAll(d, fenceClose, "> > >")
+		fmt.Fprintf(&b, "%sDOC [%d]\n%s\nEND DOC [%d]%s\n", fenceOpen, i+1, strings.TrimSpace(d), i+1, fenceClose)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// groundingRule is the request's grounding instruction; empty without
+// context_docs.
+func (r AnswerRequest) groundingRule() string {
+	if len(r.ContextDocs) == 0 {
+		return ""
+	}
+	return groundedAnswerRule
+}
+
+// groundedPrompt is the prompt as the judge, verifier and synthesis guard
+// see it: the user's prompt preceded by the reference documents, so answers
+// are checked against the same material they were asked to use.
+func (r AnswerRequest) groundedPrompt() string {
+	if len(r.ContextDocs) == 0 {
+		return r.Prompt
+	}
+	return r.contextBlock() + "Answers should rely on these documents and cite them by number.\n\nQuestion:\n" + r.Prompt
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// -------------------- Provider groups --------------------
+
+// group select values
+const (
+	groupAll      = "all"      // every answer goes on to judging/synthesis
+	groupBestOf   = "best_of"  // only the group's best answer (fastPick)
+	groupMajority = "majority" // only the answer closest to the rest of the group
+)
+
+// providerGroup is the selection policy for providers sharing a group name.
+// Providers with no group form the default group, which keeps every answer.
+type providerGroup struct {
+	Select string `json:"select"`
+}
+
+func checkGroups(mode string, m modeConfig) error {
+	for name, g := range m.Groups {
+		switch g.Select {
+		case groupAll, groupBestOf, groupMajority:
+		default:
+			return fmt.Errorf("mode %s: group %s: select must be %q, %q, or %q", mode, name, groupAll, groupBestOf, groupMajority)
+		}
+	}
+	for _, p := range slices.Concat(m.Providers, m.FallbackProviders) {
+		if _, ok := m.Groups[p.Group]; p.Group != "" && !ok {
+			return fmt.Errorf("mode %s: provider %s is in undefined group %q", mode, p.displayName(), p.Group)
+		}
+	}
+	return nil
+}
+
+// combineGroups narrows each group's answers by its policy, keeping the
+// survivors in their original (latency) order.
+func combineGroups(cands []Candidate, groups map[string]providerGroup) []Candidate {
+	if len(groups) == 0 {
+		return cands
+	}
+	members := map[string][]Candidate{}
+	for _, c := range cands {
+		members[c.group] = append(members[c.group], c)
+	}
+	keep := map[string]bool{}
+	for name, ms := range members {
+		switch groups[name].Select {
+		case groupBestOf:
+			keep[fastPick(ms).Provider] = true
+		case groupMajority:
+			keep[consensusPick(ms).Provider] = true
+		default:
+			for _, c := range ms {
+				keep[c.Provider] = true
+			}
+		}
+	}
+	out := cands[:0:0]
+	for _, c := range cands {
+		if keep[c.Provider] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// consensusPick returns the answer with the highest mean word overlap with
+// the others: the one most of the group agrees with.
+func consensusPick(cands []Candidate) Candidate {
+	sets := make([]map[string]struct{}, len(cands))
+	for i, c := range cands {
+		sets[i] = wordSet(c.Text)
+	}
+	best, bestSum := 0, -1.0
+	for i := range sets {
+		var sum float64
+		for j := range sets {
+			if i != j {
+				sum += jaccard(sets[i], sets[j])
+			}
+		}
+		if sum > bestSum {
+			best, bestSum = i, sum
+		}
+	}
+	return cands[best]
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// -------------------- Provider health probing --------------------
+
+// modelHealth is the latest probe result for one model.
+type modelHealth struct {
+	Model     string    `json:"model"`
+	Up        bool      `json:"up"`
+	LatencyMs int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+var (
+	healthMu sync.RWMutex
+	health   = map[string]modelHealth{} // by model; empty until the first probe
+)
+
+// runHealthProbes sends a one-token generation to every configured model each
+// cfg.HealthProbeInterval until ctx ends. It does nothing when probing is off,
+// or when replaying recordings, where there is nothing to probe. When
+// recording, probes bypass the recordings file so pings don't fill it.
+func runHealthProbes(ctx context.Context, g Generator) {
+	if cfg.HealthProbeInterval <= 0 || cfg.RecordingMode == recordingReplay {
+		return
+	}
+	if rc, ok := g.(*recordingClient); ok {
+		g = rc.next
+	}
+	interval := time.Duration(cfg.HealthProbeInterval)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		probeModels(ctx, g, min(interval, 10*time.Second))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func probeModels(ctx context.Context, g Generator, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, model := range configuredModels() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			_, err := g.Generate(pctx, model, "ping", genOptions{Options: map[string]any{"num_predict": 1}, KeepAlive: cfg.KeepAlive})
+			if ctx.Err() != nil {
+				return // shutting down; keep the last real result
+			}
+			h := modelHealth{Model: model, Up: err == nil, LatencyMs: time.Since(start).Milliseconds(), CheckedAt: time.Now()}
+			if err != nil {
+				h.Error = err.Error()
+			}
+			healthMu.Lock()
+			if prev, ok := health[model]; !ok || prev.Up != h.Up {
+				log.Printf("health: model %s up=%v (%dms) %s", model, h.Up, h.LatencyMs, h.Error)
+			}
+			health[model] = h
+			healthMu.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+// configuredModels lists each model used by any mode, once.
+func configuredModels() []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, m := range cfg.Modes {
+		for _, p := range slices.Concat(m.Providers, m.FallbackProviders) {
+			if !seen[p.Model] {
+				seen[p.Model] = true
+				out = append(out, p.Model)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// skipDown drops providers whose model failed its last probe. If that would
+// drop all of them they are all kept: the probe may be stale, and trying
+// beats failing without asking.
+func skipDown(providers []provider) []provider {
+	healthMu.RLock()
+	defer healthMu.RUnlock()
+	if len(health) == 0 {
+		return providers
+	}
+	var up []provider
+	for _, p := range providers {
+		if h, ok := health[p.Model]; !ok || h.Up {
+			up = append(up, p)
+		}
+	}
+	if len(up) == 0 {
+		return providers
+	}
+	return up
+}
+
+// handleProviderHealth serves the latest probe results.
+func handleProviderHealth(w http.ResponseWriter, r *http.Request) {
+	healthMu.RLock()
+	out := make([]modelHealth, 0, len(health))
+	for _, h := range health {
+		out = append(out, h)
+	}
+	healthMu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Model < out[j].Model })
+	writeJSON(w, http.StatusOK, map[string]any{"probing": cfg.HealthProbeInterval > 0, "models": out})
+}
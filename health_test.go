@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHealthProbesSkipRecordingsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recordings.jsonl")
+	testConfig(t, func(c *config) {
+		c.HealthProbeInterval = duration(time.Hour)
+		c.RecordingMode = recordingRecord
+		c.RecordingsFile = path
+	})
+	healthMu.Lock()
+	oldHealth := health
+	health = map[string]modelHealth{}
+	healthMu.Unlock()
+	t.Cleanup(func() {
+		healthMu.Lock()
+		health = oldHealth
+		healthMu.Unlock()
+	})
+
+	fake := &fakeGenerator{respond: func(model, prompt string) (string, error) { return "pong", nil }}
+	rc, err := newRecordingClient(path, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rc.f.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runHealthProbes(ctx, rc)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		healthMu.RLock()
+		n := len(health)
+		healthMu.RUnlock()
+		if n == len(configuredModels()) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("probed %d of %d models", n, len(configuredModels()))
+		}
+	}
+	cancel()
+	<-done
+
+	if len(fake.prompts("ping")) == 0 {
+		t.Fatal("no probe reached the model")
+	}
+	if b, _ := os.ReadFile(path); len(b) > 0 {
+		t.Errorf("probes were recorded: %s", b)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// -------------------- Image attachments --------------------
+
+// checkImages validates a request's base64 images against the configured
+// limits, and that the mode has a provider able to look at them.
+func checkImages(req AnswerRequest, mode string) error {
+	if len(req.Images) == 0 {
+		return nil
+	}
+	if len(req.Images) > cfg.MaxImages {
+		return fmt.Errorf("at most %d images per request", cfg.MaxImages)
+	}
+	for i, img := range req.Images {
+		b, err := base64.StdEncoding.DecodeString(img)
+		if err != nil {
+			return fmt.Errorf("images[%d] is not valid base64", i)
+		}
+		if len(b) > cfg.MaxImageBytes {
+			return fmt.Errorf("images[%d] is larger than %d bytes", i, cfg.MaxImageBytes)
+		}
+	}
+	mc, _ := imageProviders(cfg.Modes[mode])
+	if len(mc.Providers)+len(mc.FallbackProviders) == 0 {
+		return fmt.Errorf("no %s mode provider accepts images", mode)
+	}
+	return nil
+}
+
+// imageProviders narrows a mode to its multimodal providers for a request
+// with images, returning a note naming the ones left out ("" if none were).
+func imageProviders(mc modeConfig) (modeConfig, string) {
+	var skipped []string
+	keep := func(ps []provider) []provider {
+		var out []provider
+		for _, p := range ps {
+			if p.Multimodal {
+				out = append(out, p)
+			} else {
+				skipped = append(skipped, p.displayName())
+			}
+		}
+		return out
+	}
+	mc.Providers = keep(mc.Providers)
+	mc.FallbackProviders = keep(mc.FallbackProviders)
+	if len(skipped) == 0 {
+		return mc, ""
+	}
+	return mc, "skipped providers without image support: " + strings.Join(skipped, ", ")
+}
+
+// imagesDigest stands in for the images in the cache key.
+func imagesDigest(images []string) string {
+	h := sha256.New()
+	for _, img := range images {
+		h.Write([]byte(img))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// -------------------- Judge JSON repair --------------------
+
+// Shapes the judge is asked for, shown to the repair model.
+const (
+	judgeScoresShape = `[{"idx":0,"score":7,"notes":"..."}]`
+	judgeWinnerShape = `{"winner":"A","notes":"..."}`
+)
+
+// decodeJudge unmarshals the judge's output into v. If that fails and
+// cfg.JudgeRepairModel is set, the raw text goes to that model once to be
+// rewritten as JSON shaped like shape; the original error stands if the
+// repair doesn't parse either.
+func decodeJudge(ctx context.Context, g Generator, raw, shape string, v any) error {
+	err := json.Unmarshal([]byte(raw), v)
+	if err == nil || cfg.JudgeRepairModel == "" || ctx.Err() != nil {
+		return err
+	}
+	fixed, rerr := g.Generate(ctx, cfg.JudgeRepairModel, repairPrompt(raw, shape), judgeOptions())
+	if rerr != nil {
+		log.Printf("judge JSON repair failed: %v", rerr)
+		return err
+	}
+	if rerr := json.Unmarshal([]byte(unfenceJSON(fixed)), v); rerr != nil {
+		log.Printf("judge JSON repair returned invalid JSON: %v", rerr)
+		return err
+	}
+	return nil
+}
+
+func repairPrompt(raw, shape string) string {
+	return fmt.Sprintf("Rewrite the text below as valid JSON with exactly this shape: %s\n"+
+		"Keep the values it contains; do not add or invent any. Return ONLY the JSON.\n\nText:\n%s\n", shape, raw)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJudgeRepairsMalformedOutput(t *testing.T) {
+	malformed := []string{
+		"```json\n[{\"idx\":0,\"score\":7}]\n```",
+		"Here are the scores:\n[{\"idx\":0,\"score\":7}]",
+		"[{'idx': 0, 'score': 7}]",
+		`[{"idx":0,"score":7,}]`,
+		`[{"idx":0,"score":7`,
+		"idx 0 gets 7/10",
+	}
+	repaired := []string{`[{"idx":0,"score":7}]`, "```json\n[{\"idx\":0,\"score\":7}]\n```"}
+	for _, raw := range malformed {
+		for _, fix := range repaired {
+			testConfig(t, func(c *config) { c.JudgeRepairModel = "fixer" })
+			g := &fakeGenerator{respond: func(model, prompt string) (string, error) {
+				if model != "fixer" || !strings.Contains(prompt, raw) || !strings.Contains(prompt, judgeScoresShape) {
+					t.Errorf("repair call to %s with prompt %q", model, prompt)
+				}
+				return fix, nil
+			}}
+			var out []struct{ Idx, Score int }
+			if err := decodeJudge(context.Background(), g, raw, judgeScoresShape, &out); err != nil {
+				t.Errorf("%q repaired as %q: %v", raw, fix, err)
+				continue
+			}
+			if len(out) != 1 || out[0].Score != 7 {
+				t.Errorf("%q decoded to %+v", raw, out)
+			}
+		}
+	}
+}
+
+func TestDecodeJudgeKeepsErrorWhenRepairFails(t *testing.T) {
+	testConfig(t, func(c *config) { c.JudgeRepairModel = "fixer" })
+	g := &fakeGenerator{respond: func(model, prompt string) (string, error) { return "still not json", nil }}
+	var out []scored
+	if err := decodeJudge(context.Background(), g, "score: 7", judgeScoresShape, &out); err == nil {
+		t.Error("an unrepairable judge output should fail")
+	}
+	if len(g.calls) != 1 {
+		t.Errorf("%d repair calls, want exactly one", len(g.calls))
+	}
+}
+
+func TestDecodeJudgeWithoutRepairModel(t *testing.T) {
+	testConfig(t, func(c *config) { c.JudgeRepairModel = "" })
+	g := &fakeGenerator{respond: func(model, prompt string) (string, error) { return `{"winner":"A"}`, nil }}
+	var out struct{ Winner string }
+	if err := decodeJudge(context.Background(), g, "A is better", judgeWinnerShape, &out); err == nil {
+		t.Error("malformed output without a repair model should fail")
+	}
+	if len(g.calls) != 0 {
+		t.Errorf("%d model calls without a repair model", len(g.calls))
+	}
+	if err := decodeJudge(context.Background(), g, `{"winner":"B"}`, judgeWinnerShape, &out); err != nil || out.Winner != "B" {
+		t.Errorf("valid output: %v, %+v", err, out)
+	}
+}
+
+func TestJudgeUsesRepairedScores(t *testing.T) {
+	testConfig(t, func(c *config) { c.JudgeRepairModel = "fixer" })
+	g := &fakeGenerator{respond: func(model, prompt string) (string, error) {
+		if model == "fixer" {
+			return `[{"idx":1,"score":9},{"idx":0,"score":3}]`, nil
+		}
+		return "Answer 1 is clearly better (9/10); answer 0 gets 3.", nil
+	}}
+	out, err := judgeCandidates(context.Background(), g, "judge", "q", []Candidate{{Provider: "a", Text: "x"}, {Provider: "b", Text: "y"}})
+	if err != nil || len(out) != 2 || out[0].Idx != 1 {
+		t.Errorf("judge with repair: %+v, %v", out, err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+)
+
+// -------------------- Judge self-scoring --------------------
+
+// defaultJudgeModel judges candidates and runs synthesis.
+const defaultJudgeModel = "llama3.2"
+
+// judgeModel is the model that judges this request: its judge_model, or
+// defaultJudgeModel.
+func (r AnswerRequest) judgeModel() string {
+	if r.JudgeModel != "" {
+		return r.JudgeModel
+	}
+	return defaultJudgeModel
+}
+
+// checkJudgeModel rejects a judge_model that isn't the default judge or a
+// model some mode already runs, so requests can't pull arbitrary models
+// onto the Ollama host.
+func checkJudgeModel(req AnswerRequest) error {
+	m := req.JudgeModel
+	if m == "" || m == defaultJudgeModel || slices.Contains(configuredModels(), m) {
+		return nil
+	}
+	return fmt.Errorf("judge_model %q is not a configured model", m)
+}
+
+// judge_self values
+const (
+	judgeSelfAllow   = "allow"
+	judgeSelfExclude = "exclude"
+)
+
+// markJudgeOverlap flags candidates produced by the judge's own model, which
+// would otherwise be scoring itself. The flag shows up in the debug trace
+// and drives judge_self: exclude.
+func markJudgeOverlap(cands []Candidate, judgeModel string) {
+	for i := range cands {
+		cands[i].judgeSelf = cands[i].model == judgeModel
+	}
+}
+
+// judgeSubset scores only the candidates skip doesn't match (the judge's
+// own answers under judge_self: exclude, and anything the pre-filter
+// dropped), mapping indices back to cands. Skipped answers get no score, so
+// they can't win. If every candidate is skipped there is nothing to prefer
+// over them, and all are scored.
+func judgeSubset(cands []Candidate, skip func(Candidate) bool, judge func([]Candidate) ([]scored, error)) ([]scored, error) {
+	var pool []Candidate
+	var idx []int
+	for i, c := range cands {
+		if !skip(c) {
+			pool = append(pool, c)
+			idx = append(idx, i)
+		}
+	}
+	if len(pool) == 0 || len(pool) == len(cands) {
+		return judge(cands)
+	}
+	scores, err := judge(pool)
+	if err != nil {
+		return nil, err
+	}
+	for i := range scores {
+		scores[i].Idx = idx[scores[i].Idx]
+	}
+	return scores, nil
+}
+
+// warnJudgeOverlap logs at startup when a mode's ensemble includes the judge
+// model and its answers are being scored by itself.
+func warnJudgeOverlap() {
+	if cfg.JudgeSelf == judgeSelfExclude {
+		return
+	}
+	for name, m := range cfg.Modes {
+		for _, p := range m.Providers {
+			if p.Model == defaultJudgeModel {
+				log.Printf("warning: %s mode provider %s uses the judge model %s and will score its own answers (set judge_self to %q to exclude them)", name, p.displayName(), defaultJudgeModel, judgeSelfExclude)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// -------------------- Provider labels --------------------
+
+// maxProviderLabels bounds the distinct provider names across all modes.
+// Each is a label value on the per-provider metrics, so an unbounded set
+// (one per versioned model tag, say) would swell every scrape.
+const maxProviderLabels = 32
+
+// labelProviders names the providers that have no name of their own from
+// cfg.ProviderLabels (model -> label), so "llama3.2:latest" can show up as
+// "llama" in candidates, the judge prompt and metrics. A temperature suffix
+// still applies, as it would to the model. Providers named in config, or
+// whose model has no label, are left as they are. It returns a copy.
+func labelProviders(ps []provider, labels map[string]string) []provider {
+	if len(labels) == 0 {
+		return ps
+	}
+	out := make([]provider, len(ps))
+	for i, p := range ps {
+		if l, ok := labels[p.Model]; ok && p.Name == "" {
+			p.Name = provider{Model: l, Options: p.Options}.displayName()
+		}
+		out[i] = p
+	}
+	return out
+}
+
+// checkProviderLabels rejects empty labels and more than maxProviderLabels
+// provider names over all modes.
+func checkProviderLabels(c *config) error {
+	for model, l := range c.ProviderLabels {
+		if strings.TrimSpace(l) == "" {
+			return fmt.Errorf("provider_labels: model %q has an empty label", model)
+		}
+	}
+	names := map[string]bool{}
+	for _, m := range c.Modes {
+		for _, p := range m.Providers {
+			names[p.displayName()] = true
+		}
+		for _, p := range m.FallbackProviders {
+			names[p.displayName()] = true
+		}
+	}
+	if len(names) > maxProviderLabels {
+		return fmt.Errorf("%d distinct provider names across modes; at most %d (use provider_labels or name to share them)", len(names), maxProviderLabels)
+	}
+	return nil
+}
+
+// providerCounts tallies answers and failures per provider name for
+// /metrics. Answers served from the candidate cache are tallied apart, as
+// cacheHits: no call was made, but the provider still took part.
+var providerCounts = struct {
+	sync.Mutex
+	answers, failures, cacheHits map[string]int64
+}{answers: map[string]int64{}, failures: map[string]int64{}, cacheHits: map[string]int64{}}
+
+// countCandidateHit records an answer name gave from the candidate cache.
+func countCandidateHit(name string) {
+	providerCounts.Lock()
+	defer providerCounts.Unlock()
+	providerCounts.cacheHits[name]++
+}
+
+// countProvider records one provider call's outcome.
+func countProvider(name string, ok bool) {
+	providerCounts.Lock()
+	defer providerCounts.Unlock()
+	if ok {
+		providerCounts.answers[name]++
+	} else {
+		providerCounts.failures[name]++
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeProviderMetric writes a counter with one series per provider.
+func writeProviderMetric(w http.ResponseWriter, name, help string, counts map[string]int64) {
+	providerCounts.Lock()
+	names := make([]string, 0, len(counts))
+	for p := range counts {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, p := range names {
+		fmt.Fprintf(w, "%s{provider=\"%s\"} %d\n", name, labelEscaper.Replace(p), counts[p])
+	}
+	providerCounts.Unlock()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// -------------------- Candidate language consistency --------------------
+
+// language_check values
+const (
+	languageCheckIgnore = "ignore"
+	languageCheckFlag   = "flag"
+	languageCheckDrop   = "drop"
+)
+
+// checkLanguages finds the language most candidates answered in and the
+// outliers that answered in another (provider -> language code). Candidates
+// too short to classify never count as outliers, and with no clear majority
+// nothing is reported. Under "drop" the outliers are removed from cands.
+// The note is for the response's notes; empty when nothing was found.
+func checkLanguages(cands []Candidate) (kept []Candidate, outliers map[string]string, note string) {
+	if cfg.LanguageCheck == languageCheckIgnore || len(cands) < 2 {
+		return cands, nil, ""
+	}
+	langs := make([]string, len(cands))
+	counts := map[string]int{}
+	detected := 0
+	for i, c := range cands {
+		if langs[i] = detectLanguage(c.Text); langs[i] != "" {
+			counts[langs[i]]++
+			detected++
+		}
+	}
+	dominant := ""
+	for code, n := range counts {
+		if 2*n > detected {
+			dominant = code
+		}
+	}
+	if dominant == "" || counts[dominant] == detected {
+		return cands, nil, ""
+	}
+	outliers = map[string]string{}
+	for i, c := range cands {
+		if langs[i] != "" && langs[i] != dominant {
+			outliers[c.Provider] = langs[i]
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	names := make([]string, 0, len(outliers))
+	for p, code := range outliers {
+		names = append(names, fmt.Sprintf("%s (%s)", p, languageName(code)))
+	}
+	sort.Strings(names)
+	if cfg.LanguageCheck == languageCheckDrop {
+		return kept, outliers, fmt.Sprintf("dropped answers not in %s: %s", languageName(dominant), strings.Join(names, ", "))
+	}
+	return cands, outliers, fmt.Sprintf("answers not in %s: %s", languageName(dominant), strings.Join(names, ", "))
+}
@@ -10,28 +10,313 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type AnswerRequest struct {
-	Prompt string `json:"prompt"`
-	Mode   string `json:"mode"` // "fast" or "quality"
+	Prompt  string `json:"prompt"`
+	Mode    string `json:"mode"`    // "fast" or "quality"
+	Explain bool   `json:"explain"` // include debug info in the response
+
+	// MaxAnswerChars caps Final (clamped to the server's max_answer_chars).
+	// Longer answers are cut near a sentence end and marked as truncated.
+	MaxAnswerChars int `json:"max_answer_chars,omitempty"`
+
+	// Judge and Synthesize switch pipeline stages off when set to false.
+	// Left unset, the mode decides: quality always judges and synthesizes;
+	// fast judges only when candidates differ noticeably, and the non-stream
+	// endpoint synthesizes only short answers. judge:false returns fastPick's
+	// choice in either mode (so synthesis never runs either); synthesize:false
+	// returns the top judged candidate as-is.
+	Judge      *bool `json:"judge,omitempty"`
+	Synthesize *bool `json:"synthesize,omitempty"`
+
+	// NFinal > 1 returns the top N judged candidates in Finals (Final is the
+	// first) instead of one synthesized answer. SynthesizeEach runs a
+	// synthesis pass on each of them.
+	NFinal         int  `json:"n_final,omitempty"`
+	SynthesizeEach bool `json:"synthesize_each,omitempty"`
+
+	// ResponseSchema asks for JSON output: either the string "json" or a JSON
+	// schema, passed to Ollama as format. Final must then parse as JSON. On
+	// /answer/stream the body is not streamed token by token in this mode;
+	// it arrives whole in the closing meta.
+	ResponseSchema json.RawMessage `json:"response_schema,omitempty"`
+
+	// Images are base64-encoded attachments for vision models. Only
+	// providers marked multimodal see the request; the rest are skipped
+	// with a note.
+	Images []string `json:"images,omitempty"`
+
+	// TargetLanguage ("fr", "German", ...) has the final answer translated
+	// after synthesis unless it already reads as that language. On
+	// /answer/stream the answer is then sent whole rather than token by token.
+	TargetLanguage string `json:"target_language,omitempty"`
+
+	// CodeFormatting asks every model to put code in closed, language-tagged
+	// fences. A non-streamed synthesis that breaks the rule is retried once.
+	CodeFormatting bool `json:"code_formatting,omitempty"`
+
+	// StrictDeadline turns any answer that ran out of time (fallback picks,
+	// skipped judging or synthesis, fewer candidates) into a 504, or an error
+	// line on the stream, so the client can retry instead of getting a
+	// quietly degraded answer.
+	StrictDeadline bool `json:"strict_deadline,omitempty"`
+
+	// IncludeScores adds the judge's per-candidate scores to the response
+	// (explain does too).
+	IncludeScores bool `json:"include_scores,omitempty"`
+
+	// Fields limits the response to these top-level fields (e.g. "final",
+	// "mode"); empty returns everything. The fields query parameter, as a
+	// comma-separated list, takes precedence.
+	Fields []string `json:"fields,omitempty"`
+
+	// SessionID ties follow-up turns together so each provider can continue
+	// from the Ollama context of its previous answer (session_context).
+	SessionID string `json:"session_id,omitempty"`
+
+	// JudgeModel replaces defaultJudgeModel for judging this request (not
+	// synthesis), for comparing judges on live traffic. It must be one of
+	// the configured models; see checkJudgeModel.
+	JudgeModel string `json:"judge_model,omitempty"`
+
+	// StreamCandidates (/answer/stream only) streams every provider's answer
+	// as it is written, as candidate_delta lines tagged with the provider,
+	// each provider ending with a candidate_done line. Under confidence_gate
+	// the lines are held back until the answer passes the gate, and never
+	// sent for one escalated to a human.
+	StreamCandidates bool `json:"stream_candidates,omitempty"`
+
+	// ReasoningStyle is "direct" (no enumerated steps), "stepwise" (always
+	// steps) or "auto" (the model decides, the default), for both candidate
+	// and synthesis prompts.
+	ReasoningStyle string `json:"reasoning_style,omitempty"`
+
+	// Validate checks the synthesized answer's shape (list length, a
+	// required pattern, valid JSON), retrying synthesis with the reason
+	// (validate_retries) and falling back to the best candidate with a note.
+	// Answers that skip synthesis aren't checked. On /answer/stream the
+	// answer is sent whole.
+	Validate *validateSpec `json:"validate,omitempty"`
+
+	// MaxAgeSeconds, like Cache-Control: max-age, skips a cached answer
+	// older than this even if the mode's TTL hasn't expired it; 0 always
+	// regenerates. It only narrows the TTL and doesn't change what's stored:
+	// the fresh answer is cached with the mode's usual TTL.
+	MaxAgeSeconds *int `json:"max_age_seconds,omitempty"`
+
+	// PreserveSources has synthesis keep and consolidate the citations and
+	// links found in the candidates; SourcesSection (which implies it) also
+	// asks for a combined "Sources" list at the end.
+	PreserveSources bool `json:"preserve_sources,omitempty"`
+	SourcesSection  bool `json:"sources_section,omitempty"`
+
+	// SurfaceDisagreement has synthesis spell out conflicting claims
+	// ("Sources differ: ...") instead of merging them away, when the answers
+	// it combines agree less than disagreement_threshold.
+	SurfaceDisagreement bool `json:"surface_disagreement,omitempty"`
+
+	// Variations asks for up to maxVariations rephrasings of the final
+	// answer, each in a different tone or structure, returned alongside it.
+	// They are generated fresh every time and never cached.
+	Variations int `json:"variations,omitempty"`
+
+	// Voice picks a configured persona for the answer's tone; "terse" (the
+	// default) keeps the built-in concise style. See persona.go.
+	Voice string `json:"voice,omitempty"`
+
+	// MaxCandidates caps how many candidates the response lists (the one
+	// the answer came from first, then the best-scored or fastest); the
+	// rest still take part. 0 uses the server's max_candidates.
+	MaxCandidates int `json:"max_candidates,omitempty"`
+
+	// ContextDocs are retrieved snippets, most relevant first, that every
+	// model is asked to ground its answer in and cite by number. They are
+	// bounded by max_context_chars, dropping the last ones first. See
+	// grounding.go.
+	ContextDocs []string `json:"context_docs,omitempty"`
+
+	caller string // who sent it, see caller; scopes session_id
+}
+
+func (r AnswerRequest) judgeEnabled() bool { return r.Judge == nil || *r.Judge }
+func (r AnswerRequest) synthEnabled() bool { return r.Synthesize == nil || *r.Synthesize }
+func (r AnswerRequest) wantScores() bool   { return r.IncludeScores || r.Explain }
+
+// maxAge is max_age_seconds as a duration, nil when unset.
+func (r AnswerRequest) maxAge() *time.Duration {
+	if r.MaxAgeSeconds == nil {
+		return nil
+	}
+	d := time.Duration(max(*r.MaxAgeSeconds, 0)) * time.Second
+	return &d
 }
 
 type Candidate struct {
 	Provider  string `json:"provider"`
 	Text      string `json:"text"`
 	LatencyMs int64  `json:"latency_ms"`
+
+	raw             string          // model output before reasoning sections were stripped
+	promptTruncated bool            // the provider only saw the tail of the prompt (max_prompt_chars)
+	model           string          // Ollama model that produced the answer
+	judgeSelf       bool            // model is also the judge (see markJudgeOverlap)
+	judgeSkip       string          // why the judge pre-filter left it out, if it did
+	group           string          // provider group, see combineGroups
+	altModel        bool            // answered by the provider's alt_model after the primary failed
+	priority        int             // provider priority, fastPick's tie-breaker
+	refusal         bool            // matched refusal_patterns, see markRefusals
+	ollamaRaw       json.RawMessage // Ollama's full response, with explain
+	selfRating      *float64        // the model's own 0-1 confidence, under confidence_gate
 }
 
 type AnswerResponse struct {
-	Final      string      `json:"final"`
-	Candidates []Candidate `json:"candidates"`
-	Cached     bool        `json:"cached"`
-	Mode       string      `json:"mode"`
+	Version    int           `json:"version"` // envelope version, see responseVersion
+	Final      string        `json:"final"`
+	Candidates []Candidate   `json:"candidates"`
+	Cached     bool          `json:"cached"`
+	Mode       string        `json:"mode"`
+	Source     string        `json:"source"` // "synthesis", "cache", "unavailable", or the provider name Final came from
+	Truncated  bool          `json:"truncated,omitempty"`
+	Notes      []string      `json:"notes,omitempty"`       // pipeline decisions worth telling the client about
+	Finals     []finalOption `json:"finals,omitempty"`      // ranked options when n_final > 1
+	CacheTTLs  int64         `json:"cache_ttl_s,omitempty"` // how long this answer is cached for
+	Degraded   bool          `json:"degraded,omitempty"`    // fewer providers answered than the mode requires
+	Scores     []judgeScore  `json:"scores,omitempty"`      // judge ranking, on request; absent when no judge ran
+	Timings    *phaseTimings `json:"timings,omitempty"`
+	// Refused: every model refused the prompt. Final is empty and Source
+	// is "refused"; the refusals are the candidates.
+	Refused bool `json:"refused,omitempty"`
+	// Variations are rephrasings of Final, on request; never cached.
+	Variations []string `json:"variations,omitempty"`
+	// BudgetExceeded: the mode's max_tokens ran out, so later stages were skipped.
+	BudgetExceeded bool `json:"budget_exceeded,omitempty"`
+	// Confidence (0-1) is set under confidence_gate; NeedsHuman means it
+	// fell below the gate and Final is the escalation message.
+	Confidence *float64 `json:"confidence,omitempty"`
+	NeedsHuman bool     `json:"needs_human,omitempty"`
+	// Agreement is the candidates' mean pairwise word overlap (0-1), which
+	// cache_min_agreement and confidence_ttl go by. CacheDecision is
+	// "cached" when the answer was stored, else why not (see confidence.go).
+	// Both describe the answer when it was generated, so a cache hit
+	// repeats them.
+	Agreement     *float64 `json:"agreement,omitempty"`
+	CacheDecision string   `json:"cache_decision,omitempty"`
+
+	scores   []scored   // judge ranking, when judging ran
+	fallback bool       // Final stands in for a stage that failed or was skipped; see cache_fallbacks
+	Debug    *debugInfo `json:"debug,omitempty"`
+}
+
+// phaseTimings breaks down where a request's time went, in milliseconds.
+// Stages that didn't run stay 0; a cache hit only has total_ms.
+type phaseTimings struct {
+	FanoutMs int64 `json:"fanout_ms"`
+	JudgeMs  int64 `json:"judge_ms"`
+	SynthMs  int64 `json:"synth_ms"`
+	TotalMs  int64 `json:"total_ms"`
+}
+
+// track adds the time since start to *ms.
+func track(ms *int64, start time.Time) { *ms += time.Since(start).Milliseconds() }
+
+// judgeScore is one candidate's judge verdict, best first.
+type judgeScore struct {
+	Provider string `json:"provider"`
+	Score    int    `json:"score"`
+	Notes    string `json:"notes,omitempty"`
+}
+
+func judgeScores(cands []Candidate, scores []scored) []judgeScore {
+	var out []judgeScore
+	for _, s := range scores {
+		out = append(out, judgeScore{Provider: cands[s.Idx].Provider, Score: s.Score, Notes: s.Notes})
+	}
+	return out
+}
+
+// debugInfo is only attached when the request sets explain.
+type debugInfo struct {
+	RawCandidates map[string]string `json:"raw_candidates,omitempty"` // provider -> unstripped text, when stripping changed it
+	RawFinal      string            `json:"raw_final,omitempty"`
+	// TruncatedPrompts lists providers that got a shortened prompt.
+	TruncatedPrompts []string `json:"truncated_prompts,omitempty"`
+	// Agreement is the candidates' mean pairwise word overlap (0-1), the
+	// signal behind confidence_ttl and cache_min_agreement.
+	Agreement float64 `json:"agreement"`
+	// JudgeOverlap lists providers whose model is also the judge.
+	JudgeOverlap []string `json:"judge_overlap,omitempty"`
+	// JudgeFiltered maps providers the judge pre-filter skipped to the reason.
+	JudgeFiltered map[string]string `json:"judge_filtered,omitempty"`
+	// LanguageOutliers maps providers that answered in a language other
+	// than the majority's to that language (dropped under language_check: drop).
+	LanguageOutliers map[string]string `json:"language_outliers,omitempty"`
+	// AltModels maps providers that fell back to their alt_model to it.
+	AltModels map[string]string `json:"alt_models,omitempty"`
+	// OllamaResponses holds each provider's raw Ollama response (for a
+	// streamed one, the final chunk with done_reason and timings).
+	OllamaResponses map[string]json.RawMessage `json:"ollama_responses,omitempty"`
+}
+
+func newDebugInfo(cands []Candidate, rawFinal string) *debugInfo {
+	d := &debugInfo{RawFinal: rawFinal, Agreement: agreement(cands)}
+	for _, c := range cands {
+		if c.raw != "" && c.raw != c.Text {
+			if d.RawCandidates == nil {
+				d.RawCandidates = map[string]string{}
+			}
+			d.RawCandidates[c.Provider] = c.raw
+		}
+		if c.promptTruncated {
+			d.TruncatedPrompts = append(d.TruncatedPrompts, c.Provider)
+		}
+		if c.judgeSelf {
+			d.JudgeOverlap = append(d.JudgeOverlap, c.Provider)
+		}
+		if c.ollamaRaw != nil {
+			if d.OllamaResponses == nil {
+				d.OllamaResponses = map[string]json.RawMessage{}
+			}
+			d.OllamaResponses[c.Provider] = c.ollamaRaw
+		}
+		if c.altModel {
+			if d.AltModels == nil {
+				d.AltModels = map[string]string{}
+			}
+			d.AltModels[c.Provider] = c.model
+		}
+		if c.judgeSkip != "" {
+			if d.JudgeFiltered == nil {
+				d.JudgeFiltered = map[string]string{}
+			}
+			d.JudgeFiltered[c.Provider] = c.judgeSkip
+		}
+	}
+	return d
+}
+
+const (
+	sourceSynthesis   = "synthesis"
+	sourceCache       = "cache"
+	sourceUnavailable = "unavailable"
+	sourceRefused     = "refused"
+)
+
+// unavailableResponse is the canned no_answer_message answer given when no
+// model responded. It is never cached.
+func unavailableResponse(mode string) AnswerResponse {
+	return AnswerResponse{Final: cfg.NoAnswerMessage, Candidates: []Candidate{}, Mode: mode, Source: sourceUnavailable, Degraded: true, Notes: []string{"no model responded"}}
 }
 
 type errResp struct {
@@ -47,8 +332,11 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 // -------------------- Cache (in-memory TTL) --------------------
 
 type cacheItem struct {
-	val AnswerResponse
-	exp time.Time
+	val    AnswerResponse
+	gz     []byte // val gzipped instead, with compress_cache
+	exp    time.Time
+	stored time.Time
+	seq    uint64 // write order, see cachewrite.go
 }
 
 var (
@@ -56,92 +344,267 @@ var (
 	cacheMap = map[string]cacheItem{}
 )
 
-func cacheKey(prompt, mode string) string {
-	sum := sha256.Sum256([]byte(mode + "::" + prompt))
+// cache_scope values
+const (
+	cacheScopeGlobal = "global"
+	cacheScopeTenant = "tenant"
+)
+
+// cacheKey hashes the prompt and mode plus any request options that change
+// the stored answer. With no variants the key matches older builds.
+func cacheKey(prompt, mode string, variants ...string) string {
+	s := mode + "::" + prompt
+	for _, v := range variants {
+		s += "::" + v
+	}
+	sum := sha256.Sum256([]byte(s))
 	return fmt.Sprintf("%x", sum[:])
 }
 
-func cacheGet(key string) (AnswerResponse, bool) {
-	cacheMu.RLock()
-	it, ok := cacheMap[key]
-	cacheMu.RUnlock()
-	if !ok || time.Now().After(it.exp) {
+// requestCacheKey derives the cache key for a validated request. sample is
+// the providers drawn for it when its mode samples (nil otherwise).
+func requestCacheKey(req AnswerRequest, mode, tenant string, sample []provider) string {
+	var variants []string
+	if sample != nil {
+		names := make([]string, len(sample))
+		for i, p := range sample {
+			names[i] = p.displayName()
+		}
+		variants = append(variants, "providers="+strings.Join(names, ","))
+	}
+	if cfg.CacheScope == cacheScopeTenant {
+		// hashed with the rest, so the raw key never sits in memory as a map key
+		variants = append(variants, "tenant="+tenant)
+	}
+	if n := answerCharLimit(req); n > 0 {
+		variants = append(variants, "max_chars="+strconv.Itoa(n))
+	}
+	if !req.judgeEnabled() {
+		variants = append(variants, "judge=false")
+	}
+	if !req.synthEnabled() {
+		variants = append(variants, "synthesize=false")
+	}
+	if n := req.finalCount(); n > 1 {
+		variants = append(variants, "n_final="+strconv.Itoa(n))
+		if req.SynthesizeEach {
+			variants = append(variants, "synthesize_each")
+		}
+	}
+	if req.structured() {
+		variants = append(variants, "format="+string(req.ResponseSchema))
+	}
+	if len(req.Images) > 0 {
+		variants = append(variants, "images="+imagesDigest(req.Images))
+	}
+	if len(req.ContextDocs) > 0 {
+		variants = append(variants, "context="+imagesDigest(req.ContextDocs))
+	}
+	if req.CodeFormatting {
+		variants = append(variants, "code_formatting")
+	}
+	if lang := languageCode(req.TargetLanguage); lang != "" {
+		variants = append(variants, "lang="+lang)
+	}
+	if req.Validate != nil {
+		b, _ := json.Marshal(req.Validate)
+		variants = append(variants, "validate="+string(b))
+	}
+	if req.ReasoningStyle != "" && req.ReasoningStyle != styleAuto {
+		variants = append(variants, "style="+req.ReasoningStyle)
+	}
+	if req.JudgeModel != "" && req.JudgeModel != defaultJudgeModel {
+		variants = append(variants, "judge_model="+req.JudgeModel)
+	}
+	if cfg.SessionContext && req.SessionID != "" {
+		variants = append(variants, "session="+req.caller+"/"+req.SessionID)
+	}
+	if req.SurfaceDisagreement {
+		variants = append(variants, "disagreement")
+	}
+	if req.StrictDeadline {
+		// lenient requests may cache a budgetLow fast pick that a strict
+		// one would have been refused
+		variants = append(variants, "strict_deadline")
+	}
+	if req.Voice != "" && req.Voice != personaTerse {
+		variants = append(variants, "voice="+req.Voice)
+	}
+	if req.SourcesSection {
+		variants = append(variants, "sources+section")
+	} else if req.PreserveSources {
+		variants = append(variants, "sources")
+	}
+	prompt := req.Prompt
+	if cfg.NormalizeCacheKey {
+		prompt = strings.Join(strings.Fields(strings.ToLower(prompt)), " ")
+	}
+	return cacheKey(prompt, mode, variants...)
+}
+
+// cacheGet returns the entry for key unless it has expired or, when maxAge
+// is non-nil, is older than *maxAge (a request's max_age_seconds). A
+// too-old entry is left in place; the regenerated answer replaces it.
+func cacheGet(key string, maxAge *time.Duration) (AnswerResponse, bool) {
+	it, ok := pendingCacheItem(key)
+	if !ok {
+		cacheMu.RLock()
+		it, ok = cacheMap[key]
+		cacheMu.RUnlock()
+	}
+	if !ok || time.Now().After(it.exp) || maxAge != nil && time.Since(it.stored) > *maxAge {
 		return AnswerResponse{}, false
 	}
+	if it.gz != nil {
+		v, err := gunzipResponse(it.gz)
+		if err != nil {
+			log.Printf("cache: dropping unreadable entry: %v", err)
+			return AnswerResponse{}, false
+		}
+		return v, true
+	}
 	return it.val, true
 }
 
+// servedFromCache readies a cached answer for req. Neither scores nor debug
+// info is in the cache key, so whichever request filled the entry, they are
+// rebuilt or kept for the requests that ask and dropped for the rest.
+func servedFromCache(v *AnswerResponse, req AnswerRequest, start time.Time) {
+	v.Cached = true
+	v.Source = sourceCache
+	v.Timings = &phaseTimings{TotalMs: time.Since(start).Milliseconds()}
+	v.Scores = nil
+	if req.wantScores() {
+		v.Scores = judgeScores(v.Candidates, v.scores)
+	}
+	if !req.Explain {
+		v.Debug = nil
+	}
+}
+
+// cacheSet stores val under key for ttl, by way of the cache writer (see
+// cachewrite.go).
 func cacheSet(key string, val AnswerResponse, ttl time.Duration) {
-	cacheMu.Lock()
-	cacheMap[key] = cacheItem{val: val, exp: time.Now().Add(ttl)}
-	cacheMu.Unlock()
+	now := time.Now()
+	queueCacheWrite(key, cacheItem{val: val, exp: now.Add(ttl), stored: now})
 }
 
 // -------------------- Ollama client --------------------
 
 type ollamaGenerateReq struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
+	Model     string          `json:"model"`
+	Prompt    string          `json:"prompt"`
+	Stream    bool            `json:"stream"`
+	Options   map[string]any  `json:"options,omitempty"`    // passed through as Ollama model options (temperature, seed, ...)
+	KeepAlive any             `json:"keep_alive,omitempty"` // duration string ("5m", "0") or seconds; negative keeps the model loaded
+	Format    json.RawMessage `json:"format,omitempty"`     // "json" or a JSON schema for structured output
+	Images    []string        `json:"images,omitempty"`     // base64, for multimodal models
+	Context   []int           `json:"context,omitempty"`    // from a previous response, to continue that conversation
+}
+
+// genOptions carries per-call generation settings through the Generator.
+type genOptions struct {
+	Options   map[string]any
+	KeepAlive any                   // nil leaves Ollama's default (5m)
+	Format    json.RawMessage       // structured output; nil for free text
+	Images    []string              // base64 image attachments
+	Context   []int                 // conversation state from an earlier call; see sessions.go
+	OnContext func([]int)           // receives the context Ollama returns, if set
+	OnUsage   func(int)             // receives the generated token count (eval_count), if set
+	OnRaw     func(json.RawMessage) // receives Ollama's raw response (the final chunk when streaming), if set
 }
 
 type ollamaGenerateResp struct {
-	Response string `json:"response"`
+	Response  string `json:"response"`
+	Context   []int  `json:"context"`
+	EvalCount int    `json:"eval_count"`
 }
 
 type ollamaStreamResp struct {
-	Response string `json:"response"`
-	Done     bool   `json:"done"`
+	Response  string `json:"response"`
+	Done      bool   `json:"done"`
+	Context   []int  `json:"context"`    // on the final chunk
+	EvalCount int    `json:"eval_count"` // on the final chunk
 	// there are other fields, we ignore them
 }
 
-func ollamaGenerate(ctx context.Context, model, prompt string) (string, error) {
-	body, _ := json.Marshal(ollamaGenerateReq{Model: model, Prompt: prompt, Stream: false})
+// ollamaGenerateURL is Ollama's generate endpoint; a var so tests can point
+// the client at a stand-in server.
+var ollamaGenerateURL = "http://localhost:11434/api/generate"
 
-	req, err := http.NewRequestWithContext(ctx, "POST", "http://localhost:11434/api/generate", bytes.NewReader(body))
+func ollamaGenerate(ctx context.Context, model, prompt string, o genOptions) (string, error) {
+	body, _ := json.Marshal(ollamaGenerateReq{Model: model, Prompt: prompt, Stream: false, Options: o.Options, KeepAlive: o.KeepAlive, Format: o.Format, Images: o.Images, Context: o.Context})
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ollamaGenerateURL, bytes.NewReader(body))
 	if err != nil {
 		return "", err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setOllamaHeaders(req)
 
 	cli := &http.Client{Timeout: 180 * time.Second}
 	resp, err := cli.Do(req)
 	if err != nil {
-		return "", err
+		return "", ollamaDoError(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", fmt.Errorf("ollama non-2xx: %s", resp.Status)
+		return "", ollamaStatusError(resp, model)
 	}
 
+	var rawResp json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&rawResp); err != nil {
+		return "", err
+	}
 	var out ollamaGenerateResp
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+	if err := json.Unmarshal(rawResp, &out); err != nil {
 		return "", err
 	}
+	if o.OnRaw != nil {
+		o.OnRaw(rawResp)
+	}
+	if o.OnContext != nil {
+		o.OnContext(out.Context)
+	}
+	if o.OnUsage != nil {
+		o.OnUsage(out.EvalCount)
+	}
 	return strings.TrimSpace(out.Response), nil
 }
 
+// setOllamaHeaders adds the configured static headers and API key, for
+// Ollama instances behind an auth proxy.
+func setOllamaHeaders(req *http.Request) {
+	for k, v := range cfg.OllamaHeaders {
+		req.Header.Set(k, v)
+	}
+	if cfg.OllamaAPIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.OllamaAPIKey)
+	}
+}
+
 // Stream: calls Ollama with stream:true, invokes onDelta for each chunk.
 // Returns the full concatenated text too.
-func ollamaGenerateStream(ctx context.Context, model, prompt string, onDelta func(string) error) (string, error) {
-	body, _ := json.Marshal(ollamaGenerateReq{Model: model, Prompt: prompt, Stream: true})
+func ollamaGenerateStream(ctx context.Context, model, prompt string, o genOptions, onDelta func(string) error) (string, error) {
+	body, _ := json.Marshal(ollamaGenerateReq{Model: model, Prompt: prompt, Stream: true, Options: o.Options, KeepAlive: o.KeepAlive, Format: o.Format, Images: o.Images, Context: o.Context})
 
-	req, err := http.NewRequestWithContext(ctx, "POST", "http://localhost:11434/api/generate", bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", ollamaGenerateURL, bytes.NewReader(body))
 	if err != nil {
 		return "", err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setOllamaHeaders(req)
 
 	cli := &http.Client{Timeout: 0} // rely on ctx
 	resp, err := cli.Do(req)
 	if err != nil {
-		return "", err
+		return "", ollamaDoError(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", fmt.Errorf("ollama non-2xx: %s", resp.Status)
+		return "", ollamaStatusError(resp, model)
 	}
 
 	sc := bufio.NewScanner(resp.Body)
@@ -168,6 +631,15 @@ func ollamaGenerateStream(ctx context.Context, model, prompt string, onDelta fun
 			}
 		}
 		if chunk.Done {
+			if o.OnRaw != nil {
+				o.OnRaw(json.RawMessage(line))
+			}
+			if o.OnContext != nil {
+				o.OnContext(chunk.Context)
+			}
+			if o.OnUsage != nil {
+				o.OnUsage(chunk.EvalCount)
+			}
 			break
 		}
 	}
@@ -178,14 +650,97 @@ func ollamaGenerateStream(ctx context.Context, model, prompt string, onDelta fun
 	return strings.TrimSpace(full.String()), nil
 }
 
+// Generator is the model backend the pipeline talks to. The handlers use gen,
+// which defaults to the Ollama HTTP client; tests can swap in a fake that
+// returns canned candidates and judge JSON.
+//
+// GenerateStream is how every streamed stage (synthesis today) reaches the
+// backend, so a non-Ollama implementation must honour the same contract:
+// call onDelta with each text fragment in order, stop and return onDelta's
+// error if it fails, abort when ctx ends, and return the full text.
+type Generator interface {
+	Generate(ctx context.Context, model, prompt string, o genOptions) (string, error)
+	GenerateStream(ctx context.Context, model, prompt string, o genOptions, onDelta func(string) error) (string, error)
+}
+
+type ollamaClient struct{}
+
+func (ollamaClient) Generate(ctx context.Context, model, prompt string, o genOptions) (string, error) {
+	return ollamaGenerate(ctx, model, prompt, o)
+}
+
+func (ollamaClient) GenerateStream(ctx context.Context, model, prompt string, o genOptions, onDelta func(string) error) (string, error) {
+	return ollamaGenerateStream(ctx, model, prompt, o, onDelta)
+}
+
+var gen Generator = ollamaClient{}
+
 // -------------------- Ensemble logic --------------------
 
+// provider is one ensemble member. The same model may appear several times
+// with different options (e.g. temperatures) to self-ensemble; each entry
+// runs independently and needs its own name.
 type provider struct {
-	name  string
-	model string
+	Name    string         `json:"name"` // defaults to model (or its provider_labels entry), or model@t<temperature>
+	Model   string         `json:"model"`
+	Options map[string]any `json:"options,omitempty"`
+
+	// KeepAlive overrides the global keep_alive for this provider's model.
+	KeepAlive any `json:"keep_alive,omitempty"`
+
+	// MaxPromptChars caps the user prompt this provider sees, for models with
+	// a small context window. Longer prompts keep their tail (the latest
+	// turns); 0 means no cap.
+	MaxPromptChars int `json:"max_prompt_chars,omitempty"`
+
+	// Multimodal marks a vision model that can take requests with images.
+	Multimodal bool `json:"multimodal,omitempty"`
+
+	// Group names an entry in the mode's groups; see providerGroup.
+	Group string `json:"group,omitempty"`
+
+	// AltModel is tried once, within the request deadline, when Model
+	// errors or answers empty, so a flaky model doesn't cost the slot. The
+	// substitution is noted in the response.
+	AltModel string `json:"alt_model,omitempty"`
+
+	// Priority breaks fastPick ties: between answers of comparable
+	// structure, the higher-priority provider's wins, ahead of latency.
+	// Default 0.
+	Priority int `json:"priority,omitempty"`
+
+	// Weight biases the "weighted" sample_strategy: a provider of weight 3
+	// is drawn about three times as often as one of weight 1. 0 means 1.
+	Weight float64 `json:"weight,omitempty"`
+}
+
+// displayName returns Name, deriving one from the model and temperature when unset.
+func (p provider) displayName() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	if t, ok := p.Options["temperature"]; ok {
+		return fmt.Sprintf("%s@t%v", p.Model, t)
+	}
+	return p.Model
+}
+
+func (p provider) genOptions() genOptions {
+	o := genOptions{Options: p.Options, KeepAlive: cfg.KeepAlive}
+	if p.KeepAlive != nil {
+		o.KeepAlive = p.KeepAlive
+	}
+	return o
 }
 
-func fanOut(ctx context.Context, providers []provider, userPrompt string) []Candidate {
+// candidateTap receives each provider's output as it is generated, for
+// stream_candidates: text deltas, then one done call with the provider's
+// error, if any. Providers are only streamed for stream_candidates; a tap
+// otherwise just gets the done calls. Calls come from concurrent goroutines.
+type candidateTap func(provider, delta string, done bool, err error)
+
+func fanOut(ctx context.Context, g Generator, providers []provider, req AnswerRequest, tap candidateTap) []Candidate {
+	providers = skipDown(providers)
 	type result struct {
 		c   Candidate
 		err error
@@ -201,18 +756,89 @@ func fanOut(ctx context.Context, providers []provider, userPrompt string) []Cand
 			defer wg.Done()
 			start := time.Now()
 
+			userPrompt, clipped := clipPrompt(req.Prompt, p.MaxPromptChars)
+			if clipped {
+				log.Printf("provider %s: prompt cut to its last %d chars", p.displayName(), p.MaxPromptChars)
+			}
 			prompt := "Answer the user clearly and directly.\n" +
-				"Prefer correct, concise explanations and practical examples when helpful.\n\n" +
-				"User:\n" + userPrompt
-
-			text, err := ollamaGenerate(ctx, p.model, prompt)
+				"Prefer correct, concise explanations and practical examples when helpful.\n" +
+				req.codeRule() + req.styleRule() + req.voiceRule() + req.groundingRule() + selfRatingPrompt() + "\n" +
+				req.contextBlock() + "User:\n" + userPrompt
+
+			o := p.genOptions()
+			o.Format = req.ResponseSchema
+			o.Images = req.Images
+			sessionOptions(&o, req, p)
+			var ollamaRaw json.RawMessage
+			if req.Explain {
+				o.OnRaw = func(m json.RawMessage) { ollamaRaw = m }
+			}
+			generate := func(model string) (string, error) {
+				if tap == nil || !req.StreamCandidates {
+					return g.Generate(ctx, model, prompt, o)
+				}
+				filter := newReasoningFilter(cfg.ReasoningTags)
+				raw, err := g.GenerateStream(ctx, model, prompt, o, func(delta string) error {
+					if d := filter.Write(delta); d != "" {
+						tap(p.displayName(), d, false, nil)
+					}
+					return nil
+				})
+				if d := filter.Flush(); d != "" && err == nil {
+					tap(p.displayName(), d, false, nil)
+				}
+				return strings.TrimSpace(raw), err
+			}
+			model, altUsed := p.Model, false
+			useCache := cfg.CandidateCacheTTL > 0 && o.OnContext == nil
+			var ckey string
+			if useCache {
+				ckey = candidateKey(model, prompt, o)
+				if e, ok := candidateGet(ckey); ok {
+					if !isRefresh(ctx) {
+						countCandidateHit(p.displayName())
+					}
+					text, rating := takeSelfRating(stripReasoning(e.raw))
+					if tap != nil {
+						tap(p.displayName(), text, false, nil)
+						tap(p.displayName(), "", true, nil)
+					}
+					ch <- result{c: Candidate{Provider: p.displayName(), Text: text, LatencyMs: e.latencyMs, raw: e.raw, promptTruncated: clipped, model: model, group: p.Group, priority: p.Priority, selfRating: rating}}
+					return
+				}
+			}
+			raw, err := generate(model)
+			text := stripReasoning(raw)
+			if (err != nil || strings.TrimSpace(text) == "") && p.AltModel != "" && ctx.Err() == nil {
+				log.Printf("provider %s: %s failed (%v); trying alt model %s", p.displayName(), p.Model, err, p.AltModel)
+				model, altUsed = p.AltModel, true
+				// session context is per model: the alt continues from its
+				// own, if any, and saves under its own model, so the primary
+				// never gets tokens the alt produced
+				alt := p
+				alt.Name, alt.Model = p.displayName(), p.AltModel
+				o.Context, o.OnContext = nil, nil
+				sessionOptions(&o, req, alt)
+				raw, err = generate(model)
+				text = stripReasoning(raw)
+			}
+			if tap != nil {
+				tap(p.displayName(), "", true, err)
+			}
 			lat := time.Since(start).Milliseconds()
+			if !isRefresh(ctx) {
+				countProvider(p.displayName(), err == nil && strings.TrimSpace(text) != "")
+			}
 
 			if err != nil || strings.TrimSpace(text) == "" {
 				ch <- result{err: err}
 				return
 			}
-			ch <- result{c: Candidate{Provider: p.name, Text: text, LatencyMs: lat}}
+			if useCache && !altUsed {
+				candidateSet(ckey, raw, lat)
+			}
+			text, rating := takeSelfRating(text)
+			ch <- result{c: Candidate{Provider: p.displayName(), Text: text, LatencyMs: lat, raw: raw, promptTruncated: clipped, model: model, group: p.Group, altModel: altUsed, priority: p.Priority, ollamaRaw: ollamaRaw, selfRating: rating}}
 		}()
 	}
 
@@ -233,29 +859,86 @@ func fanOut(ctx context.Context, providers []provider, userPrompt string) []Cand
 	return cands
 }
 
+// promptOmitted marks where clipPrompt dropped the start of a prompt.
+const promptOmitted = "[earlier conversation omitted]\n"
+
+// clipPrompt keeps the last limit characters of prompt (marker included),
+// starting at a line boundary when one is close enough that little is lost.
+func clipPrompt(prompt string, limit int) (string, bool) {
+	r := []rune(prompt)
+	if limit <= 0 || len(r) <= limit {
+		return prompt, false
+	}
+	keep := max(limit-utf8.RuneCountInString(promptOmitted), 0)
+	tail := string(r[len(r)-keep:])
+	if i := strings.IndexByte(tail, '\n'); i >= 0 && i < len(tail)/4 {
+		tail = tail[i+1:]
+	}
+	return promptOmitted + tail, true
+}
+
+// altModelNotes tells the client which providers answered with their
+// alt_model.
+func altModelNotes(cands []Candidate) []string {
+	var notes []string
+	for _, c := range cands {
+		if c.altModel {
+			notes = append(notes, fmt.Sprintf("%s answered with alt model %s", c.Provider, c.model))
+		}
+	}
+	return notes
+}
+
+// minProviders is how many candidates a request sent to selected providers
+// needs: the mode's MinProviders, or all of them when sampling or the
+// budget left fewer selected than that.
+func minProviders(mc modeConfig, selected []provider) int {
+	return min(mc.MinProviders, len(selected))
+}
+
+// ensureMinProviders tops up a short candidate set from the mode's fallback
+// tier when fewer than minProviders answered (e.g. a model isn't pulled). If
+// it is still short afterwards the answer is reported as degraded, since the
+// mode's quality contract wasn't met.
+func ensureMinProviders(ctx context.Context, g Generator, mc modeConfig, selected []provider, cands []Candidate, req AnswerRequest, tap candidateTap) ([]Candidate, bool, string) {
+	want := minProviders(mc, selected)
+	if len(cands) >= want {
+		return cands, false, ""
+	}
+	if len(mc.FallbackProviders) > 0 {
+		cands = append(cands, fanOut(ctx, g, mc.FallbackProviders, req, tap)...)
+		sort.Slice(cands, func(i, j int) bool { return cands[i].LatencyMs < cands[j].LatencyMs })
+	}
+	if len(cands) >= want {
+		return cands, false, ""
+	}
+	return cands, true, fmt.Sprintf("degraded: %d of the %d providers this mode expects answered", len(cands), want)
+}
+
 type scored struct {
 	Idx   int
 	Score int
 	Notes string
 }
 
-func judgeCandidates(ctx context.Context, judgeModel string, userPrompt string, cands []Candidate) ([]scored, error) {
+// judgeCandidates ranks cands best-first using the configured strategy.
+func judgeCandidates(ctx context.Context, g Generator, judgeModel string, userPrompt string, cands []Candidate) ([]scored, error) {
 	if len(cands) == 0 {
 		return nil, errors.New("no candidates")
 	}
-
-	var b strings.Builder
-	b.WriteString("You are a strict evaluator.\n")
-	b.WriteString("Score each answer 0-10 for correctness + usefulness. Penalize hallucinations.\n")
-	b.WriteString("Return ONLY valid JSON array like: [{\"idx\":0,\"score\":7,\"notes\":\"...\"}, ...]\n\n")
-	b.WriteString("User prompt:\n")
-	b.WriteString(userPrompt)
-	b.WriteString("\n\nAnswers:\n")
-	for i, c := range cands {
-		b.WriteString(fmt.Sprintf("\n[%d] (%s)\n%s\n", i, c.Provider, c.Text))
+	judge := func(cands []Candidate) ([]scored, error) {
+		if cfg.JudgeStrategy == judgePairwise && len(cands) > 1 {
+			return judgeCandidatesPairwise(ctx, g, judgeModel, userPrompt, cands)
+		}
+		return judgeCandidatesAbsolute(ctx, g, judgeModel, userPrompt, cands)
 	}
+	exclude := cfg.JudgeSelf == judgeSelfExclude
+	return judgeSubset(cands, func(c Candidate) bool { return c.judgeSkip != "" || exclude && c.judgeSelf }, judge)
+}
 
-	raw, err := ollamaGenerate(ctx, judgeModel, b.String())
+// judgeCandidatesAbsolute scores all candidates 0-10 in a single judge call.
+func judgeCandidatesAbsolute(ctx context.Context, g Generator, judgeModel string, userPrompt string, cands []Candidate) ([]scored, error) {
+	raw, err := g.Generate(ctx, judgeModel, judgePrompt(userPrompt, cands), judgeOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -265,7 +948,7 @@ func judgeCandidates(ctx context.Context, judgeModel string, userPrompt string,
 		Score int    `json:"score"`
 		Notes string `json:"notes"`
 	}
-	if err := json.Unmarshal([]byte(raw), &arr); err != nil {
+	if err := decodeJudge(ctx, g, raw, judgeScoresShape, &arr); err != nil {
 		return nil, fmt.Errorf("judge returned non-JSON: %s", raw)
 	}
 
@@ -283,41 +966,195 @@ func judgeCandidates(ctx context.Context, judgeModel string, userPrompt string,
 	return out, nil
 }
 
-func synthPrompt(userPrompt string, top []Candidate) string {
+// judgeOptions pins the judge's sampling so scores are stable between runs
+// and it doesn't get creative with the JSON format.
+func judgeOptions() genOptions {
+	o := genOptions{KeepAlive: cfg.KeepAlive}
+	if cfg.JudgeTemperature >= 0 {
+		o.Options = map[string]any{"temperature": cfg.JudgeTemperature, "seed": 0}
+	}
+	return o
+}
+
+func synthOptions() genOptions {
+	o := genOptions{KeepAlive: cfg.KeepAlive, Options: map[string]any{}}
+	if cfg.DeterministicSynth {
+		o.Options["temperature"] = 0
+		o.Options["seed"] = 0
+	}
+	if cfg.SynthNumPredict > 0 {
+		o.Options["num_predict"] = cfg.SynthNumPredict
+	}
+	if len(o.Options) == 0 {
+		o.Options = nil
+	}
+	return o
+}
+
+// synthPrompt builds the merge prompt. top is in rank order and scores, when
+// non-nil, holds the matching judge scores used for the rank annotations.
+func synthPrompt(req AnswerRequest, top []Candidate, scores []scored) string {
+	ranked := cfg.SynthRankHints && len(scores) == len(top)
+	top, _ = synthBudget(req, top)
+
 	var b strings.Builder
 	b.WriteString("Combine the best parts of the answers below into ONE final answer.\n")
-	b.WriteString("Rules: be correct, remove contradictions, be concise, no fluff.\n")
-	b.WriteString("If a step-by-step explanation is helpful, include it.\n\n")
+	b.WriteString(req.synthRules(req.disagrees(top)))
+	if req.disagrees(top) {
+		b.WriteString(disagreementRule)
+	}
+	b.WriteString(req.synthStyleRule())
+	b.WriteString(req.codeRule())
+	b.WriteString(req.sourcesRule())
+	b.WriteString(req.groundingRule())
+	if ranked {
+		b.WriteString("Each answer is labelled with its rank and an evaluator's score (0-10); lean on higher-ranked answers.\n")
+		if cfg.SynthPreferTop {
+			b.WriteString("Where the answers conflict, follow the rank 1 answer.\n")
+		}
+	}
+	b.WriteString("\n")
+	b.WriteString(req.contextBlock())
 	b.WriteString("User prompt:\n")
-	b.WriteString(userPrompt)
+	b.WriteString(req.Prompt)
 	b.WriteString("\n\nAnswers:\n")
-	for _, c := range top {
+	for i, c := range top {
 		b.WriteString("\n---\n")
-		b.WriteString(c.Provider + ":\n")
+		if ranked {
+			b.WriteString(fmt.Sprintf("%s (rank %d, score %d/10):\n", c.Provider, i+1, scores[i].Score))
+		} else {
+			b.WriteString(c.Provider + ":\n")
+		}
 		b.WriteString(c.Text + "\n")
 	}
 	return b.String()
 }
 
+// synthesize runs a non-streamed synthesis, retrying failed or empty output
+// up to cfg.SynthRetries times while the deadline allows. With
+// code_formatting, output with broken fences gets one more try; if that
+// isn't better the first answer stands.
+func synthesize(ctx context.Context, g Generator, model, prompt string, req AnswerRequest) (text, raw string, err error) {
+	o := synthOptions()
+	o.Format = req.ResponseSchema
+	for attempt := 0; ; attempt++ {
+		raw, err = g.Generate(ctx, model, prompt, o)
+		text = stripReasoning(raw)
+		if err == nil && strings.TrimSpace(text) != "" {
+			if req.CodeFormatting && !fencesOK(text) && ctx.Err() == nil {
+				log.Printf("synthesis has unclosed or untagged code fences; retrying once")
+				if raw2, err := g.Generate(ctx, model, prompt, o); err == nil {
+					if text2 := stripReasoning(raw2); fencesOK(text2) {
+						return text2, raw2, nil
+					}
+				}
+			}
+			return text, raw, nil
+		}
+		if !retrySynth(ctx, attempt, err) {
+			if err == nil {
+				err = errors.New("synthesis returned no text")
+			}
+			return "", raw, err
+		}
+	}
+}
+
+// retrySynth reports whether another synthesis attempt is allowed after a
+// failed or empty one, logging the retry so model flakiness is visible.
+func retrySynth(ctx context.Context, attempt int, err error) bool {
+	if attempt >= cfg.SynthRetries || ctx.Err() != nil {
+		return false
+	}
+	log.Printf("synthesis attempt %d/%d produced nothing (err: %v); retrying", attempt+1, cfg.SynthRetries+1, err)
+	return true
+}
+
+// retryPipeline reports whether a request nobody answered gets another
+// fan-out, after waiting out the backoff (doubling per attempt); never when
+// the wait would reach the deadline. status, if set, is told about the retry.
+func retryPipeline(ctx context.Context, attempt int, status func(string)) bool {
+	if attempt >= cfg.PipelineRetries || ctx.Err() != nil {
+		return false
+	}
+	wait := time.Duration(cfg.PipelineRetryBackoff) << attempt
+	if dl, ok := ctx.Deadline(); ok && time.Until(dl) <= wait {
+		return false
+	}
+	msg := fmt.Sprintf("no model answered; retrying in %v (%d/%d)...", wait, attempt+1, cfg.PipelineRetries)
+	log.Print(msg)
+	if status != nil {
+		status(msg)
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// normalizeText canonicalises whitespace so formatting noise between runs
+// doesn't look like a real difference: CRLF -> LF, trailing whitespace
+// dropped from each line, and runs of blank lines reduced to one outside
+// ``` fences. Leading and inner whitespace is kept: indentation carries
+// meaning in nested lists, YAML and tables as well as in code.
+func normalizeText(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+
+	lines := strings.Split(s, "\n")
+	out := lines[:0]
+	blank, fenced := false, false
+	for _, l := range lines {
+		l = strings.TrimRight(l, " \t")
+		if strings.HasPrefix(strings.TrimSpace(l), "```") {
+			fenced = !fenced
+		}
+		if l == "" && !fenced {
+			if blank {
+				continue
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+		out = append(out, l)
+	}
+	return strings.Trim(strings.Join(out, "\n"), "\n")
+}
+
 // Fast heuristic: pick the one with more structure (newlines), else fastest
 func fastPick(cands []Candidate) Candidate {
+	cands = withoutRefusals(cands)
 	best := cands[0]
-	bestNL := strings.Count(best.Text, "\n")
+	bestNL := strings.Count(normalizeText(best.Text), "\n")
 	for _, c := range cands[1:] {
-		nl := strings.Count(c.Text, "\n")
+		nl := strings.Count(normalizeText(c.Text), "\n")
 		if nl > bestNL+1 {
 			best = c
 			bestNL = nl
 		}
 	}
-	return best
+	// Among answers of comparable structure (within a line of the pick), a
+	// higher provider priority wins; latency order breaks remaining ties.
+	pick := best
+	for _, c := range cands {
+		nl := strings.Count(normalizeText(c.Text), "\n")
+		if c.priority > pick.priority && nl >= bestNL-1 && nl <= bestNL+1 {
+			pick = c
+		}
+	}
+	return pick
 }
 
 func shouldSkipJudgeInFastMode(cands []Candidate) bool {
 	if len(cands) < 2 {
 		return true
 	}
-	a, b := len(cands[0].Text), len(cands[1].Text)
+	a, b := len(normalizeText(cands[0].Text)), len(normalizeText(cands[1].Text))
 	diff := a - b
 	if diff < 0 {
 		diff = -diff
@@ -325,15 +1162,93 @@ func shouldSkipJudgeInFastMode(cands []Candidate) bool {
 	return diff < 350
 }
 
+// budgetLow reports whether less than cfg.JudgeMinBudget of the request's
+// total budget is left on ctx, i.e. too little to judge and still answer.
+// strict_deadline requests never take that shortcut: they run the full
+// pipeline and get a 504 if it doesn't fit.
+func budgetLow(ctx context.Context, req AnswerRequest, total time.Duration) bool {
+	dl, ok := ctx.Deadline()
+	if !ok || req.StrictDeadline || cfg.JudgeMinBudget <= 0 || total <= 0 {
+		return false
+	}
+	return float64(time.Until(dl)) < cfg.JudgeMinBudget*float64(total)
+}
+
+// missedDeadline reports whether a strict_deadline request ran out of time
+// somewhere in the pipeline, whatever fallback answer that left it with.
+func missedDeadline(ctx context.Context, req AnswerRequest) bool {
+	return req.StrictDeadline && errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+const deadlineMessage = "deadline exceeded before a fully judged answer was ready"
+
 // -------------------- NDJSON streaming helpers --------------------
 
 type streamMsg struct {
-	Type string `json:"type"`           // "status" | "delta" | "meta" | "error"
-	Text string `json:"text,omitempty"` // for status/delta/error
+	Seq  int    `json:"seq,omitempty"`  // position in a recorded stream, see transcript.go
+	Type string `json:"type"`           // "status" | "delta" | "reset" | "meta" | "error" | "cancelled" | "candidate_delta" | "candidate_done"
+	Text string `json:"text,omitempty"` // for status/delta/reset/error; candidate text, or the provider's error on candidate_done
 	Meta any    `json:"meta,omitempty"` // for meta
+
+	// Provider tags candidate_delta and candidate_done (stream_candidates).
+	Provider string `json:"provider,omitempty"`
+
+	// State is set on the closing meta: see streamend.go.
+	State string `json:"state,omitempty"`
+
+	// Percent (0-99, with synth_num_predict) or ETAs (seconds, estimated)
+	// ride along on synthesis progress status messages; see stream_progress.
+	Percent int     `json:"percent,omitempty"`
+	ETAs    float64 `json:"eta_s,omitempty"`
+}
+
+// replayCached streams a cached answer as a series of word-aligned deltas with
+// light pacing, so cache hits still render progressively instead of as one blob.
+func replayCached(ctx context.Context, w http.ResponseWriter, text string) {
+	chunks := chunkText(text, cfg.CacheReplayChunk)
+	for i, c := range chunks {
+		if err := writeNDJSON(w, streamMsg{Type: "delta", Text: c}); err != nil {
+			return
+		}
+		if i == len(chunks)-1 || cfg.CacheReplayPacing <= 0 {
+			continue
+		}
+		t := time.NewTimer(time.Duration(cfg.CacheReplayPacing))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// chunkText splits s into pieces of roughly size bytes, breaking after
+// whitespace so words stay intact. size <= 0 returns s whole.
+func chunkText(s string, size int) []string {
+	if size <= 0 || len(s) <= size {
+		return []string{s}
+	}
+	var out []string
+	for len(s) > size {
+		cut := strings.IndexAny(s[size:], " \n\t")
+		if cut < 0 {
+			break
+		}
+		cut += size + 1
+		out = append(out, s[:cut])
+		s = s[cut:]
+	}
+	if s != "" {
+		out = append(out, s)
+	}
+	return out
 }
 
 func writeNDJSON(w http.ResponseWriter, v streamMsg) error {
+	if tw, ok := w.(transcriptWriter); ok {
+		v.Seq = tw.t.nextSeq()
+	}
 	b, _ := json.Marshal(v)
 	_, err := w.Write(append(b, '\n'))
 	if f, ok := w.(http.Flusher); ok {
@@ -344,18 +1259,102 @@ func writeNDJSON(w http.ResponseWriter, v streamMsg) error {
 
 // -------------------- Handlers --------------------
 
-// Non-stream JSON endpoint (kept for compatibility)
-func handleAnswer(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, errResp{Error: "POST only"})
-		return
+// GET /answer?prompt=...&mode=... puts the prompt in the URL, so keep it short:
+// proxies and browsers commonly cap URLs somewhere between 2 and 8 KB. Longer
+// prompts are rejected with 414 and should be sent via POST.
+const maxQueryPromptBytes = 2000
+
+// queryBool parses an optional boolean query parameter (nil when absent or invalid).
+func queryBool(q url.Values, name string) *bool {
+	b, err := strconv.ParseBool(q.Get(name))
+	if err != nil {
+		return nil
+	}
+	return &b
+}
+
+// resolveMode maps the requested mode to the one that will run: anything
+// but "quality" means fast, and a disabled mode either falls back to the
+// other one or is refused, per disabled_mode.
+func resolveMode(requested string) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(requested))
+	if mode != "quality" {
+		mode = "fast"
+	}
+	if !cfg.Modes[mode].Disabled {
+		return mode, nil
+	}
+	if cfg.DisabledMode == disabledModeReject {
+		return "", fmt.Errorf("mode %s is disabled on this server", mode)
+	}
+	if mode == "quality" {
+		return "fast", nil
 	}
+	return "quality", nil
+}
 
+// Non-stream JSON endpoint (kept for compatibility). POST is the primary path;
+// GET is accepted for quick testing from a browser.
+func handleAnswer(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	var req AnswerRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, errResp{Error: "bad json"})
+	switch r.Method {
+	case http.MethodPost:
+		if err := decodeAnswerRequest(r.Body, &req); err != nil {
+			writeJSON(w, http.StatusBadRequest, errResp{Error: decodeError(err)})
+			return
+		}
+	case http.MethodGet:
+		q := r.URL.Query()
+		if err := aliasQuery(q); err != nil {
+			writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+			return
+		}
+		req.Prompt = q.Get("prompt")
+		req.Mode = q.Get("mode")
+		req.Explain, _ = strconv.ParseBool(q.Get("explain"))
+		req.MaxAnswerChars, _ = strconv.Atoi(q.Get("max_answer_chars"))
+		req.Judge = queryBool(q, "judge")
+		req.Synthesize = queryBool(q, "synthesize")
+		req.NFinal, _ = strconv.Atoi(q.Get("n_final"))
+		req.IncludeScores, _ = strconv.ParseBool(q.Get("include_scores"))
+		req.StrictDeadline, _ = strconv.ParseBool(q.Get("strict_deadline"))
+		req.PreserveSources, _ = strconv.ParseBool(q.Get("preserve_sources"))
+		req.SourcesSection, _ = strconv.ParseBool(q.Get("sources_section"))
+		req.SurfaceDisagreement, _ = strconv.ParseBool(q.Get("surface_disagreement"))
+		req.Variations, _ = strconv.Atoi(q.Get("variations"))
+		req.Voice = q.Get("voice")
+		req.MaxCandidates, _ = strconv.Atoi(q.Get("max_candidates"))
+		req.JudgeModel = q.Get("judge_model")
+		req.ReasoningStyle = q.Get("reasoning_style")
+		if v, err := strconv.Atoi(q.Get("max_age_seconds")); err == nil {
+			req.MaxAgeSeconds = &v
+		}
+		if len(req.Prompt) > maxQueryPromptBytes {
+			writeJSON(w, http.StatusRequestURITooLong, errResp{Error: fmt.Sprintf("prompt too long for GET (max %d bytes); use POST", maxQueryPromptBytes)})
+			return
+		}
+	}
+	req.caller = caller(r)
+
+	version, err := requestedVersion(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	clientTimeout, err := requestTimeout(w, r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	fields, err := requestFields(r, req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
 		return
 	}
+	render := func(resp AnswerResponse) any {
+		return selectFields(shapeResponse(capCandidates(withholdCandidates(resp), req.candidateCap()), version), fields)
+	}
 
 	req.Prompt = strings.TrimSpace(req.Prompt)
 	if req.Prompt == "" {
@@ -363,71 +1362,241 @@ func handleAnswer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mode := strings.ToLower(strings.TrimSpace(req.Mode))
-	if mode != "quality" {
-		mode = "fast"
+	mode, err := resolveMode(req.Mode)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	if err := checkImages(req, mode); err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	if err := checkJudgeModel(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	if err := checkReasoningStyle(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	if err := checkValidate(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	if err := checkVariations(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	if err := checkVoice(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+
+	sample := peekSample(mode)
+	key := requestCacheKey(req, mode, r.Header.Get(cfg.CacheTenantHeader), sample)
+	if v, ok := cacheGet(key, req.maxAge()); ok && !isRefresh(r.Context()) {
+		maybeRefresh(r, req, mode, key)
+		servedFromCache(&v, req, start)
+		addCachedVariations(r, &v, req, mode, clientTimeout)
+		audit(r, req, v, start)
+		writeJSON(w, http.StatusOK, render(v))
+		return
 	}
 
-	key := cacheKey(req.Prompt, mode)
-	if v, ok := cacheGet(key); ok {
-		v.Cached = true
-		writeJSON(w, http.StatusOK, v)
+	if !missJitter(r.Context()) {
 		return
 	}
+	release, ok := admit(r.Context())
+	if !ok {
+		writeBusy(w)
+		return
+	}
+	defer release()
+
+	mc := cfg.Modes[mode]
+	if sample = takeSample(mode); sample != nil {
+		mc.Providers = sample
+		key = requestCacheKey(req, mode, r.Header.Get(cfg.CacheTenantHeader), sample)
+	}
+	var imageNote string
+	if len(req.Images) > 0 {
+		mc, imageNote = imageProviders(mc)
+	}
+	providers := mc.Providers
+	timeout := time.Duration(mc.Timeout)
+	if clientTimeout > 0 {
+		timeout = clientTimeout
+	}
+	cacheTTL := time.Duration(mc.CacheTTL)
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	budget := newTokenBudget(gen, mc.MaxTokens)
+	gen := Generator(budget)
 
 	var (
-		providers []provider
-		timeout   time.Duration
-		cacheTTL  time.Duration
+		degraded     bool
+		degradedNote string
+		langOutliers map[string]string
+		langNote     string
+		refusalNote  string
+		allRefused   bool
+		shortNote    string
+		compressNote string
+		contextNote  string
+		tm           phaseTimings
 	)
-	if mode == "quality" {
-		providers = []provider{
-			{name: "llama3.2", model: "llama3.2"},
-			{name: "qwen2.5", model: "qwen2.5"},
-			{name: "mistral", model: "mistral"},
+
+	// finish caches and writes a freshly computed answer.
+	finish := func(resp AnswerResponse, rawFinal string) {
+		if missedDeadline(ctx, req) {
+			writeJSON(w, http.StatusGatewayTimeout, errResp{Error: deadlineMessage})
+			return
+		}
+		if imageNote != "" {
+			resp.Notes = append(resp.Notes, imageNote)
+		}
+		if compressNote != "" {
+			resp.Notes = append(resp.Notes, compressNote)
+		}
+		if contextNote != "" {
+			resp.Notes = append(resp.Notes, contextNote)
+		}
+		if degraded {
+			resp.Degraded = true
+			resp.Notes = append(resp.Notes, degradedNote)
+		}
+		if langNote != "" {
+			resp.Notes = append(resp.Notes, langNote)
+		}
+		if shortNote != "" {
+			resp.Notes = append(resp.Notes, shortNote)
+		}
+		if refusalNote != "" {
+			resp.Notes = append(resp.Notes, refusalNote)
+		}
+		resp.Notes = append(resp.Notes, altModelNotes(resp.Candidates)...)
+		if budget.exceeded() {
+			resp.BudgetExceeded = true
+			resp.Notes = append(resp.Notes, "token budget exhausted; later stages skipped")
+		}
+		translateAnswer(ctx, gen, &resp, req)
+		finalizeAnswer(&resp, req)
+		gateConfidence(&resp)
+		if req.structured() && !resp.NeedsHuman {
+			if err := checkStructured(&resp); err != nil {
+				writeJSON(w, http.StatusBadGateway, errResp{Error: err.Error()})
+				return
+			}
 		}
-		timeout = 120 * time.Second
-		cacheTTL = 30 * time.Minute
-	} else {
-		providers = []provider{
-			{name: "llama3.2", model: "llama3.2"},
-			{name: "qwen2.5", model: "qwen2.5"},
+		// kept with the cached entry for later explain requests
+		resp.Debug = newDebugInfo(resp.Candidates, rawFinal)
+		resp.Debug.LanguageOutliers = langOutliers
+		if req.wantScores() {
+			resp.Scores = judgeScores(resp.Candidates, resp.scores)
 		}
-		timeout = 45 * time.Second
-		cacheTTL = 10 * time.Minute
+		timings := tm
+		timings.TotalMs = time.Since(start).Milliseconds()
+		resp.Timings = &timings
+		ttl := answerTTL(resp, cacheTTL)
+		resp.CacheTTLs = int64(ttl.Seconds())
+		resp.CacheDecision = cacheDecision(resp, ttl)
+		a, low := lowAgreement(resp)
+		resp.Agreement = &a
+		if low {
+			resp.Notes = append(resp.Notes, fmt.Sprintf("not cached: candidate agreement %.2f is below %.2f", a, cfg.CacheMinAgreement))
+		}
+		if uncachedFallback(resp) {
+			resp.Notes = append(resp.Notes, "not cached: fallback answer")
+		}
+		if ttl > 0 {
+			cacheSet(key, resp, ttl)
+		}
+		if !req.Explain {
+			resp.Debug = nil
+		}
+		addVariations(ctx, gen, &resp, req, budgetLow(ctx, req, timeout))
+		audit(r, req, resp, start)
+		writeJSON(w, http.StatusOK, render(resp))
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), timeout)
-	defer cancel()
-
-	cands := fanOut(ctx, providers, req.Prompt)
+	req, contextNote = fitContext(req)
+	req, compressNote = compressPrompt(ctx, gen, req)
+	failures := &failureLog{}
+	t0 := time.Now()
+	var cands []Candidate
+	for attempt := 0; ; attempt++ {
+		cands = fanOut(ctx, gen, providers, req, failures.tap(nil))
+		cands, shortNote = dropShort(cands)
+		cands, degraded, degradedNote = ensureMinProviders(ctx, gen, mc, providers, cands, req, failures.tap(nil))
+		if len(cands) > 0 || !retryPipeline(ctx, attempt, nil) {
+			break
+		}
+	}
+	cands = combineGroups(cands, mc.Groups)
+	cands, langOutliers, langNote = checkLanguages(cands)
+	refusalNote, allRefused = markRefusals(cands)
+	track(&tm.FanoutMs, t0)
+	if len(cands) == 0 && missedDeadline(ctx, req) {
+		writeJSON(w, http.StatusGatewayTimeout, errResp{Error: deadlineMessage})
+		return
+	}
+	if len(cands) == 0 && cfg.NoAnswerMessage != "" {
+		resp := unavailableResponse(mode)
+		audit(r, req, resp, start)
+		writeJSON(w, http.StatusOK, render(resp))
+		return
+	}
 	if len(cands) == 0 {
-		writeJSON(w, http.StatusBadGateway, errResp{Error: "no model responses (is Ollama running on localhost:11434?)"})
+		writeJSON(w, http.StatusBadGateway, errResp{Error: failures.message()})
+		return
+	}
+	if allRefused {
+		resp := refusedResponse(mode, cands, refusalNote)
+		audit(r, req, resp, start)
+		writeJSON(w, http.StatusOK, render(resp))
 		return
 	}
 
 	if len(cands) == 1 {
-		resp := AnswerResponse{Final: cands[0].Text, Candidates: cands, Cached: false, Mode: mode}
-		cacheSet(key, resp, cacheTTL)
-		writeJSON(w, http.StatusOK, resp)
+		finish(AnswerResponse{Final: cands[0].Text, Candidates: cands, Cached: false, Mode: mode, Source: cands[0].Provider}, "")
+		return
+	}
+
+	synthModel := defaultJudgeModel
+	markJudgeOverlap(cands, req.judgeModel())
+	prefilterJudge(cands)
+	if req.finalCount() > 1 {
+		t0 := time.Now()
+		finals, scores, source := rankFinals(ctx, gen, synthModel, req, cands, req.judgeEnabled() && !budgetLow(ctx, req, timeout))
+		track(&tm.JudgeMs, t0)
+		finish(AnswerResponse{Final: finals[0].Text, Finals: finals, Candidates: cands, Cached: false, Mode: mode, scores: scores, Source: source}, "")
 		return
 	}
 
-	if mode == "fast" && shouldSkipJudgeInFastMode(cands) {
+	if !req.judgeEnabled() || (mode == "fast" && shouldSkipJudgeInFastMode(cands) && verifyFast(ctx, gen, req, cands)) {
 		best := fastPick(cands)
-		resp := AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode}
-		cacheSet(key, resp, cacheTTL)
-		writeJSON(w, http.StatusOK, resp)
+		finish(AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode, Source: best.Provider}, "")
 		return
 	}
 
-	judgeModel := "llama3.2"
-	scores, err := judgeCandidates(ctx, judgeModel, req.Prompt, cands)
+	if budgetLow(ctx, req, timeout) {
+		best := fastPick(cands)
+		finish(AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode, Source: best.Provider, Notes: []string{"judge skipped: request deadline nearly spent"}, fallback: true}, "")
+		return
+	}
+
+	var spec *speculation
+	if speculate(mode, req) {
+		spec = startSpeculation(ctx, gen, synthModel, req, cands)
+		defer spec.discard()
+	}
+	t0 = time.Now()
+	scores, err := judgeCandidates(ctx, gen, req.judgeModel(), req.groundedPrompt(), cands)
+	track(&tm.JudgeMs, t0)
 	if err != nil {
 		best := fastPick(cands)
-		resp := AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode}
-		cacheSet(key, resp, cacheTTL)
-		writeJSON(w, http.StatusOK, resp)
+		finish(AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode, Source: best.Provider, fallback: true}, "")
 		return
 	}
 
@@ -437,37 +1606,93 @@ func handleAnswer(w http.ResponseWriter, r *http.Request) {
 	}
 
 	final := cands[scores[0].Idx].Text
-	if mode == "quality" {
-		merged, err := ollamaGenerate(ctx, judgeModel, synthPrompt(req.Prompt, top))
-		if err == nil && strings.TrimSpace(merged) != "" {
-			final = merged
-		}
-	} else {
-		if len(final) < 500 {
-			merged, err := ollamaGenerate(ctx, judgeModel, synthPrompt(req.Prompt, top))
-			if err == nil && strings.TrimSpace(merged) != "" {
+	source := cands[scores[0].Idx].Provider
+	var (
+		rawFinal string
+		notes    []string
+		fallback bool
+	)
+	if req.synthEnabled() && (mode == "quality" || len(final) < 500) {
+		t0 := time.Now()
+		var bakeoff [][]Candidate
+		if !budgetLow(ctx, req, timeout) {
+			bakeoff = bakeoffSubsets(cands, scores)
+		}
+		top, merged, raw, bakeNote, err := synthesizeBest(ctx, gen, synthModel, req, top, bakeoff, scores, spec)
+		if verr := (*validationError)(nil); errors.As(err, &verr) {
+			notes = append(notes, verr.Error()+"; using best candidate")
+		}
+		fallback = err != nil
+		if err == nil {
+			if bad, note := synthRegressed(ctx, gen, req.judgeModel(), req.groundedPrompt(), merged, top[0], bodyCharLimit(req)); bad {
+				notes = append(notes, note)
+				fallback = true
+			} else {
 				final = merged
+				source = sourceSynthesis
+				rawFinal = raw
+				if note := synthBudgetNote(req, top); note != "" {
+					notes = append(notes, note)
+				}
+				if note := disagreementNote(req, top); note != "" {
+					notes = append(notes, note)
+				}
+				if bakeNote != "" {
+					notes = append(notes, bakeNote)
+				}
 			}
 		}
+		track(&tm.SynthMs, t0)
 	}
 
-	resp := AnswerResponse{Final: final, Candidates: cands, Cached: false, Mode: mode}
-	cacheSet(key, resp, cacheTTL)
-	writeJSON(w, http.StatusOK, resp)
+	finish(AnswerResponse{Final: final, Candidates: cands, Cached: false, Mode: mode, scores: scores, Source: source, Notes: notes, fallback: fallback}, rawFinal)
 }
 
 // Streaming NDJSON endpoint
 func handleAnswerStream(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, errResp{Error: "POST only"})
+	start := time.Now()
+
+	closeStream, ok := openStream()
+	if !ok {
+		writeStreamsBusy(w)
 		return
 	}
+	defer closeStream()
+
+	resumable := wantsResumable(r)
+	if resumable || wantsTranscript(r) {
+		id := newTranscriptID()
+		w.Header().Set("X-Transcript-ID", id)
+		t := newTranscript(id, caller(r))
+		defer t.finish()
+		w = transcriptWriter{ResponseWriter: w, t: t, detached: resumable}
+	}
 
 	var req AnswerRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, errResp{Error: "bad json"})
+	if err := decodeAnswerRequest(r.Body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: decodeError(err)})
 		return
 	}
+	req.caller = caller(r)
+
+	version, err := requestedVersion(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	clientTimeout, err := requestTimeout(w, r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	fields, err := requestFields(r, req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	render := func(resp AnswerResponse) any {
+		return selectFields(shapeResponse(capCandidates(withholdCandidates(resp), req.candidateCap()), version), fields)
+	}
 
 	req.Prompt = strings.TrimSpace(req.Prompt)
 	if req.Prompt == "" {
@@ -475,9 +1700,34 @@ func handleAnswerStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mode := strings.ToLower(strings.TrimSpace(req.Mode))
-	if mode != "quality" {
-		mode = "fast"
+	mode, err := resolveMode(req.Mode)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	if err := checkImages(req, mode); err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	if err := checkJudgeModel(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	if err := checkReasoningStyle(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	if err := checkValidate(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	if err := checkVariations(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
+	}
+	if err := checkVoice(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+		return
 	}
 
 	// NDJSON streaming headers
@@ -485,71 +1735,291 @@ func handleAnswerStream(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	key := cacheKey(req.Prompt, mode)
-	if v, ok := cacheGet(key); ok {
+	sample := peekSample(mode)
+	key := requestCacheKey(req, mode, r.Header.Get(cfg.CacheTenantHeader), sample)
+	if v, ok := cacheGet(key, req.maxAge()); ok {
+		maybeRefresh(r, req, mode, key)
 		_ = writeNDJSON(w, streamMsg{Type: "status", Text: "cache hit"})
-		_ = writeNDJSON(w, streamMsg{Type: "delta", Text: v.Final})
-		v.Cached = true
-		_ = writeNDJSON(w, streamMsg{Type: "meta", Meta: v})
+		if !req.structured() {
+			replayCached(r.Context(), w, v.Final)
+		}
+		servedFromCache(&v, req, start)
+		addCachedVariations(r, &v, req, mode, clientTimeout)
+		audit(r, req, v, start)
+		_ = writeNDJSON(w, streamMsg{Type: "meta", Meta: render(v), State: streamComplete})
+		return
+	}
+
+	// nothing has been written yet, so a rejection can still be a plain 503
+	if !missJitter(r.Context()) {
+		return
+	}
+	release, ok := admit(r.Context())
+	if !ok {
+		writeBusy(w)
 		return
 	}
+	defer release()
+
+	mc := cfg.Modes[mode]
+	if sample = takeSample(mode); sample != nil {
+		mc.Providers = sample
+		key = requestCacheKey(req, mode, r.Header.Get(cfg.CacheTenantHeader), sample)
+	}
+	var imageNote string
+	if len(req.Images) > 0 {
+		mc, imageNote = imageProviders(mc)
+	}
+	providers := mc.Providers
+	timeout := time.Duration(mc.Timeout)
+	if clientTimeout > 0 {
+		timeout = clientTimeout
+	}
+	cacheTTL := time.Duration(mc.CacheTTL)
+
+	// a resumable stream outlives its connection so the client can reattach
+	base := r.Context()
+	if resumable {
+		base = context.WithoutCancel(base)
+	}
+	ctx, cancel := context.WithTimeout(base, timeout)
+	defer cancel()
+	budget := newTokenBudget(gen, mc.MaxTokens)
+	gen := Generator(budget)
 
 	var (
-		providers []provider
-		timeout   time.Duration
-		cacheTTL  time.Duration
+		degraded     bool
+		degradedNote string
+		langOutliers map[string]string
+		langNote     string
+		refusalNote  string
+		allRefused   bool
+		shortNote    string
+		compressNote string
+		contextNote  string
+		tm           phaseTimings
+		synthStart   time.Time // set once synthesis starts; finish charges the rest to synth_ms
+		closed       bool      // the closing meta went out
+		heldMu       sync.Mutex
+		held         []streamMsg // stream_candidates lines kept back under confidence_gate
 	)
-	if mode == "quality" {
-		providers = []provider{
-			{name: "llama3.2", model: "llama3.2"},
-			{name: "qwen2.5", model: "qwen2.5"},
-			{name: "mistral", model: "mistral"},
+	defer func() {
+		if !closed {
+			endStream(w, ctx, r.Context(), resumable)
+		}
+	}()
+
+	// finish caches a freshly computed answer and sends it as the closing meta.
+	// Unless the answer was already streamed token by token, Final goes out
+	// as a single delta after post-processing; a streamed answer only still
+	// needs the configured suffix. Structured answers are only sent in meta,
+	// once they are known to parse.
+	finish := func(resp AnswerResponse, rawFinal string, streamed bool) {
+		if !synthStart.IsZero() {
+			track(&tm.SynthMs, synthStart)
+		}
+		if missedDeadline(ctx, req) {
+			_ = writeNDJSON(w, streamMsg{Type: "error", Text: deadlineMessage})
+			return
+		}
+		if imageNote != "" {
+			resp.Notes = append(resp.Notes, imageNote)
+		}
+		if compressNote != "" {
+			resp.Notes = append(resp.Notes, compressNote)
+		}
+		if contextNote != "" {
+			resp.Notes = append(resp.Notes, contextNote)
+		}
+		if degraded {
+			resp.Degraded = true
+			resp.Notes = append(resp.Notes, degradedNote)
+		}
+		if langNote != "" {
+			resp.Notes = append(resp.Notes, langNote)
+		}
+		if shortNote != "" {
+			resp.Notes = append(resp.Notes, shortNote)
+		}
+		if refusalNote != "" {
+			resp.Notes = append(resp.Notes, refusalNote)
 		}
-		timeout = 120 * time.Second
-		cacheTTL = 30 * time.Minute
-	} else {
-		providers = []provider{
-			{name: "llama3.2", model: "llama3.2"},
-			{name: "qwen2.5", model: "qwen2.5"},
+		resp.Notes = append(resp.Notes, altModelNotes(resp.Candidates)...)
+		if budget.exceeded() {
+			resp.BudgetExceeded = true
+			resp.Notes = append(resp.Notes, "token budget exhausted; later stages skipped")
 		}
-		timeout = 45 * time.Second
-		cacheTTL = 10 * time.Minute
+		translateAnswer(ctx, gen, &resp, req)
+		finalizeAnswer(&resp, req)
+		gateConfidence(&resp)
+		if !resp.NeedsHuman {
+			heldMu.Lock()
+			for _, m := range held {
+				_ = writeNDJSON(w, m)
+			}
+			held = nil
+			heldMu.Unlock()
+		}
+		if req.structured() && !resp.NeedsHuman {
+			if err := checkStructured(&resp); err != nil {
+				_ = writeNDJSON(w, streamMsg{Type: "error", Text: err.Error()})
+				return
+			}
+		} else if !streamed {
+			_ = writeNDJSON(w, streamMsg{Type: "delta", Text: resp.Final})
+		} else if cfg.AnswerSuffix != "" {
+			_ = writeNDJSON(w, streamMsg{Type: "delta", Text: cfg.AnswerSuffix})
+		}
+		// kept with the cached entry for later explain requests
+		resp.Debug = newDebugInfo(resp.Candidates, rawFinal)
+		resp.Debug.LanguageOutliers = langOutliers
+		if req.wantScores() {
+			resp.Scores = judgeScores(resp.Candidates, resp.scores)
+		}
+		timings := tm
+		timings.TotalMs = time.Since(start).Milliseconds()
+		resp.Timings = &timings
+		ttl := answerTTL(resp, cacheTTL)
+		resp.CacheTTLs = int64(ttl.Seconds())
+		resp.CacheDecision = cacheDecision(resp, ttl)
+		a, low := lowAgreement(resp)
+		resp.Agreement = &a
+		if low {
+			resp.Notes = append(resp.Notes, fmt.Sprintf("not cached: candidate agreement %.2f is below %.2f", a, cfg.CacheMinAgreement))
+		}
+		if uncachedFallback(resp) {
+			resp.Notes = append(resp.Notes, "not cached: fallback answer")
+		}
+		if ttl > 0 {
+			cacheSet(key, resp, ttl)
+		}
+		if !req.Explain {
+			resp.Debug = nil
+		}
+		addVariations(ctx, gen, &resp, req, budgetLow(ctx, req, timeout))
+		audit(r, req, resp, start)
+		_ = writeNDJSON(w, streamMsg{Type: "meta", Meta: render(resp), State: streamComplete})
+		closed = true
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), timeout)
-	defer cancel()
-
 	_ = writeNDJSON(w, streamMsg{Type: "status", Text: "running models..."})
-	cands := fanOut(ctx, providers, req.Prompt)
+	var tap candidateTap
+	if req.StreamCandidates {
+		tap = func(provider, delta string, done bool, err error) {
+			msg := streamMsg{Type: "candidate_delta", Provider: provider, Text: delta}
+			if done {
+				msg = streamMsg{Type: "candidate_done", Provider: provider}
+				if err != nil {
+					msg.Text = err.Error()
+				}
+			}
+			heldMu.Lock()
+			defer heldMu.Unlock()
+			if cfg.ConfidenceGate > 0 {
+				held = append(held, msg)
+				return
+			}
+			_ = writeNDJSON(w, msg)
+		}
+	}
+	req, contextNote = fitContext(req)
+	req, compressNote = compressPrompt(ctx, gen, req)
+	failures := &failureLog{}
+	tap = failures.tap(tap)
+	t0 := time.Now()
+	var cands []Candidate
+	for attempt := 0; ; attempt++ {
+		cands = fanOut(ctx, gen, providers, req, tap)
+		cands, shortNote = dropShort(cands)
+		if len(cands) < minProviders(mc, providers) && len(mc.FallbackProviders) > 0 {
+			_ = writeNDJSON(w, streamMsg{Type: "status", Text: "too few models answered; trying fallback models..."})
+		}
+		cands, degraded, degradedNote = ensureMinProviders(ctx, gen, mc, providers, cands, req, tap)
+		if len(cands) > 0 || !retryPipeline(ctx, attempt, func(msg string) { _ = writeNDJSON(w, streamMsg{Type: "status", Text: msg}) }) {
+			break
+		}
+	}
+	cands = combineGroups(cands, mc.Groups)
+	cands, langOutliers, langNote = checkLanguages(cands)
+	refusalNote, allRefused = markRefusals(cands)
+	track(&tm.FanoutMs, t0)
+	if len(cands) == 0 && missedDeadline(ctx, req) {
+		_ = writeNDJSON(w, streamMsg{Type: "error", Text: deadlineMessage})
+		return
+	}
+	if len(cands) == 0 && cfg.NoAnswerMessage != "" {
+		resp := unavailableResponse(mode)
+		_ = writeNDJSON(w, streamMsg{Type: "delta", Text: resp.Final})
+		audit(r, req, resp, start)
+		_ = writeNDJSON(w, streamMsg{Type: "meta", Meta: render(resp), State: streamComplete})
+		closed = true
+		return
+	}
 	if len(cands) == 0 {
-		_ = writeNDJSON(w, streamMsg{Type: "error", Text: "no model responses (is Ollama running on localhost:11434?)"})
+		_ = writeNDJSON(w, streamMsg{Type: "error", Text: failures.message()})
+		return
+	}
+	if allRefused {
+		resp := refusedResponse(mode, cands, refusalNote)
+		audit(r, req, resp, start)
+		_ = writeNDJSON(w, streamMsg{Type: "meta", Meta: render(resp), State: streamComplete})
+		closed = true
+		return
+	}
+
+	synthModel := defaultJudgeModel
+	markJudgeOverlap(cands, req.judgeModel())
+	prefilterJudge(cands)
+	if req.finalCount() > 1 {
+		_ = writeNDJSON(w, streamMsg{Type: "status", Text: "ranking candidates..."})
+		t0 := time.Now()
+		finals, scores, source := rankFinals(ctx, gen, synthModel, req, cands, req.judgeEnabled() && !budgetLow(ctx, req, timeout))
+		track(&tm.JudgeMs, t0)
+
+		finish(AnswerResponse{Final: finals[0].Text, Finals: finals, Candidates: cands, Cached: false, Mode: mode, scores: scores, Source: source}, "", false)
 		return
 	}
 
 	// FAST shortcut
-	if mode == "fast" && len(cands) >= 2 && shouldSkipJudgeInFastMode(cands) {
+	if !req.judgeEnabled() || (mode == "fast" && len(cands) >= 2 && shouldSkipJudgeInFastMode(cands) && verifyFast(ctx, gen, req, cands)) {
 		best := fastPick(cands)
 		_ = writeNDJSON(w, streamMsg{Type: "status", Text: "fast path (no judge)"})
-		_ = writeNDJSON(w, streamMsg{Type: "delta", Text: best.Text})
 
-		resp := AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode}
-		cacheSet(key, resp, cacheTTL)
-		_ = writeNDJSON(w, streamMsg{Type: "meta", Meta: resp})
+		finish(AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode, Source: best.Provider}, "", false)
+		return
+	}
+
+	if budgetLow(ctx, req, timeout) {
+		best := fastPick(cands)
+		_ = writeNDJSON(w, streamMsg{Type: "status", Text: "running out of time; skipping judge"})
+
+		finish(AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode, Source: best.Provider, Notes: []string{"judge skipped: request deadline nearly spent"}, fallback: true}, "", false)
 		return
 	}
 
-	judgeModel := "llama3.2"
 	_ = writeNDJSON(w, streamMsg{Type: "status", Text: "judging candidates..."})
 
-	scores, err := judgeCandidates(ctx, judgeModel, req.Prompt, cands)
+	var spec *speculation
+	if speculate(mode, req) {
+		spec = startSpeculation(ctx, gen, synthModel, req, cands)
+		defer spec.discard()
+	}
+	t0 = time.Now()
+	scores, err := judgeCandidates(ctx, gen, req.judgeModel(), req.groundedPrompt(), cands)
+	track(&tm.JudgeMs, t0)
 	if err != nil {
 		best := fastPick(cands)
 		_ = writeNDJSON(w, streamMsg{Type: "status", Text: "judge failed; using best guess"})
-		_ = writeNDJSON(w, streamMsg{Type: "delta", Text: best.Text})
 
-		resp := AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode}
-		cacheSet(key, resp, cacheTTL)
-		_ = writeNDJSON(w, streamMsg{Type: "meta", Meta: resp})
+		finish(AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode, Source: best.Provider, fallback: true}, "", false)
+		return
+	}
+
+	if !req.synthEnabled() {
+		best := cands[scores[0].Idx]
+		_ = writeNDJSON(w, streamMsg{Type: "status", Text: "synthesis disabled; using top judged candidate"})
+
+		finish(AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode, scores: scores, Source: best.Provider}, "", false)
 		return
 	}
 
@@ -558,38 +2028,244 @@ func handleAnswerStream(w http.ResponseWriter, r *http.Request) {
 		top = append(top, cands[scores[1].Idx])
 	}
 
+	synthP := synthPrompt(req, top, scores[:len(top)])
+	synthStart = time.Now()
+	var bakeoff [][]Candidate
+	if !budgetLow(ctx, req, timeout) {
+		bakeoff = bakeoffSubsets(cands, scores)
+	}
+
+	// Streamed deltas stop keep runes into a capped answer, leaving room for
+	// " " + the marker as truncateAnswer does; a cap too small for that
+	// isn't worth streaming.
+	limit := bodyCharLimit(req)
+	keep := limit - utf8.RuneCountInString(truncationMarker) - 1
+
+	// Partial JSON is no use to a client, and an answer about to be
+	// translated shouldn't stream in the wrong language, so both are
+	// buffered; so is a bake-off, whose winner isn't known until the end,
+	// an answer the confidence gate may still withhold, and one that dedupe
+	// or normalization would rewrite after its deltas went out.
+	if req.structured() || req.TargetLanguage != "" || req.Validate != nil || bakeoff != nil || cfg.ConfidenceGate > 0 || cfg.DedupeThreshold > 0 || cfg.NormalizeOutput || (limit > 0 && keep <= 0) {
+		_ = writeNDJSON(w, streamMsg{Type: "status", Text: "synthesizing (answer sent when complete)..."})
+		top, merged, raw, bakeNote, err := synthesizeBest(ctx, gen, synthModel, req, top, bakeoff, scores, spec)
+		if err != nil {
+			best := cands[scores[0].Idx]
+			_ = writeNDJSON(w, streamMsg{Type: "status", Text: "synth failed; fallback to best candidate"})
+
+			var notes []string
+			if verr := (*validationError)(nil); errors.As(err, &verr) {
+				notes = append(notes, verr.Error()+"; using best candidate")
+			}
+			finish(AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode, scores: scores, Source: best.Provider, Notes: notes, fallback: true}, "", false)
+			return
+		}
+		var notes []string
+		if note := synthBudgetNote(req, top); note != "" {
+			notes = append(notes, note)
+		}
+		if note := disagreementNote(req, top); note != "" {
+			notes = append(notes, note)
+		}
+		if bakeNote != "" {
+			notes = append(notes, bakeNote)
+		}
+		finish(AnswerResponse{Final: merged, Candidates: cands, Cached: false, Mode: mode, scores: scores, Source: sourceSynthesis, Notes: notes}, raw, false)
+		return
+	}
+
 	// Stream the synthesis (real streaming)
 	_ = writeNDJSON(w, streamMsg{Type: "status", Text: "synthesizing..."})
 
-	synthP := synthPrompt(req.Prompt, top)
+	// The configured prefix goes out ahead of the first real delta. Under an
+	// answer cap, send writes the first keep runes and holds back the tail;
+	// once the answer runs past the cap the generation is stopped and
+	// settle ends the stream with the truncation marker, else settle sends
+	// what was held. Either way the deltas add up to Final. emit regroups
+	// deltas per stream_delta_grouping; what the grouper holds goes out once
+	// the generation is done.
+	var (
+		final   strings.Builder
+		started bool
+		sent    int
+		tail    []rune
+		capped  bool
+	)
+	grouper := newDeltaGrouper(cfg.StreamDeltaGrouping)
+	write := func(text string) error {
+		if text == "" {
+			return nil
+		}
+		if !started && cfg.AnswerPrefix != "" {
+			if err := writeNDJSON(w, streamMsg{Type: "delta", Text: cfg.AnswerPrefix}); err != nil {
+				return err
+			}
+		}
+		started = true
+		final.WriteString(text)
+		return writeNDJSON(w, streamMsg{Type: "delta", Text: text})
+	}
+	send := func(delta string) error {
+		if capped {
+			return errAnswerCapped
+		}
+		if limit <= 0 {
+			return write(delta)
+		}
+		r := []rune(delta)
+		n := min(max(keep-sent, 0), len(r))
+		sent += n
+		tail = append(tail, r[n:]...)
+		if err := write(string(r[:n])); err != nil {
+			return err
+		}
+		if sent+len(tail) > limit {
+			capped, tail = true, nil
+			return errAnswerCapped
+		}
+		return nil
+	}
+	settle := func() error {
+		if !capped {
+			h := string(tail)
+			tail = nil
+			return write(h)
+		}
+		marker := truncationMarker
+		if s := final.String(); s != "" && !unicode.IsSpace([]rune(s)[len([]rune(s))-1]) {
+			marker = " " + marker
+		}
+		return write(marker)
+	}
+	emit := func(delta string) error { return send(grouper.Write(delta)) }
+	var (
+		raw          string
+		synthStalled bool
+	)
+	if spec.adopt(top) {
+		// an adopted speculation is held to the same stall timeout
+		sctx, watchdog, stop := watchStall(ctx, time.Duration(cfg.SynthStallTimeout))
+		context.AfterFunc(sctx, spec.discard)
+		raw, err = spec.stream(func(delta string) error {
+			watchdog.kick()
+			return emit(delta)
+		})
+		synthStalled = stalled(sctx)
+		stop()
+		if err == nil {
+			if err = send(grouper.Flush()); errors.Is(err, errAnswerCapped) {
+				err = nil
+			}
+		}
+	}
+	// an attempt that produced nothing can be retried; once deltas are out it can't
+	for attempt := 0; !synthStalled && final.Len() == 0; attempt++ {
+		filter := newReasoningFilter(cfg.ReasoningTags)
+		grouper = newDeltaGrouper(cfg.StreamDeltaGrouping)
+		meter := newProgressMeter(cfg.SynthNumPredict, len(top[0].Text))
+		sctx, watchdog, stop := watchStall(ctx, time.Duration(cfg.SynthStallTimeout))
+		raw, err = gen.GenerateStream(sctx, synthModel, synthP, synthOptions(), func(delta string) error {
+			watchdog.kick()
+			if m, ok := meter.tick(time.Now()); ok {
+				if err := writeNDJSON(w, m); err != nil {
+					return err
+				}
+			}
+			return emit(filter.Write(delta))
+		})
+		synthStalled = stalled(sctx)
+		stop()
+		if err == nil {
+			err = emit(filter.Flush())
+		}
+		if err == nil {
+			err = send(grouper.Flush())
+		}
+		if errors.Is(err, errAnswerCapped) {
+			err = nil
+		}
+		if synthStalled || final.Len() > 0 || !retrySynth(ctx, attempt, err) {
+			break
+		}
+	}
+	if synthStalled {
+		best := cands[scores[0].Idx]
+		msg := "synthesis stalled; streaming best candidate instead"
+		if final.Len() > 0 {
+			resetStream(w, msg)
+		} else {
+			_ = writeNDJSON(w, streamMsg{Type: "status", Text: msg})
+		}
 
-	var final strings.Builder
-	merged, err := ollamaGenerateStream(ctx, judgeModel, synthP, func(delta string) error {
-		final.WriteString(delta)
-		return writeNDJSON(w, streamMsg{Type: "delta", Text: delta})
-	})
-	if err != nil || strings.TrimSpace(merged) == "" {
+		finish(AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode, scores: scores, Source: best.Provider, Notes: []string{"synthesis stalled"}, fallback: true}, "", false)
+		return
+	}
+	if err != nil || strings.TrimSpace(final.String()) == "" {
 		// Fallback to best judged candidate
-		best := cands[scores[0].Idx].Text
-		_ = writeNDJSON(w, streamMsg{Type: "status", Text: "synth failed; fallback to best candidate"})
-		_ = writeNDJSON(w, streamMsg{Type: "delta", Text: best})
+		best := cands[scores[0].Idx]
+		if msg := "synth failed; fallback to best candidate"; final.Len() > 0 {
+			resetStream(w, msg)
+		} else {
+			_ = writeNDJSON(w, streamMsg{Type: "status", Text: msg})
+		}
 
-		resp := AnswerResponse{Final: best, Candidates: cands, Cached: false, Mode: mode}
-		cacheSet(key, resp, cacheTTL)
-		_ = writeNDJSON(w, streamMsg{Type: "meta", Meta: resp})
+		finish(AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode, scores: scores, Source: best.Provider, fallback: true}, "", false)
 		return
 	}
 
+	_ = settle()
 	finalText := strings.TrimSpace(final.String())
-	resp := AnswerResponse{Final: finalText, Candidates: cands, Cached: false, Mode: mode}
-	cacheSet(key, resp, cacheTTL)
-	_ = writeNDJSON(w, streamMsg{Type: "meta", Meta: resp})
+	if bad, note := synthRegressed(ctx, gen, req.judgeModel(), req.groundedPrompt(), finalText, top[0], limit); bad {
+		best := top[0]
+		resetStream(w, "synthesis regressed; using best candidate")
+
+		finish(AnswerResponse{Final: best.Text, Candidates: cands, Cached: false, Mode: mode, scores: scores, Source: best.Provider, Notes: []string{note}, fallback: true}, "", false)
+		return
+	}
+	var notes []string
+	if note := synthBudgetNote(req, top); note != "" {
+		notes = append(notes, note)
+	}
+	if note := disagreementNote(req, top); note != "" {
+		notes = append(notes, note)
+	}
+	finish(AnswerResponse{Final: finalText, Candidates: cands, Cached: false, Mode: mode, scores: scores, Source: sourceSynthesis, Notes: notes, Truncated: capped}, raw, true)
 }
 
 func main() {
-	http.HandleFunc("/answer", handleAnswer)
-	http.HandleFunc("/answer/stream", handleAnswerStream)
+	c, err := loadConfig(os.Getenv("CONFIG_FILE"), os.Getenv)
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+	cfg = c
+	log.Printf("effective config: %s", cfg)
+	initAdmission(cfg.MaxInFlight)
+	warnJudgeOverlap()
+	if err := setupRecording(); err != nil {
+		log.Fatalf("recordings: %v", err)
+	}
+	if err := setupAudit(); err != nil {
+		log.Fatalf("audit log: %v", err)
+	}
+	startCacheCompressors()
+	startCacheWriter()
+
+	mux := newMux()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go runHealthProbes(ctx, gen)
+
+	srv := &http.Server{Addr: ":8080", Handler: mux}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(sctx)
+	}()
 
 	log.Println("Go backend listening on :8080 (expects Ollama on :11434)")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGenerator is a Generator for tests. respond decides every reply from
+// the model and prompt; GenerateStream hands the same reply to onDelta a
+// few characters at a time.
+type fakeGenerator struct {
+	respond func(model, prompt string) (string, error)
+
+	mu    sync.Mutex
+	calls []fakeCall
+}
+
+type fakeCall struct{ model, prompt string }
+
+func (f *fakeGenerator) Generate(ctx context.Context, model, prompt string, o genOptions) (string, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, fakeCall{model, prompt})
+	f.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return f.respond(model, prompt)
+}
+
+func (f *fakeGenerator) GenerateStream(ctx context.Context, model, prompt string, o genOptions, onDelta func(string) error) (string, error) {
+	text, err := f.Generate(ctx, model, prompt, o)
+	if err != nil {
+		return "", err
+	}
+	r := []rune(text)
+	for i := 0; i < len(r); i += 4 {
+		if err := ctx.Err(); err != nil {
+			return string(r[:i]), err
+		}
+		if err := onDelta(string(r[i:min(i+4, len(r))])); err != nil {
+			return string(r[:i]), err
+		}
+	}
+	return text, nil
+}
+
+// prompts returns the prompts of the calls whose prompt contains s.
+func (f *fakeGenerator) prompts(s string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for _, c := range f.calls {
+		if strings.Contains(c.prompt, s) {
+			out = append(out, c.prompt)
+		}
+	}
+	return out
+}
+
+// judgedAnswer matches an answer's header in the judge prompt, with or
+// without guardrails.
+var judgedAnswer = regexp.MustCompile(`(?m)^(?:<<<ANSWER |\[)(\d+)\]? \(([^)]*)\)$`)
+
+// ensemble is a fakeGenerator playing a whole pipeline: candidate prompts
+// get answers[model] (an error when missing), the judge scores each answer
+// scores[provider], and synthesis returns synth.
+func ensemble(answers map[string]string, scores map[string]int, synth string) *fakeGenerator {
+	return &fakeGenerator{respond: func(model, prompt string) (string, error) {
+		switch {
+		case strings.HasPrefix(prompt, "You are a strict evaluator.\nScore"):
+			var out []map[string]any
+			for _, m := range judgedAnswer.FindAllStringSubmatch(prompt, -1) {
+				var idx int
+				fmt.Sscan(m[1], &idx)
+				out = append(out, map[string]any{"idx": idx, "score": scores[m[2]]})
+			}
+			b, _ := json.Marshal(out)
+			return string(b), nil
+		case strings.HasPrefix(prompt, "Combine the best parts"):
+			return synth, nil
+		}
+		if a, ok := answers[model]; ok {
+			return a, nil
+		}
+		return "", fmt.Errorf("model %s is down", model)
+	}}
+}
+
+// testConfig installs the default config, changed by edit, as cfg for the
+// rest of the test, with an empty answer cache. Both are put back after.
+func testConfig(t *testing.T, edit func(*config)) {
+	t.Helper()
+	c, err := loadConfig("", func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit != nil {
+		edit(&c)
+	}
+	if err := c.validate(); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	oldCfg := cfg
+	flushCacheWrites()
+	cacheMu.Lock()
+	pendingMu.Lock()
+	oldCache, oldPending := cacheMap, cachePending
+	cfg, cacheMap, cachePending = c, map[string]cacheItem{}, map[string]cacheItem{}
+	pendingMu.Unlock()
+	cacheMu.Unlock()
+	t.Cleanup(func() {
+		flushCacheWrites()
+		cacheMu.Lock()
+		pendingMu.Lock()
+		cfg, cacheMap, cachePending = oldCfg, oldCache, oldPending
+		pendingMu.Unlock()
+		cacheMu.Unlock()
+	})
+}
+
+// useGenerator swaps gen for g for the rest of the test.
+func useGenerator(t *testing.T, g Generator) {
+	t.Helper()
+	old := gen
+	gen = g
+	t.Cleanup(func() { gen = old })
+}
+
+// postAnswer sends body to handler and decodes the JSON response.
+func postAnswer(t *testing.T, handler http.HandlerFunc, body string) (int, AnswerResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/answer", strings.NewReader(body)))
+	var resp AnswerResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode %s: %v", rec.Body, err)
+		}
+	}
+	return rec.Code, resp
+}
+
+// postStream sends body to handleAnswerStream and decodes the NDJSON lines.
+func postStream(t *testing.T, body string) []streamMsg {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleAnswerStream(rec, httptest.NewRequest(http.MethodPost, "/answer/stream", strings.NewReader(body)))
+	var msgs []streamMsg
+	for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+		var m streamMsg
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("decode %q: %v", line, err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// deltas joins the text of a stream's delta messages after the last reset,
+// the answer as a client would show it.
+func deltas(msgs []streamMsg) string {
+	var sb strings.Builder
+	for _, m := range msgs {
+		switch m.Type {
+		case "delta":
+			sb.WriteString(m.Text)
+		case "reset":
+			sb.Reset()
+		}
+	}
+	return sb.String()
+}
+
+func TestPipelineJudgesAndSynthesizes(t *testing.T) {
+	testConfig(t, nil)
+	g := ensemble(
+		map[string]string{"llama3.2": "Paris is the capital of France.", "qwen2.5": "The capital is Paris.", "mistral": "Lyon."},
+		map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 2},
+		"Paris is the capital of France, and its largest city.",
+	)
+	useGenerator(t, g)
+
+	code, resp := postAnswer(t, handleAnswer, `{"prompt":"What is the capital of France?","mode":"quality","include_scores":true}`)
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if resp.Source != sourceSynthesis || resp.Final != "Paris is the capital of France, and its largest city." {
+		t.Fatalf("got %q from %q, want the synthesis", resp.Final, resp.Source)
+	}
+	if len(resp.Candidates) != 3 {
+		t.Fatalf("got %d candidates, want 3", len(resp.Candidates))
+	}
+	if len(resp.Scores) != 3 || resp.Scores[0].Provider != "llama3.2" || resp.Scores[0].Score != 9 {
+		t.Fatalf("scores = %+v, want llama3.2 first with 9", resp.Scores)
+	}
+	synth := g.prompts("Combine the best parts")
+	if len(synth) != 1 {
+		t.Fatalf("%d synthesis calls, want 1", len(synth))
+	}
+	if !strings.Contains(synth[0], "Paris is the capital of France.") || !strings.Contains(synth[0], "The capital is Paris.") || strings.Contains(synth[0], "Lyon.") {
+		t.Errorf("synthesis prompt should merge the top two answers only:\n%s", synth[0])
+	}
+}
+
+func TestPipelineFallsBackWhenJudgeFails(t *testing.T) {
+	testConfig(t, nil)
+	g := ensemble(map[string]string{"llama3.2": "Paris.", "qwen2.5": "It is Paris.", "mistral": "Paris, France."}, nil, "merged")
+	judge := g.respond
+	g.respond = func(model, prompt string) (string, error) {
+		if strings.HasPrefix(prompt, "You are a strict evaluator") {
+			return "not json", nil
+		}
+		return judge(model, prompt)
+	}
+	useGenerator(t, g)
+
+	code, resp := postAnswer(t, handleAnswer, `{"prompt":"capital of France?","mode":"quality"}`)
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if resp.Source == sourceSynthesis || len(g.prompts("Combine the best parts")) != 0 {
+		t.Errorf("a failed judge should skip synthesis, got source %q", resp.Source)
+	}
+}
+
+func TestNormalizeTextKeepsMeaningfulWhitespace(t *testing.T) {
+	tests := []struct{ name, in, want string }{
+		{"line endings and trailing space", "a  \r\nb\t\r\nc", "a\nb\nc"},
+		{"blank line runs", "a\n\n\n\nb", "a\n\nb"},
+		{"nested list", "- top\n  - nested\n    - deeper", "- top\n  - nested\n    - deeper"},
+		{"yaml", "server:\n  port: 8080\n  hosts:\n    - a", "server:\n  port: 8080\n  hosts:\n    - a"},
+		{"table alignment", "| a   | b |\n|-----|---|", "| a   | b |\n|-----|---|"},
+		{"inner spacing", "x  =  1", "x  =  1"},
+		{"leading indent", "\n\n    indented first line", "    indented first line"},
+		{"code keeps blank runs", "```go\nfunc f() {\n\n\n\treturn\n}\n```", "```go\nfunc f() {\n\n\n\treturn\n}\n```"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeText(tt.in); got != tt.want {
+				t.Errorf("normalizeText(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJudgeCandidatesAbsolute(t *testing.T) {
+	testConfig(t, nil)
+	g := ensemble(nil, map[string]int{"a": 4, "b": 9, "c": 6}, "")
+	cands := []Candidate{{Provider: "a", Text: "one"}, {Provider: "b", Text: "two"}, {Provider: "c", Text: "three"}}
+
+	out, err := judgeCandidates(context.Background(), g, "judge", "q", cands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.calls) != 1 {
+		t.Errorf("%d judge calls, want a single one", len(g.calls))
+	}
+	want := []int{1, 2, 0}
+	if len(out) != len(want) {
+		t.Fatalf("got %+v", out)
+	}
+	for i, idx := range want {
+		if out[i].Idx != idx {
+			t.Errorf("rank %d is %d, want %d (%+v)", i, out[i].Idx, idx, out)
+		}
+	}
+
+	g.respond = func(model, prompt string) (string, error) { return `[{"idx":7,"score":9},{"idx":-1,"score":3}]`, nil }
+	if out, err := judgeCandidates(context.Background(), g, "judge", "q", cands); err == nil {
+		t.Errorf("out-of-range indexes only should fail, got %+v", out)
+	}
+}
+
+func TestOllamaClientSendsHeadersAndAPIKey(t *testing.T) {
+	testConfig(t, func(c *config) {
+		c.OllamaHeaders = map[string]string{"X-Proxy-Tenant": "team-a", "X-Trace": "1"}
+		c.OllamaAPIKey = "s3cret"
+	})
+	var got []http.Header
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got = append(got, r.Header.Clone())
+		mu.Unlock()
+		var req ollamaGenerateReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Stream {
+			fmt.Fprintln(w, `{"response":"hi","done":false}`)
+			fmt.Fprintln(w, `{"response":"","done":true}`)
+			return
+		}
+		fmt.Fprint(w, `{"response":"hi"}`)
+	}))
+	defer srv.Close()
+	old := ollamaGenerateURL
+	ollamaGenerateURL = srv.URL + "/api/generate"
+	t.Cleanup(func() { ollamaGenerateURL = old })
+
+	ctx := context.Background()
+	if _, err := (ollamaClient{}).Generate(ctx, "m", "p", genOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (ollamaClient{}).GenerateStream(ctx, "m", "p", genOptions{}, func(string) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("%d requests reached the server, want 2", len(got))
+	}
+	for i, h := range got {
+		if h.Get("Authorization") != "Bearer s3cret" || h.Get("X-Proxy-Tenant") != "team-a" || h.Get("X-Trace") != "1" {
+			t.Errorf("request %d headers = %v", i, h)
+		}
+	}
+}
+
+func TestEnsureMinProvidersCountsSelectedProviders(t *testing.T) {
+	testConfig(t, nil)
+	mc := cfg.Modes["quality"]
+	two := mc.Providers[:2]
+	cands := []Candidate{{Provider: "llama3.2", Text: "a"}, {Provider: "qwen2.5", Text: "b"}}
+	g := ensemble(nil, nil, "")
+
+	if _, degraded, note := ensureMinProviders(context.Background(), g, mc, two, cands, AnswerRequest{}, nil); degraded {
+		t.Errorf("two answers from two selected providers is not degraded: %s", note)
+	}
+	if _, degraded, _ := ensureMinProviders(context.Background(), g, mc, mc.Providers, cands, AnswerRequest{}, nil); !degraded {
+		t.Errorf("two answers from the mode's three providers should be degraded")
+	}
+	if _, degraded, _ := ensureMinProviders(context.Background(), g, mc, two, cands[:1], AnswerRequest{}, nil); !degraded {
+		t.Errorf("one answer from two selected providers should be degraded")
+	}
+}
+
+func TestCacheHitServesScoresAndDebugOnRequest(t *testing.T) {
+	testConfig(t, nil)
+	useGenerator(t, ensemble(
+		map[string]string{"llama3.2": "Paris.", "qwen2.5": "It is Paris.", "mistral": "Paris, France."},
+		map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 5},
+		"Paris is the capital of France.",
+	))
+
+	if _, resp := postAnswer(t, handleAnswer, `{"prompt":"capital?","mode":"quality"}`); resp.Cached || resp.Scores != nil || resp.Debug != nil {
+		t.Fatalf("plain request got cached=%v scores=%v debug=%v", resp.Cached, resp.Scores, resp.Debug)
+	}
+	_, resp := postAnswer(t, handleAnswer, `{"prompt":"capital?","mode":"quality","include_scores":true,"explain":true}`)
+	if !resp.Cached {
+		t.Fatal("second request should be a cache hit")
+	}
+	if len(resp.Scores) != 3 || resp.Scores[0].Provider != "llama3.2" {
+		t.Errorf("scores on a hit = %+v, want the cached ranking", resp.Scores)
+	}
+	if resp.Debug == nil {
+		t.Error("explain on a hit got no debug info")
+	}
+	if _, resp := postAnswer(t, handleAnswer, `{"prompt":"capital?","mode":"quality"}`); resp.Scores != nil || resp.Debug != nil {
+		t.Errorf("plain hit got scores=%v debug=%v", resp.Scores, resp.Debug)
+	}
+}
+
+func TestStrictDeadlineSkipsLenientFallbackInCache(t *testing.T) {
+	// every lenient request finds its budget low and fast-picks
+	testConfig(t, func(c *config) { c.JudgeMinBudget = 1 })
+	useGenerator(t, ensemble(
+		map[string]string{"llama3.2": "Paris.", "qwen2.5": "It is Paris.", "mistral": "Paris, France."},
+		map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 5},
+		"Paris is the capital of France.",
+	))
+
+	if _, resp := postAnswer(t, handleAnswer, `{"prompt":"capital?","mode":"quality"}`); resp.Source == sourceSynthesis {
+		t.Fatalf("lenient request should have fast-picked, got %q", resp.Source)
+	}
+	_, resp := postAnswer(t, handleAnswer, `{"prompt":"capital?","mode":"quality","strict_deadline":true}`)
+	if resp.Cached || resp.Source != sourceSynthesis {
+		t.Errorf("strict request got cached=%v source %q, want its own synthesis", resp.Cached, resp.Source)
+	}
+	if _, resp := postAnswer(t, handleAnswer, `{"prompt":"capital?","mode":"quality","strict_deadline":true}`); !resp.Cached || resp.Final != "Paris is the capital of France." {
+		t.Errorf("repeated strict request got cached=%v %q", resp.Cached, resp.Final)
+	}
+}
+
+func TestStrictDeadlineWithNoCandidatesOnBothEndpoints(t *testing.T) {
+	testConfig(t, func(c *config) {
+		m := c.Modes["quality"]
+		m.Timeout = duration(50 * time.Millisecond)
+		c.Modes["quality"] = m
+		c.NoAnswerMessage = "No model could answer right now."
+	})
+	hang := map[string]bool{}
+	for _, p := range cfg.Modes["quality"].Providers {
+		hang[p.Model] = true
+	}
+	for _, p := range cfg.Modes["quality"].FallbackProviders {
+		hang[p.Model] = true
+	}
+	useGenerator(t, hangingGenerator{ensemble(nil, nil, ""), hang})
+
+	body := `{"prompt":"capital?","mode":"quality","strict_deadline":true}`
+	if code, resp := postAnswer(t, handleAnswer, body); code != http.StatusGatewayTimeout {
+		t.Errorf("/answer got %d %q, want 504", code, resp.Final)
+	}
+	msgs := postStream(t, body)
+	if !slices.ContainsFunc(msgs, func(m streamMsg) bool { return m.Type == "error" && m.Text == deadlineMessage }) || deltas(msgs) != "" {
+		t.Errorf("/answer/stream got %+v, want the deadline error and no answer", msgs)
+	}
+}
+
+func TestSynthRegressionRejudgedByRequestJudge(t *testing.T) {
+	testConfig(t, func(c *config) {
+		c.SynthMinRatio = 0.8
+		c.SynthRegressionRejudge = true
+	})
+	g := ensemble(
+		map[string]string{"llama3.2": "Paris is the capital of France.", "qwen2.5": "The capital is Paris.", "mistral": "Paris, France."},
+		map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 5},
+		"Paris.",
+	)
+	answer := g.respond
+	g.respond = func(model, prompt string) (string, error) {
+		if strings.HasPrefix(prompt, "You are a strict evaluator comparing") {
+			return `{"winner":"B"}`, nil
+		}
+		return answer(model, prompt)
+	}
+	useGenerator(t, g)
+
+	_, resp := postAnswer(t, handleAnswer, `{"prompt":"capital?","mode":"quality","judge_model":"qwen2.5"}`)
+	msgs := postStream(t, `{"prompt":"capital city?","mode":"quality","judge_model":"qwen2.5"}`)
+	if resp.Final != "Paris is the capital of France." || deltas(msgs) != resp.Final {
+		t.Errorf("regressed synthesis kept: /answer %q, stream %q", resp.Final, deltas(msgs))
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var rejudged []string
+	for _, c := range g.calls {
+		if strings.HasPrefix(c.prompt, "You are a strict evaluator comparing") {
+			rejudged = append(rejudged, c.model)
+		}
+	}
+	if !slices.Equal(rejudged, []string{"qwen2.5", "qwen2.5"}) {
+		t.Errorf("regression rejudged by %v, want the request's judge_model twice", rejudged)
+	}
+}
+
+// sessionGenerator answers every candidate prompt with "<model> says hi",
+// fails the models in down, and hands each model back a context of its own
+// while recording the context it was sent.
+type sessionGenerator struct {
+	*fakeGenerator
+	down map[string]bool
+
+	mu   sync.Mutex
+	sent map[string][][]int // model -> contexts received, per call
+}
+
+func (s *sessionGenerator) Generate(ctx context.Context, model, prompt string, o genOptions) (string, error) {
+	if strings.HasPrefix(prompt, "You are a strict evaluator") || strings.HasPrefix(prompt, "Combine the best parts") {
+		return s.fakeGenerator.Generate(ctx, model, prompt, o)
+	}
+	s.mu.Lock()
+	s.sent[model] = append(s.sent[model], o.Context)
+	down := s.down[model]
+	s.mu.Unlock()
+	if down {
+		return "", fmt.Errorf("model %s is down", model)
+	}
+	if o.OnContext != nil {
+		o.OnContext([]int{len(model)})
+	}
+	return model + " says hi", nil
+}
+
+func TestAltModelAnswersWhenPrimaryFails(t *testing.T) {
+	testConfig(t, func(c *config) {
+		m := c.Modes["fast"]
+		m.Providers = []provider{{Model: "llama3.2", AltModel: "llama3.1"}, {Model: "qwen2.5"}}
+		c.Modes["fast"] = m
+	})
+	useGenerator(t, &sessionGenerator{fakeGenerator: ensemble(nil, nil, ""), down: map[string]bool{"llama3.2": true}, sent: map[string][][]int{}})
+
+	_, resp := postAnswer(t, handleAnswer, `{"prompt":"hi","mode":"fast","explain":true}`)
+	var alt *Candidate
+	for i, c := range resp.Candidates {
+		if c.Provider == "llama3.2" {
+			alt = &resp.Candidates[i]
+		}
+	}
+	if alt == nil || alt.Text != "llama3.1 says hi" {
+		t.Fatalf("candidates = %+v, want llama3.2 answered by its alt model", resp.Candidates)
+	}
+	if resp.Debug == nil || resp.Debug.AltModels["llama3.2"] != "llama3.1" {
+		t.Errorf("debug = %+v, want the alt model recorded", resp.Debug)
+	}
+	if !slices.ContainsFunc(resp.Notes, func(n string) bool { return strings.Contains(n, "alt model llama3.1") }) {
+		t.Errorf("notes = %q, want an alt-model note", resp.Notes)
+	}
+}
+
+func TestAltModelKeepsSessionContextApart(t *testing.T) {
+	testConfig(t, func(c *config) {
+		c.SessionContext = true
+		m := c.Modes["fast"]
+		m.Providers = []provider{{Model: "llama3.2", AltModel: "llama3.1"}, {Model: "qwen2.5"}}
+		c.Modes["fast"] = m
+	})
+	freshSessions(t)
+	g := &sessionGenerator{fakeGenerator: ensemble(nil, nil, ""), down: map[string]bool{"llama3.2": true}, sent: map[string][][]int{}}
+	useGenerator(t, g)
+
+	postAnswer(t, handleAnswer, `{"prompt":"turn 1","mode":"fast","session_id":"s"}`) // primary down, alt answers
+	postAnswer(t, handleAnswer, `{"prompt":"turn 2","mode":"fast","session_id":"s"}`) // alt again: continues its own context
+	g.mu.Lock()
+	g.down["llama3.2"] = false
+	g.mu.Unlock()
+	postAnswer(t, handleAnswer, `{"prompt":"turn 3","mode":"fast","session_id":"s"}`) // primary is back
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if got := g.sent["llama3.1"]; len(got) != 2 || got[0] != nil || !slices.Equal(got[1], []int{len("llama3.1")}) {
+		t.Errorf("alt model got contexts %v, want none and then its own", got)
+	}
+	if got := g.sent["llama3.2"]; len(got) != 3 || got[2] != nil {
+		t.Errorf("primary got contexts %v, want none on turn 3 (the alt's tokens aren't its own)", got)
+	}
+	if got := g.sent["qwen2.5"]; len(got) != 3 || !slices.Equal(got[2], []int{len("qwen2.5")}) {
+		t.Errorf("qwen2.5 got contexts %v, want its own from turn 2", got)
+	}
+}
+
+func TestFastPickOrdering(t *testing.T) {
+	lines := func(n int) string { return "answer" + strings.Repeat("\n- point", n) }
+	tests := []struct {
+		name  string
+		cands []Candidate // fastest first, as fanOut returns them
+		want  string
+	}{
+		{"fastest on a tie", []Candidate{
+			{Provider: "fast", Text: lines(2)}, {Provider: "slow", Text: lines(2)},
+		}, "fast"},
+		{"structure beats speed", []Candidate{
+			{Provider: "fast", Text: lines(0)}, {Provider: "slow", Text: lines(4)},
+		}, "slow"},
+		{"one line more is comparable", []Candidate{
+			{Provider: "fast", Text: lines(2)}, {Provider: "slow", Text: lines(3)},
+		}, "fast"},
+		{"priority beats speed", []Candidate{
+			{Provider: "fast", Text: lines(2)}, {Provider: "trusted", Text: lines(2), priority: 5},
+		}, "trusted"},
+		{"priority within a line of the pick", []Candidate{
+			{Provider: "fast", Text: lines(3)}, {Provider: "trusted", Text: lines(2), priority: 5},
+		}, "trusted"},
+		{"priority doesn't beat structure", []Candidate{
+			{Provider: "trusted", Text: lines(0), priority: 9}, {Provider: "structured", Text: lines(5)},
+		}, "structured"},
+		{"highest priority wins", []Candidate{
+			{Provider: "a", Text: lines(1), priority: 1}, {Provider: "b", Text: lines(1), priority: 3}, {Provider: "c", Text: lines(1), priority: 2},
+		}, "b"},
+		{"equal priority falls back to speed", []Candidate{
+			{Provider: "a", Text: lines(1)}, {Provider: "b", Text: lines(1), priority: 2}, {Provider: "c", Text: lines(1), priority: 2},
+		}, "b"},
+		{"refusals are passed over", []Candidate{
+			{Provider: "refuser", Text: lines(1), priority: 9, refusal: true}, {Provider: "b", Text: lines(1)},
+		}, "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fastPick(tt.cands); got.Provider != tt.want {
+				t.Errorf("fastPick picked %s, want %s", got.Provider, tt.want)
+			}
+		})
+	}
+}
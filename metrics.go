@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// -------------------- Metrics --------------------
+
+// handleMetrics serves gauges and counters in the Prometheus text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMetric(w, "llm_queue_depth", "gauge", "Requests waiting for an admission slot.", queueDepth.Load())
+	writeMetric(w, "llm_in_flight", "gauge", "Requests currently running the model pipeline.", inFlight.Load())
+	writeMetric(w, "llm_active_streams", "gauge", "Open /answer/stream connections.", activeStreams.Load())
+	writeMetric(w, "llm_streams_rejected_total", "counter", "Streams refused with 503 because max_streams were open.", streamsRejected.Load())
+	writeMetric(w, "llm_rejected_total", "counter", "Requests rejected with 503 because the queue wait expired.", rejectedTotal.Load())
+	writeMetric(w, "llm_queue_full_total", "counter", "Requests rejected with 503 because max_queue were already waiting.", queueFullTotal.Load())
+	writeMetric(w, "llm_cache_refreshes_total", "counter", "Cache hits regenerated in the background (refresh_probability).", refreshesTotal.Load())
+	writeMetric(w, "llm_speculative_synth_hits_total", "counter", "Speculative syntheses adopted because the judge agreed.", specHits.Load())
+	writeMetric(w, "llm_speculative_synth_misses_total", "counter", "Speculative syntheses discarded after judging.", specMisses.Load())
+	writeMetric(w, "llm_speculative_synth_saved_ms_total", "counter", "Judge time overlapped by adopted speculative syntheses.", specSavedMs.Load())
+	writeProviderMetric(w, "llm_provider_answers_total", "Candidate answers per provider.", providerCounts.answers)
+	writeProviderMetric(w, "llm_provider_failures_total", "Provider calls that errored or answered empty.", providerCounts.failures)
+	writeProviderMetric(w, "llm_provider_cache_hits_total", "Candidate answers per provider served from the candidate cache (candidate_cache_ttl).", providerCounts.cacheHits)
+}
+
+func writeMetric(w http.ResponseWriter, name, typ, help string, v int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, typ, name, v)
+}
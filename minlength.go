@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// -------------------- Minimum candidate length --------------------
+
+// dropShort removes candidates shorter than min_candidate_chars, which are
+// usually errors, refusals or truncations, before they count towards
+// min_providers or reach the judge. If none would be left the longest is
+// kept. The note names what was dropped; empty when nothing was.
+func dropShort(cands []Candidate) (kept []Candidate, note string) {
+	if cfg.MinCandidateChars <= 0 || len(cands) == 0 {
+		return cands, ""
+	}
+	var dropped []string
+	longest := 0
+	for i, c := range cands {
+		n := len([]rune(strings.TrimSpace(c.Text)))
+		if n > len([]rune(strings.TrimSpace(cands[longest].Text))) {
+			longest = i
+		}
+		if n < cfg.MinCandidateChars {
+			dropped = append(dropped, c.Provider)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	if len(dropped) == 0 {
+		return cands, ""
+	}
+	if len(kept) == 0 {
+		return []Candidate{cands[longest]}, fmt.Sprintf("every answer was under %d chars; kept the longest (%s)", cfg.MinCandidateChars, cands[longest].Provider)
+	}
+	return kept, fmt.Sprintf("dropped answers under %d chars: %s", cfg.MinCandidateChars, strings.Join(dropped, ", "))
+}
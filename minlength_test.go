@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDropShortKeepsTheLongestWhenAllAreTooShort(t *testing.T) {
+	testConfig(t, func(c *config) { c.MinCandidateChars = 20 })
+	cands := []Candidate{{Provider: "a", Text: "Yes."}, {Provider: "b", Text: "  Paris, France.  "}, {Provider: "c", Text: ""}}
+	kept, note := dropShort(cands)
+	if len(kept) != 1 || kept[0].Provider != "b" {
+		t.Fatalf("kept %+v, want only the longest", kept)
+	}
+	if !strings.Contains(note, "kept the longest (b)") {
+		t.Errorf("note %q", note)
+	}
+}
+
+func TestDropShortCountsRunesNotBytes(t *testing.T) {
+	testConfig(t, func(c *config) { c.MinCandidateChars = 5 })
+	// four runes, twelve bytes: too short however it is encoded
+	kept, _ := dropShort([]Candidate{{Provider: "ja", Text: "東京です"}, {Provider: "en", Text: "It is Tokyo."}})
+	if len(kept) != 1 || kept[0].Provider != "en" {
+		t.Errorf("kept %+v, want only en", kept)
+	}
+}
+
+func TestDropShortLeavesLongEnoughAnswers(t *testing.T) {
+	testConfig(t, func(c *config) { c.MinCandidateChars = 5 })
+	cands := []Candidate{{Provider: "a", Text: "Paris."}, {Provider: "b", Text: "Lyon is not it."}}
+	if kept, note := dropShort(cands); len(kept) != 2 || note != "" {
+		t.Errorf("kept %d with note %q, want both and no note", len(kept), note)
+	}
+
+	testConfig(t, func(c *config) { c.MinCandidateChars = 0 })
+	if kept, note := dropShort([]Candidate{{Provider: "a", Text: "."}}); len(kept) != 1 || note != "" {
+		t.Errorf("min_candidate_chars 0 dropped %d", 1-len(kept))
+	}
+}
+
+func TestAllTooShortStillAnswers(t *testing.T) {
+	testConfig(t, func(c *config) { c.MinCandidateChars = 50 })
+	useGenerator(t, ensemble(map[string]string{"llama3.2": "Paris.", "qwen2.5": "It is Paris.", "mistral": "Paris"}, nil, "unused"))
+
+	_, resp := postAnswer(t, handleAnswer, `{"prompt":"capital of France?","mode":"quality"}`)
+	if resp.Final != "It is Paris." || len(resp.Candidates) != 1 {
+		t.Errorf("got %q from %d candidates, want the longest alone", resp.Final, len(resp.Candidates))
+	}
+	if !slices.ContainsFunc(resp.Notes, func(n string) bool { return strings.HasPrefix(n, "every answer was under 50 chars") }) {
+		t.Errorf("notes %q", resp.Notes)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import "context"
+
+// -------------------- N-best finals --------------------
+
+// maxNFinal bounds n_final so a request can't fan synthesis out arbitrarily.
+const maxNFinal = 5
+
+// finalOption is one entry of AnswerResponse.Finals.
+type finalOption struct {
+	Provider    string `json:"provider"`
+	Score       *int   `json:"score,omitempty"` // judge score; absent when judging didn't run
+	Text        string `json:"text"`
+	Synthesized bool   `json:"synthesized,omitempty"`
+}
+
+// finalCount is the request's n_final, clamped to [1, maxNFinal].
+func (r AnswerRequest) finalCount() int {
+	return min(max(r.NFinal, 1), maxNFinal)
+}
+
+// nBestFinals returns up to n answers best-first. With scores it follows the
+// judge's ranking; without, fastPick's choice leads and the rest keep latency
+// order. When synthEach is set every option gets its own synthesis pass
+// (falling back to the raw candidate if that fails).
+func nBestFinals(ctx context.Context, g Generator, judgeModel string, req AnswerRequest, cands []Candidate, scores []scored) []finalOption {
+	n, synthEach := req.finalCount(), req.SynthesizeEach
+	var out []finalOption
+	if len(scores) > 0 {
+		for _, s := range scores {
+			score := s.Score
+			out = append(out, finalOption{Provider: cands[s.Idx].Provider, Score: &score, Text: cands[s.Idx].Text})
+		}
+	} else {
+		best := fastPick(cands)
+		out = append(out, finalOption{Provider: best.Provider, Text: best.Text})
+		for _, c := range cands {
+			if c.Provider != best.Provider {
+				out = append(out, finalOption{Provider: c.Provider, Text: c.Text})
+			}
+		}
+	}
+	if len(out) > n {
+		out = out[:n]
+	}
+	if !synthEach {
+		return out
+	}
+
+	done := make(chan struct{}, len(out))
+	for i := range out {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			c := Candidate{Provider: out[i].Provider, Text: out[i].Text}
+			var ranks []scored
+			if out[i].Score != nil {
+				ranks = []scored{{Score: *out[i].Score}}
+			}
+			if text, _, err := synthesize(ctx, g, judgeModel, synthPrompt(req, []Candidate{c}, ranks), req); err == nil {
+				out[i].Text = text
+				out[i].Synthesized = true
+			}
+		}()
+	}
+	for range out {
+		<-done
+	}
+	return out
+}
+
+// rankFinals is used when n_final > 1: it judges (unless disabled or out of
+// time) and returns the options, the judge ranking (nil if judging didn't
+// run or failed), and the response source for the first option.
+func rankFinals(ctx context.Context, g Generator, judgeModel string, req AnswerRequest, cands []Candidate, judge bool) ([]finalOption, []scored, string) {
+	var scores []scored
+	if judge {
+		scores, _ = judgeCandidates(ctx, g, req.judgeModel(), req.groundedPrompt(), cands)
+	}
+	finals := nBestFinals(ctx, g, judgeModel, req, cands, scores)
+	if finals[0].Synthesized {
+		return finals, scores, sourceSynthesis
+	}
+	return finals, scores, finals[0].Provider
+}
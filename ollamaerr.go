@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// -------------------- Ollama failure causes --------------------
+
+// errOllamaUnreachable wraps connection-level failures: nothing is listening
+// on Ollama's address, or it can't be resolved or reached.
+var errOllamaUnreachable = errors.New("ollama unreachable")
+
+// modelNotFoundError is Ollama's 404 for a model that hasn't been pulled.
+type modelNotFoundError struct{ model string }
+
+func (e *modelNotFoundError) Error() string {
+	return fmt.Sprintf("model %s not installed; run `ollama pull %s`", e.model, e.model)
+}
+
+// ollamaDoError classifies an error from sending a request to Ollama.
+func ollamaDoError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if oe := (*net.OpError)(nil); errors.As(err, &oe) {
+		return fmt.Errorf("%w: %v", errOllamaUnreachable, err)
+	}
+	return err
+}
+
+// ollamaStatusError is the error for a non-2xx reply about model.
+func ollamaStatusError(resp *http.Response, model string) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return &modelNotFoundError{model: model}
+	}
+	return fmt.Errorf("ollama non-2xx: %s", resp.Status)
+}
+
+// failureLog collects the errors providers failed with during fan-out, so
+// a request nobody answered can say why.
+type failureLog struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// tap records each provider's error and passes every call on to next.
+func (f *failureLog) tap(next candidateTap) candidateTap {
+	return func(provider, delta string, done bool, err error) {
+		if done && err != nil {
+			f.mu.Lock()
+			f.errs = append(f.errs, err)
+			f.mu.Unlock()
+		}
+		if next != nil {
+			next(provider, delta, done, err)
+		}
+	}
+}
+
+// message explains an empty fan-out, naming each distinct cause once.
+func (f *failureLog) message() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	causes := map[string]bool{}
+	for _, err := range f.errs {
+		var nf *modelNotFoundError
+		switch {
+		case errors.Is(err, errOllamaUnreachable):
+			causes["Ollama is unreachable at localhost:11434 (is it running?)"] = true
+		case errors.As(err, &nf):
+			causes[nf.Error()] = true
+		default:
+			causes[err.Error()] = true
+		}
+	}
+	if len(causes) == 0 {
+		return "no model responses (every model answered empty)"
+	}
+	list := make([]string, 0, len(causes))
+	for c := range causes {
+		list = append(list, c)
+	}
+	sort.Strings(list)
+	return "no model responses: " + strings.Join(list, "; ")
+}
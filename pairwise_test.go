@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+)
+
+// pairwiseAnswer matches the two answers in a pairwise judge prompt, with or
+// without guardrails.
+var pairwiseAnswer = regexp.MustCompile(`(?m)^(?:\S*ANSWER |Answer )([AB]):?\n(.*)$`)
+
+func TestJudgeCandidatesPairwiseRanksByWins(t *testing.T) {
+	for _, guard := range []bool{true, false} {
+		testConfig(t, func(c *config) {
+			c.JudgeStrategy = judgePairwise
+			c.JudgeGuardrails = guard
+		})
+		rank := map[string]int{"best": 3, "good": 2, "poor": 1}
+		g := &fakeGenerator{respond: func(model, prompt string) (string, error) {
+			texts := map[string]string{}
+			for _, m := range pairwiseAnswer.FindAllStringSubmatch(prompt, -1) {
+				texts[m[1]] = m[2]
+			}
+			if rank[texts["A"]] > rank[texts["B"]] {
+				return `{"winner":"A"}`, nil
+			}
+			return `{"winner":"B"}`, nil
+		}}
+		cands := []Candidate{{Provider: "p", Text: "poor"}, {Provider: "b", Text: "best"}, {Provider: "g", Text: "good"}}
+
+		out, err := judgeCandidates(context.Background(), g, "judge", "q", cands)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(g.calls) != 3 {
+			t.Errorf("guardrails=%v: %d judge calls, want one per pair", guard, len(g.calls))
+		}
+		want := []scored{{Idx: 1, Score: 10}, {Idx: 2, Score: 5}, {Idx: 0, Score: 0}}
+		if len(out) != len(want) {
+			t.Fatalf("guardrails=%v: got %+v", guard, out)
+		}
+		for i, w := range want {
+			if out[i].Idx != w.Idx || out[i].Score != w.Score {
+				t.Errorf("guardrails=%v: rank %d = %+v, want idx %d score %d", guard, i, out[i], w.Idx, w.Score)
+			}
+		}
+	}
+}
+
+func TestJudgeCandidatesPairwiseSkipsFailedMatches(t *testing.T) {
+	testConfig(t, func(c *config) { c.JudgeStrategy = judgePairwise })
+	g := &fakeGenerator{respond: func(model, prompt string) (string, error) { return `{"winner":"C"}`, nil }}
+	cands := []Candidate{{Provider: "a", Text: "one"}, {Provider: "b", Text: "two"}}
+	if out, err := judgeCandidates(context.Background(), g, "judge", "q", cands); err == nil {
+		t.Fatalf("an unknown winner everywhere should fail, got %+v", out)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// -------------------- Voices --------------------
+
+// personaTerse is the built-in default voice: concise, no fluff. It can't be
+// redefined in personas.
+const personaTerse = "terse"
+
+// persona is a named voice a request picks with "voice". Synthesis replaces
+// the default "be concise, no fluff" rule in the synthesis prompt;
+// Candidates, if set, is also given to every provider.
+type persona struct {
+	Synthesis  string `json:"synthesis"`
+	Candidates string `json:"candidates,omitempty"`
+}
+
+func checkVoice(req AnswerRequest) error {
+	if req.Voice == "" || req.Voice == personaTerse {
+		return nil
+	}
+	if _, ok := cfg.Personas[req.Voice]; ok {
+		return nil
+	}
+	names := []string{personaTerse}
+	for name := range cfg.Personas {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return fmt.Errorf("unknown voice %q (configured: %s)", req.Voice, strings.Join(names, ", "))
+}
+
+// persona returns the request's voice, if it picked one other than terse.
+func (r AnswerRequest) persona() (persona, bool) {
+	p, ok := cfg.Personas[r.Voice]
+	return p, ok && r.Voice != personaTerse
+}
+
+// synthRules is the synthesis prompt's rules line, in the request's voice.
+// Contradictions are only to be removed when the answers aren't being
+// presented side by side (surface_disagreement).
+func (r AnswerRequest) synthRules(disagree bool) string {
+	rules := "Rules: be correct"
+	if !disagree {
+		rules += ", remove contradictions"
+	}
+	if p, ok := r.persona(); ok {
+		return rules + ".\n" + strings.TrimSpace(p.Synthesis) + "\n"
+	}
+	return rules + ", be concise, no fluff.\n"
+}
+
+// voiceRule is the candidate-prompt instruction for the request's voice;
+// empty unless the persona sets one.
+func (r AnswerRequest) voiceRule() string {
+	if p, ok := r.persona(); ok && p.Candidates != "" {
+		return strings.TrimSpace(p.Candidates) + "\n"
+	}
+	return ""
+}
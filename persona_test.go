@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func personaEnsemble() *fakeGenerator {
+	return ensemble(
+		map[string]string{"llama3.2": "Paris is the capital of France.", "qwen2.5": "The capital is Paris.", "mistral": "Paris."},
+		map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 5},
+		"Paris it is.",
+	)
+}
+
+func TestPersonaReachesTheSynthesisPrompt(t *testing.T) {
+	testConfig(t, func(c *config) {
+		c.Personas["pirate"] = persona{Synthesis: "Answer like a pirate.", Candidates: "Keep it nautical."}
+	})
+	g := personaEnsemble()
+	useGenerator(t, g)
+
+	if code, _ := postAnswer(t, handleAnswer, `{"prompt":"capital of France?","mode":"quality","voice":"pirate"}`); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	synth := g.prompts("Combine the best parts")
+	if len(synth) != 1 || !strings.Contains(synth[0], "Answer like a pirate.") || strings.Contains(synth[0], "be concise, no fluff") {
+		t.Fatalf("synthesis prompt %q, want the pirate voice instead of terse", synth)
+	}
+	for _, p := range g.prompts("capital of France?") {
+		if strings.HasPrefix(p, "Combine the best parts") || strings.HasPrefix(p, "You are a strict evaluator.") {
+			continue
+		}
+		if !strings.Contains(p, "Keep it nautical.") {
+			t.Errorf("candidate prompt without the persona's instruction: %q", p)
+		}
+	}
+}
+
+func TestConfiguredPersonaReachesTheSynthesisPrompt(t *testing.T) {
+	testConfig(t, nil)
+	g := personaEnsemble()
+	useGenerator(t, g)
+
+	postAnswer(t, handleAnswer, `{"prompt":"capital of France?","mode":"quality","voice":"formal"}`)
+	if synth := g.prompts("Combine the best parts"); len(synth) != 1 || !strings.Contains(synth[0], cfg.Personas["formal"].Synthesis) {
+		t.Errorf("synthesis prompt %q, want the formal voice", synth)
+	}
+}
+
+func TestTerseIsTheDefaultVoice(t *testing.T) {
+	for _, voice := range []string{"", `,"voice":"terse"`} {
+		testConfig(t, nil)
+		g := personaEnsemble()
+		useGenerator(t, g)
+
+		postAnswer(t, handleAnswer, `{"prompt":"capital of France?","mode":"quality"`+voice+`}`)
+		synth := g.prompts("Combine the best parts")
+		if len(synth) != 1 || !strings.Contains(synth[0], "be concise, no fluff") {
+			t.Fatalf("voice %q: synthesis prompt %q, want the terse rule", voice, synth)
+		}
+		for _, p := range cfg.Personas {
+			if strings.Contains(synth[0], p.Synthesis) {
+				t.Errorf("voice %q: a persona leaked into the synthesis prompt", voice)
+			}
+		}
+	}
+}
+
+func TestUnknownVoiceIsRejected(t *testing.T) {
+	testConfig(t, nil)
+	useGenerator(t, personaEnsemble())
+	if code, _ := postAnswer(t, handleAnswer, `{"prompt":"capital of France?","voice":"shouty"}`); code != http.StatusBadRequest {
+		t.Errorf("got %d, want 400", code)
+	}
+}
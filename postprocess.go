@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// -------------------- Final answer post-processing --------------------
+
+const truncationMarker = "… [truncated]"
+
+// errAnswerCapped stops a streamed synthesis once max_answer_chars is reached.
+var errAnswerCapped = errors.New("answer length cap reached")
+
+// finalizeAnswer applies per-request post-processing to a freshly computed
+// answer. It runs after synthesis and before caching, so cached values are
+// already in their delivered form. Structured (JSON) answers are left alone,
+// since any of these edits could break the document.
+func finalizeAnswer(resp *AnswerResponse, req AnswerRequest) {
+	if req.structured() {
+		return
+	}
+	if cfg.NormalizeOutput {
+		resp.Final = normalizeText(resp.Final)
+	}
+	if cfg.DedupeThreshold > 0 {
+		resp.Final = dedupeRepeats(resp.Final, cfg.DedupeThreshold)
+		for i := range resp.Finals {
+			resp.Finals[i].Text = dedupeRepeats(resp.Finals[i].Text, cfg.DedupeThreshold)
+		}
+	}
+	if n := bodyCharLimit(req); n > 0 {
+		// a streamed answer arrives already cut, with Truncated set
+		var cut bool
+		resp.Final, cut = truncateAnswer(resp.Final, n)
+		resp.Truncated = resp.Truncated || cut
+		for i := range resp.Finals {
+			resp.Finals[i].Text, cut = truncateAnswer(resp.Finals[i].Text, n)
+			resp.Truncated = resp.Truncated || cut
+		}
+	}
+	resp.Final = cfg.AnswerPrefix + resp.Final + cfg.AnswerSuffix
+	for i := range resp.Finals {
+		resp.Finals[i].Text = cfg.AnswerPrefix + resp.Finals[i].Text + cfg.AnswerSuffix
+	}
+}
+
+// bodyCharLimit is the cap left for the answer itself once the configured
+// prefix/suffix banner is accounted for (0 = no cap).
+func bodyCharLimit(req AnswerRequest) int {
+	n := answerCharLimit(req)
+	if n == 0 {
+		return 0
+	}
+	n -= utf8.RuneCountInString(cfg.AnswerPrefix) + utf8.RuneCountInString(cfg.AnswerSuffix)
+	return max(n, 1)
+}
+
+// answerCharLimit is the request's max_answer_chars clamped to the server max (0 = no cap).
+func answerCharLimit(req AnswerRequest) int {
+	n := req.MaxAnswerChars
+	if n <= 0 {
+		return 0
+	}
+	if cfg.MaxAnswerChars > 0 && n > cfg.MaxAnswerChars {
+		n = cfg.MaxAnswerChars
+	}
+	return n
+}
+
+// truncateAnswer cuts s so that it, plus the marker, fits in limit characters.
+// It prefers a sentence end, then a word break, in the last third of the window.
+func truncateAnswer(s string, limit int) (string, bool) {
+	if utf8.RuneCountInString(s) <= limit {
+		return s, false
+	}
+	keep := limit - utf8.RuneCountInString(truncationMarker) - 1
+	if keep <= 0 {
+		return string([]rune(truncationMarker)[:limit]), true
+	}
+	head := string([]rune(s)[:keep])
+
+	floor := len(head) * 2 / 3
+	cut := -1
+	for _, sep := range []string{". ", "! ", "? ", ".\n", "\n"} {
+		if i := strings.LastIndex(head, sep); i >= floor && i+1 > cut {
+			cut = i + 1
+		}
+	}
+	if cut < 0 {
+		if i := strings.LastIndexAny(head, " \t\n"); i >= floor {
+			cut = i
+		}
+	}
+	if cut > 0 {
+		head = head[:cut]
+	}
+	return strings.TrimRight(head, " \t\n") + " " + truncationMarker, true
+}
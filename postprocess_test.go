@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAnswerBannerOnEveryPath(t *testing.T) {
+	const prefix, suffix = "[AI-generated] ", " (verify before use)"
+	banner := func(c *config) { c.AnswerPrefix, c.AnswerSuffix = prefix, suffix }
+	agree := map[string]string{"llama3.2": "Paris is the capital.", "qwen2.5": "The capital is Paris.", "mistral": "Paris."}
+	scores := map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 3}
+
+	tests := []struct {
+		name, body, source string
+		judgeFails         bool
+	}{
+		{"synthesis", `{"prompt":"capital?","mode":"quality"}`, sourceSynthesis, false},
+		{"fast pick", `{"prompt":"capital?","mode":"fast"}`, "", false},
+		{"fallback", `{"prompt":"capital?","mode":"quality"}`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig(t, banner)
+			g := ensemble(agree, scores, "Paris is the capital of France.")
+			if tt.judgeFails {
+				answer := g.respond
+				g.respond = func(model, prompt string) (string, error) {
+					if strings.HasPrefix(prompt, "You are a strict evaluator") {
+						return "no", nil
+					}
+					return answer(model, prompt)
+				}
+			}
+			useGenerator(t, g)
+
+			for _, cached := range []bool{false, true} {
+				code, resp := postAnswer(t, handleAnswer, tt.body)
+				if code != http.StatusOK || resp.Cached != cached {
+					t.Fatalf("status %d, cached %v, want cached %v", code, resp.Cached, cached)
+				}
+				if !cached && tt.source != "" && resp.Source != tt.source {
+					t.Fatalf("source %q, want %q", resp.Source, tt.source)
+				}
+				if !cached && tt.source == "" && resp.Source == sourceSynthesis {
+					t.Fatalf("expected a single provider's answer, got synthesis")
+				}
+				body := strings.TrimSuffix(strings.TrimPrefix(resp.Final, prefix), suffix)
+				if body == resp.Final || strings.Contains(body, prefix) || strings.Contains(body, suffix) {
+					t.Errorf("cached=%v: final %q should carry the banner exactly once", cached, resp.Final)
+				}
+			}
+
+			fresh := strings.Replace(tt.body, "capital?", "capital city?", 1)
+			for _, body := range []string{fresh, fresh} {
+				stream := deltas(postStream(t, body))
+				if !strings.HasPrefix(stream, prefix) || !strings.HasSuffix(stream, suffix) || strings.Count(stream, prefix) != 1 {
+					t.Errorf("streamed answer %q should carry the banner once", stream)
+				}
+			}
+		})
+	}
+}
+
+func TestTruncateAnswer(t *testing.T) {
+	for _, tc := range []struct {
+		name, in string
+		limit    int
+		want     string
+		cut      bool
+	}{
+		{"fits", "Paris is the capital.", 21, "Paris is the capital.", false},
+		{"sentence end", "Paris is the capital. It sits on the Seine river in the north.", 40, "Paris is the capital. " + truncationMarker, true},
+		{"word break", "Paris is the capital and largest city of France", 40, "Paris is the capital and " + truncationMarker, true},
+		{"no break", strings.Repeat("x", 50), 30, strings.Repeat("x", 16) + " " + truncationMarker, true},
+		{"tiny limit", "Paris is the capital.", 5, string([]rune(truncationMarker)[:5]), true},
+	} {
+		got, cut := truncateAnswer(tc.in, tc.limit)
+		if got != tc.want || cut != tc.cut {
+			t.Errorf("%s: got %q, %v; want %q, %v", tc.name, got, cut, tc.want, tc.cut)
+		}
+		if n := len([]rune(got)); n > tc.limit {
+			t.Errorf("%s: %d runes over the %d limit", tc.name, n, tc.limit)
+		}
+	}
+}
+
+func TestMaxAnswerCharsOnBothEndpoints(t *testing.T) {
+	long := strings.Repeat("Paris is the capital of France and its largest city. ", 8)
+	for _, tc := range []struct {
+		name  string
+		synth string
+		limit int
+		cut   bool
+	}{
+		{"over the cap", long, 80, true},
+		{"just under the cap", "Paris is the capital of France and its largest city.", 60, false},
+		{"tiny cap", long, 5, true},
+	} {
+		testConfig(t, nil)
+		useGenerator(t, ensemble(
+			map[string]string{"llama3.2": "Paris.", "qwen2.5": "It is Paris.", "mistral": "Paris, France."},
+			map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 5},
+			tc.synth,
+		))
+		body := fmt.Sprintf(`{"prompt":"capital?","mode":"quality","max_answer_chars":%d}`, tc.limit)
+
+		_, resp := postAnswer(t, handleAnswer, body)
+		msgs := postStream(t, strings.Replace(body, "capital?", "capital city?", 1))
+		var meta AnswerResponse
+		b, _ := json.Marshal(msgs[len(msgs)-1].Meta)
+		_ = json.Unmarshal(b, &meta)
+
+		for endpoint, r := range map[string]AnswerResponse{"/answer": resp, "/answer/stream": meta} {
+			marked := strings.HasSuffix(r.Final, truncationMarker) || strings.HasPrefix(truncationMarker, r.Final)
+			if r.Truncated != tc.cut || marked != tc.cut {
+				t.Errorf("%s %s: truncated=%v final %q", tc.name, endpoint, r.Truncated, r.Final)
+			}
+			if n := len([]rune(r.Final)); n > tc.limit {
+				t.Errorf("%s %s: %d runes over the %d cap", tc.name, endpoint, n, tc.limit)
+			}
+		}
+		if d := deltas(msgs); d != meta.Final {
+			t.Errorf("%s: streamed %q, meta final %q", tc.name, d, meta.Final)
+		}
+	}
+}
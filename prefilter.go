@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// -------------------- Judge pre-filter --------------------
+
+// judgePrefilter trims the candidate set before judging so large ensembles
+// don't pay to score answers that are unlikely to win. Zero disables a rule.
+type judgePrefilter struct {
+	// FastestK keeps only the K lowest-latency candidates.
+	FastestK int `json:"fastest_k"`
+	// MinChars drops candidates shorter than this; very short answers are
+	// usually errors or refusals.
+	MinChars int `json:"min_chars"`
+}
+
+// prefilterJudge marks candidates the judge should skip, recording why in
+// judgeSkip for the debug trace; refusals (see markRefusals) are always
+// skipped. If the rules would leave nothing to judge they're ignored for
+// this request.
+func prefilterJudge(cands []Candidate) {
+	pf := cfg.JudgePrefilter
+	if pf.FastestK <= 0 && pf.MinChars <= 0 && !slices.ContainsFunc(cands, func(c Candidate) bool { return c.refusal }) {
+		return
+	}
+	for i := range cands {
+		if cands[i].refusal {
+			cands[i].judgeSkip = "refusal"
+		} else if pf.MinChars > 0 && len([]rune(cands[i].Text)) < pf.MinChars {
+			cands[i].judgeSkip = fmt.Sprintf("shorter than %d chars", pf.MinChars)
+		}
+	}
+	if pf.FastestK > 0 {
+		order := make([]int, 0, len(cands))
+		for i, c := range cands {
+			if c.judgeSkip == "" {
+				order = append(order, i)
+			}
+		}
+		sort.SliceStable(order, func(a, b int) bool { return cands[order[a]].LatencyMs < cands[order[b]].LatencyMs })
+		for _, i := range order[min(pf.FastestK, len(order)):] {
+			cands[i].judgeSkip = fmt.Sprintf("not among the %d fastest", pf.FastestK)
+		}
+	}
+	for _, c := range cands {
+		if c.judgeSkip == "" {
+			return
+		}
+	}
+	for i := range cands {
+		cands[i].judgeSkip = ""
+	}
+}
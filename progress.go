@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// -------------------- Stream progress --------------------
+
+// progressInterval is the minimum gap between progress status messages.
+const progressInterval = time.Second
+
+// progressMeter turns the synthesis token stream into occasional status
+// messages. With a num_predict cap the percentage is tokens so far over the
+// cap (an upper bound, so it can jump to done early). Without one it reports
+// an ETA from the token rate and an expected length. Ollama streams about
+// one token per chunk, so chunks are counted as tokens.
+type progressMeter struct {
+	limit    int // num_predict, 0 when unset
+	expected int // token estimate used when there is no limit
+	start    time.Time
+	last     time.Time
+	tokens   int
+}
+
+// newProgressMeter returns nil when progress reporting is off. expectedChars
+// is a rough size for the answer (the top candidate's length will do).
+func newProgressMeter(limit, expectedChars int) *progressMeter {
+	if !cfg.StreamProgress {
+		return nil
+	}
+	now := time.Now()
+	return &progressMeter{limit: limit, expected: max(expectedChars/4, 1), start: now, last: now}
+}
+
+// tick counts one streamed chunk and returns a status message when one is due.
+func (p *progressMeter) tick(now time.Time) (streamMsg, bool) {
+	if p == nil {
+		return streamMsg{}, false
+	}
+	p.tokens++
+	if now.Sub(p.last) < progressInterval {
+		return streamMsg{}, false
+	}
+	p.last = now
+	if p.limit > 0 {
+		pct := min(p.tokens*100/p.limit, 99)
+		return streamMsg{Type: "status", Text: fmt.Sprintf("synthesizing... %d%%", pct), Percent: pct}, true
+	}
+	rate := float64(p.tokens) / now.Sub(p.start).Seconds()
+	left := math.Ceil(float64(max(p.expected-p.tokens, 0)) / rate)
+	if left <= 0 {
+		return streamMsg{Type: "status", Text: "synthesizing... almost done"}, true
+	}
+	return streamMsg{Type: "status", Text: fmt.Sprintf("synthesizing... about %.0fs left", left), ETAs: left}, true
+}
@@ -0,0 +1,95 @@
+package main
+
+import "strings"
+
+// -------------------- Reasoning stripping --------------------
+
+// reasoningFilter removes tag-delimited reasoning sections (e.g. <think>...</think>)
+// from text that may arrive in arbitrary chunks. Text that could still turn into
+// an opening tag is held back until the next chunk decides it.
+type reasoningFilter struct {
+	tags   []tagPair
+	buf    string
+	inside *tagPair
+}
+
+func newReasoningFilter(tags []tagPair) *reasoningFilter {
+	return &reasoningFilter{tags: tags}
+}
+
+// Write feeds a chunk and returns whatever is now safe to emit.
+func (f *reasoningFilter) Write(chunk string) string {
+	if len(f.tags) == 0 {
+		return chunk
+	}
+	f.buf += chunk
+
+	var out strings.Builder
+	for {
+		if f.inside != nil {
+			i := strings.Index(f.buf, f.inside.Close)
+			if i < 0 {
+				// keep just enough to recognise a close tag split across chunks
+				if keep := len(f.inside.Close) - 1; len(f.buf) > keep {
+					f.buf = f.buf[len(f.buf)-keep:]
+				}
+				return out.String()
+			}
+			f.buf = f.buf[i+len(f.inside.Close):]
+			f.inside = nil
+			continue
+		}
+
+		idx, tag := -1, (*tagPair)(nil)
+		for i := range f.tags {
+			if j := strings.Index(f.buf, f.tags[i].Open); j >= 0 && (idx < 0 || j < idx) {
+				idx, tag = j, &f.tags[i]
+			}
+		}
+		if tag != nil {
+			out.WriteString(f.buf[:idx])
+			f.buf = f.buf[idx+len(tag.Open):]
+			f.inside = tag
+			continue
+		}
+
+		hold := f.partialOpenSuffix()
+		out.WriteString(f.buf[:len(f.buf)-hold])
+		f.buf = f.buf[len(f.buf)-hold:]
+		return out.String()
+	}
+}
+
+// Flush returns any held-back text. An unterminated reasoning section is dropped.
+func (f *reasoningFilter) Flush() string {
+	rest := f.buf
+	f.buf = ""
+	if f.inside != nil {
+		f.inside = nil
+		return ""
+	}
+	return rest
+}
+
+// partialOpenSuffix reports how many trailing bytes of buf are a prefix of some open tag.
+func (f *reasoningFilter) partialOpenSuffix() int {
+	best := 0
+	for _, t := range f.tags {
+		for n := len(t.Open) - 1; n > best; n-- {
+			if strings.HasSuffix(f.buf, t.Open[:n]) {
+				best = n
+				break
+			}
+		}
+	}
+	return best
+}
+
+// stripReasoning removes configured reasoning sections from a complete text.
+func stripReasoning(text string) string {
+	if len(cfg.ReasoningTags) == 0 {
+		return text
+	}
+	f := newReasoningFilter(cfg.ReasoningTags)
+	return strings.TrimSpace(f.Write(text) + f.Flush())
+}
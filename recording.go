@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// -------------------- Record / replay --------------------
+
+// recording_mode values
+const (
+	recordingRecord = "record"
+	recordingReplay = "replay"
+)
+
+// recording is one line of the recordings file (JSON Lines). Key is the hex
+// SHA-256 of model + "\n" + prompt; model is kept alongside for people
+// reading the file. Generation options are not part of the key, so a replay
+// returns the recorded output whatever the sampling settings.
+//
+//	{"key":"3f1c…","model":"llama3.2","response":"Paris is the capital of France."}
+type recording struct {
+	Key      string `json:"key"`
+	Model    string `json:"model"`
+	Response string `json:"response"`
+}
+
+func recordingKey(model, prompt string) string {
+	sum := sha256.Sum256([]byte(model + "\n" + prompt))
+	return fmt.Sprintf("%x", sum[:])
+}
+
+// replayClient answers from a recordings file instead of calling Ollama, so
+// the service runs without a GPU. Unknown model+prompt pairs fail like an
+// unreachable model would.
+type replayClient struct {
+	responses map[string]string
+}
+
+func loadReplayClient(path string) (replayClient, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return replayClient{}, err
+	}
+	defer f.Close()
+
+	c := replayClient{responses: map[string]string{}}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 16<<20)
+	for n := 1; sc.Scan(); n++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec recording
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return replayClient{}, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		c.responses[rec.Key] = rec.Response
+	}
+	return c, sc.Err()
+}
+
+var errNoRecording = errors.New("no recorded response for this model and prompt")
+
+func (c replayClient) Generate(ctx context.Context, model, prompt string, o genOptions) (string, error) {
+	if r, ok := c.responses[recordingKey(model, prompt)]; ok {
+		return r, nil
+	}
+	return "", errNoRecording
+}
+
+// GenerateStream sends the recorded response in word-boundary chunks so
+// streaming paths see more than a single delta.
+func (c replayClient) GenerateStream(ctx context.Context, model, prompt string, o genOptions, onDelta func(string) error) (string, error) {
+	r, ok := c.responses[recordingKey(model, prompt)]
+	if !ok {
+		return "", errNoRecording
+	}
+	for _, chunk := range chunkText(r, 16) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if err := onDelta(chunk); err != nil {
+			return "", err
+		}
+	}
+	return r, nil
+}
+
+// recordingClient passes calls through to next and appends every successful
+// response to the recordings file.
+type recordingClient struct {
+	next Generator
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func newRecordingClient(path string, next Generator) (*recordingClient, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingClient{next: next, f: f}, nil
+}
+
+func (c *recordingClient) Generate(ctx context.Context, model, prompt string, o genOptions) (string, error) {
+	r, err := c.next.Generate(ctx, model, prompt, o)
+	if err == nil {
+		c.save(model, prompt, r)
+	}
+	return r, err
+}
+
+func (c *recordingClient) GenerateStream(ctx context.Context, model, prompt string, o genOptions, onDelta func(string) error) (string, error) {
+	r, err := c.next.GenerateStream(ctx, model, prompt, o, onDelta)
+	if err == nil {
+		c.save(model, prompt, r)
+	}
+	return r, err
+}
+
+func (c *recordingClient) save(model, prompt, response string) {
+	b, _ := json.Marshal(recording{Key: recordingKey(model, prompt), Model: model, Response: response})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.f.Write(append(b, '\n')); err != nil {
+		log.Printf("recordings: %v", err)
+	}
+}
+
+// setupRecording swaps gen for a replaying or recording client per config.
+func setupRecording() error {
+	switch cfg.RecordingMode {
+	case recordingReplay:
+		c, err := loadReplayClient(cfg.RecordingsFile)
+		if err != nil {
+			return err
+		}
+		log.Printf("replaying %d recorded responses from %s (Ollama is not called)", len(c.responses), cfg.RecordingsFile)
+		gen = c
+	case recordingRecord:
+		c, err := newRecordingClient(cfg.RecordingsFile, gen)
+		if err != nil {
+			return err
+		}
+		log.Printf("recording model responses to %s", cfg.RecordingsFile)
+		gen = c
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// -------------------- Stale-while-revalidate --------------------
+
+// A mode with refresh_probability > 0 regenerates that fraction of its cache
+// hits in the background: the hit is served as usual and the request is
+// replayed through handleAnswer, skipping the cache lookup, so its answer
+// replaces the entry. Popular prompts drift towards what the current models
+// produce without anyone waiting on a miss.
+//
+// Refreshes go through the admission queue like any request, are skipped
+// outright while it is full, and run at most one per cache key. Sampled
+// modes aren't refreshed: the replay could draw other providers and so
+// write another key. Nor are session turns: the replay would advance the
+// caller's stored session context behind their back. A refresh is not a
+// request anyone made, so it is left out of the audit log and the
+// per-provider counters.
+
+type refreshKey struct{}
+
+var (
+	refreshMu      sync.Mutex
+	refreshing     = map[string]bool{}
+	refreshesTotal atomic.Int64
+)
+
+// isRefresh reports whether ctx belongs to a background refresh replay.
+func isRefresh(ctx context.Context) bool {
+	v, _ := ctx.Value(refreshKey{}).(bool)
+	return v
+}
+
+// maybeRefresh starts a background refresh of key, served from r, with the
+// mode's refresh_probability.
+func maybeRefresh(r *http.Request, req AnswerRequest, mode, key string) {
+	mc := cfg.Modes[mode]
+	if mc.RefreshProbability <= 0 || mc.SampleSize > 0 || req.SessionID != "" || rand.Float64() >= mc.RefreshProbability {
+		return
+	}
+	if admitSlots != nil && len(admitSlots) == cap(admitSlots) {
+		return
+	}
+	refreshMu.Lock()
+	if refreshing[key] {
+		refreshMu.Unlock()
+		return
+	}
+	refreshing[key] = true
+	refreshMu.Unlock()
+
+	req.Variations = 0 // nobody reads them
+	body, err := json.Marshal(req)
+	if err != nil {
+		refreshMu.Lock()
+		delete(refreshing, key)
+		refreshMu.Unlock()
+		return
+	}
+	r2 := r.Clone(context.WithValue(context.Background(), refreshKey{}, true))
+	r2.Method = http.MethodPost
+	r2.URL.RawQuery = ""
+	r2.Body = io.NopCloser(bytes.NewReader(body))
+	r2.ContentLength = int64(len(body))
+	refreshesTotal.Add(1)
+	go func() {
+		defer func() {
+			refreshMu.Lock()
+			delete(refreshing, key)
+			refreshMu.Unlock()
+		}()
+		handleAnswer(discardWriter{header: http.Header{}}, r2)
+	}()
+}
+
+// discardWriter is the ResponseWriter for a refresh nobody is waiting on.
+type discardWriter struct{ header http.Header }
+
+func (d discardWriter) Header() http.Header         { return d.header }
+func (d discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d discardWriter) WriteHeader(int)             {}
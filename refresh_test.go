@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// refreshEverything sets refresh_probability 1 on every mode.
+func refreshEverything(c *config) {
+	for name, mc := range c.Modes {
+		mc.RefreshProbability = 1
+		c.Modes[name] = mc
+	}
+}
+
+// waitRefreshes waits for background refreshes to finish.
+func waitRefreshes(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		refreshMu.Lock()
+		n := len(refreshing)
+		refreshMu.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("refresh still running")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSessionTurnsAreNotRefreshed(t *testing.T) {
+	testConfig(t, func(c *config) {
+		refreshEverything(c)
+		c.SessionContext = true
+	})
+	g := ensemble(map[string]string{"llama3.2": "Paris.", "qwen2.5": "Paris."}, nil, "Paris.")
+	useGenerator(t, g)
+
+	body := `{"prompt":"capital of France?","mode":"fast","session_id":"s1"}`
+	postAnswer(t, handleAnswer, body)
+	calls := len(g.prompts("capital of France?"))
+	before := refreshesTotal.Load()
+	if _, resp := postAnswer(t, handleAnswer, body); !resp.Cached {
+		t.Fatal("second turn missed the cache")
+	}
+	waitRefreshes(t)
+	if refreshesTotal.Load() != before || len(g.prompts("capital of France?")) != calls {
+		t.Error("a session turn was replayed in the background")
+	}
+}
+
+func TestRefreshesAreNotAuditedOrCounted(t *testing.T) {
+	testConfig(t, refreshEverything)
+	g := ensemble(map[string]string{"llama3.2": "Paris.", "qwen2.5": "Paris."}, nil, "Paris.")
+	useGenerator(t, g)
+	var buf bytes.Buffer
+	old := auditOut
+	auditOut = &buf
+	t.Cleanup(func() { auditOut = old })
+
+	body := `{"prompt":"capital of France?","mode":"fast"}`
+	postAnswer(t, handleAnswer, body)
+	providerCounts.Lock()
+	answers := providerCounts.answers["llama3.2"]
+	providerCounts.Unlock()
+	calls := len(g.prompts("capital of France?"))
+
+	before := refreshesTotal.Load()
+	postAnswer(t, handleAnswer, body)
+	waitRefreshes(t)
+	if refreshesTotal.Load() != before+1 || len(g.prompts("capital of France?")) == calls {
+		t.Fatal("the cache hit wasn't refreshed")
+	}
+
+	auditMu.Lock()
+	lines := strings.Count(buf.String(), "\n")
+	auditMu.Unlock()
+	if lines != 2 {
+		t.Errorf("got %d audit lines, want one per client request", lines)
+	}
+	providerCounts.Lock()
+	got := providerCounts.answers["llama3.2"]
+	providerCounts.Unlock()
+	if got != answers {
+		t.Errorf("provider answers went from %d to %d on a refresh", answers, got)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// -------------------- Refusal detection --------------------
+
+// defaultRefusalPatterns are phrasings models open a refusal with. An answer
+// is a refusal only when it starts with one, case-insensitively, so a real
+// answer that quotes one or declines a side point ("I can't provide exact
+// figures, but...") isn't flagged.
+var defaultRefusalPatterns = []string{
+	"i can't help with", "i cannot help with", "i can't assist with", "i cannot assist with",
+	"i'm sorry, but i can't", "i'm sorry, but i cannot", "i am sorry, but i cannot",
+	"sorry, but i can't", "sorry, i can't", "i apologize, but i can't", "i apologize, but i cannot",
+	"i'm unable to help", "i am unable to help", "i'm not able to help", "i won't be able to help",
+	"i can't comply", "i cannot comply", "i must decline", "as an ai language model, i cannot",
+}
+
+func isRefusal(text string) bool {
+	head := strings.ToLower(strings.TrimLeft(text, " \t\r\n*_>\"'"))
+	head = strings.ReplaceAll(head, "’", "'")
+	for _, p := range cfg.RefusalPatterns {
+		if p != "" && strings.HasPrefix(head, strings.ToLower(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// markRefusals flags candidates matching refusal_patterns. Flagged ones are
+// left out of judging and lose fastPick to any real answer; when every
+// candidate refused, all is set and the request gets refusedResponse. The
+// note names the refusing providers; empty when there were none.
+func markRefusals(cands []Candidate) (note string, all bool) {
+	var names []string
+	for i := range cands {
+		if isRefusal(cands[i].Text) {
+			cands[i].refusal = true
+			names = append(names, cands[i].Provider)
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	if len(names) == len(cands) {
+		return "every model refused the prompt", true
+	}
+	return fmt.Sprintf("set aside refusals from %s", strings.Join(names, ", ")), false
+}
+
+// refusedResponse is the answer when every model refused: no Final, so a
+// refusal is never passed off as the answer, with refused set and the
+// refusals kept as candidates. Like unavailableResponse, it is never cached.
+func refusedResponse(mode string, cands []Candidate, note string) AnswerResponse {
+	return AnswerResponse{Final: "", Candidates: cands, Mode: mode, Source: sourceRefused, Refused: true, Notes: []string{note}}
+}
+
+// withoutRefusals returns the candidates that aren't refusals, or all of
+// them if none are.
+func withoutRefusals(cands []Candidate) []Candidate {
+	var kept []Candidate
+	for _, c := range cands {
+		if !c.refusal {
+			kept = append(kept, c)
+		}
+	}
+	if len(kept) == 0 {
+		return cands
+	}
+	return kept
+}
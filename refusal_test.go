@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIsRefusal(t *testing.T) {
+	testConfig(t, nil)
+	for _, tc := range []struct {
+		text string
+		want bool
+	}{
+		{"I can't help with that.", true},
+		{"I cannot assist with that request.", true},
+		{"I’m sorry, but I can’t help with creating malware.", true},
+		{"I'm sorry, but I cannot provide instructions for that.", true},
+		{"Sorry, I can't do that.", true},
+		{"I apologize, but I cannot comply with this request.", true},
+		{"I'm unable to help with that. If you're struggling, please reach out to someone you trust.", true},
+		{"I must decline to answer.", true},
+		{"As an AI language model, I cannot browse the internet.", true},
+		{"  **I can't help with that.**", true},
+
+		{"Paris is the capital of France.", false},
+		{"I can't provide exact figures, but the population is about 2.1 million.", false},
+		{"The error \"I can't help with that\" means the assistant refused; retry with more context.", false},
+		{"To reset it, hold the button. If it says I'm unable to help, the device needs service.", false},
+		{"", false},
+	} {
+		if got := isRefusal(tc.text); got != tc.want {
+			t.Errorf("isRefusal(%q) = %v, want %v", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestRefusalDetectionOff(t *testing.T) {
+	testConfig(t, func(c *config) { c.RefusalPatterns = nil })
+	if isRefusal("I can't help with that.") {
+		t.Error("flagged a refusal with refusal_patterns empty")
+	}
+}
+
+func TestAllRefusedIsNotPresentedAsTheAnswer(t *testing.T) {
+	testConfig(t, nil)
+	useGenerator(t, ensemble(map[string]string{
+		"llama3.2": "I can't help with that.",
+		"qwen2.5":  "I'm sorry, but I cannot assist with this request.",
+		"mistral":  "Sorry, I can't do that.",
+	}, nil, "I can't help with that."))
+
+	body := `{"prompt":"how do I pick a lock?","mode":"quality"}`
+	code, resp := postAnswer(t, handleAnswer, body)
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if resp.Final != "" || !resp.Refused || resp.Source != sourceRefused || len(resp.Candidates) != 3 {
+		t.Errorf("got final %q refused=%v source %q with %d candidates, want an empty refused answer", resp.Final, resp.Refused, resp.Source, len(resp.Candidates))
+	}
+	if _, again := postAnswer(t, handleAnswer, body); again.Cached {
+		t.Error("a refusal was cached")
+	}
+
+	msgs := postStream(t, body)
+	last := msgs[len(msgs)-1]
+	if d := deltas(msgs); d != "" {
+		t.Errorf("streamed %q for a refusal", d)
+	}
+	if m, _ := last.Meta.(map[string]any); last.Type != "meta" || m["refused"] != true || m["final"] != "" {
+		t.Errorf("stream ended with %+v, want a refused meta", last)
+	}
+}
+
+func TestSomeRefusedPicksARealAnswer(t *testing.T) {
+	testConfig(t, nil)
+	useGenerator(t, ensemble(map[string]string{
+		"llama3.2": "I can't help with that.",
+		"qwen2.5":  "Paris is the capital of France.",
+	}, map[string]int{"qwen2.5": 8}, "Paris is the capital of France."))
+
+	_, resp := postAnswer(t, handleAnswer, `{"prompt":"capital of France?","mode":"fast"}`)
+	if resp.Refused || resp.Final != "Paris is the capital of France." {
+		t.Errorf("got %q refused=%v, want the real answer", resp.Final, resp.Refused)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -------------------- Client deadlines --------------------
+
+// requestTimeout reads X-Timeout-Ms, which replaces the mode's timeout for
+// one request. It returns 0 when the header is absent. Values above
+// cfg.MaxRequestTimeout are clamped, and the response says so in a Warning
+// header.
+func requestTimeout(w http.ResponseWriter, r *http.Request) (time.Duration, error) {
+	h := strings.TrimSpace(r.Header.Get("X-Timeout-Ms"))
+	if h == "" {
+		return 0, nil
+	}
+	ms, err := strconv.ParseInt(h, 10, 64)
+	if err != nil || ms <= 0 {
+		return 0, fmt.Errorf("X-Timeout-Ms must be a positive integer, got %q", h)
+	}
+	d, limit := time.Duration(ms)*time.Millisecond, time.Duration(cfg.MaxRequestTimeout)
+	if limit > 0 && d > limit {
+		w.Header().Set("Warning", fmt.Sprintf(`299 - "X-Timeout-Ms clamped to %d"`, limit.Milliseconds()))
+		d = limit
+	}
+	return d, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// -------------------- Routes --------------------
+
+// routes lists every endpoint with the methods it serves; anything else gets
+// a 405 with an Allow header from allowMethods, so handlers don't check.
+var routes = []struct {
+	path    string
+	methods []string
+	h       http.HandlerFunc
+}{
+	{"/answer", []string{http.MethodGet, http.MethodPost}, handleAnswer},
+	{"/answer/stream", []string{http.MethodPost}, handleAnswerStream},
+	{"/answer/transcript/{id}", []string{http.MethodGet}, handleTranscript},
+	{"/answer/stream/resume/{id}", []string{http.MethodGet}, handleResume},
+	{"/metrics", []string{http.MethodGet}, handleMetrics},
+	{"/version", []string{http.MethodGet}, handleVersion},
+	{"/admin/providers", []string{http.MethodGet}, handleProviderHealth},
+	{"/admin/diagnostics", []string{http.MethodGet}, handleDiagnostics},
+}
+
+// newMux registers routes under cfg.BasePath, logging each full path.
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, rt := range routes {
+		mux.HandleFunc(cfg.BasePath+rt.path, allowMethods(rt.methods, rt.h))
+		log.Printf("route: %s %s%s", strings.Join(rt.methods, ","), cfg.BasePath, rt.path)
+	}
+	return mux
+}
+
+// allowMethods answers requests with other methods 405, listing the
+// allowed ones in the Allow header as RFC 9110 requires.
+func allowMethods(methods []string, h http.HandlerFunc) http.HandlerFunc {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(methods, r.Method) {
+			w.Header().Set("Allow", allow)
+			writeJSON(w, http.StatusMethodNotAllowed, errResp{Error: strings.ReplaceAll(allow, ", ", " or ") + " only"})
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoutesAllowOnlyTheirMethods(t *testing.T) {
+	testConfig(t, nil)
+	useGenerator(t, ensemble(map[string]string{"llama3.2": "Paris.", "qwen2.5": "Paris."}, nil, "Paris."))
+	mux := newMux()
+
+	for _, tc := range []struct {
+		path     string
+		allow    string
+		allowed  []string
+		rejected []string
+	}{
+		{"/answer?prompt=hi&mode=fast", "GET, POST", []string{http.MethodGet, http.MethodPost}, []string{http.MethodPut, http.MethodDelete, http.MethodPatch, http.MethodHead}},
+		{"/answer/stream", "POST", []string{http.MethodPost}, []string{http.MethodGet, http.MethodPut, http.MethodDelete}},
+		{"/answer/transcript/abc", "GET", []string{http.MethodGet}, []string{http.MethodPost, http.MethodDelete}},
+		{"/answer/stream/resume/abc", "GET", []string{http.MethodGet}, []string{http.MethodPost, http.MethodPut}},
+		{"/metrics", "GET", []string{http.MethodGet}, []string{http.MethodPost, http.MethodDelete}},
+		{"/version", "GET", []string{http.MethodGet}, []string{http.MethodPost, http.MethodPut}},
+		{"/admin/providers", "GET", []string{http.MethodGet}, []string{http.MethodPost, http.MethodDelete}},
+		{"/admin/diagnostics", "GET", []string{http.MethodGet}, []string{http.MethodPost, http.MethodPut}},
+	} {
+		for _, m := range tc.rejected {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(m, tc.path, nil))
+			if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != tc.allow {
+				t.Errorf("%s %s: got %d with Allow %q, want 405 with %q", m, tc.path, rec.Code, rec.Header().Get("Allow"), tc.allow)
+			}
+		}
+		for _, m := range tc.allowed {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(m, tc.path, nil))
+			if rec.Code == http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "" {
+				t.Errorf("%s %s: got %d with Allow %q, want it served", m, tc.path, rec.Code, rec.Header().Get("Allow"))
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// -------------------- Provider sampling --------------------
+
+// sample_strategy values
+const (
+	sampleRandom     = "random"
+	sampleRoundRobin = "round_robin"
+	sampleLRU        = "lru"
+	sampleWeighted   = "weighted"
+)
+
+var (
+	sampleMu   sync.Mutex
+	sampleNext = map[string]int{}       // mode -> round-robin start
+	sampleUsed = map[string]time.Time{} // mode + "/" + provider name -> last pick
+)
+
+// peekSample is the sample the next request would get, for its cache
+// lookup, without using it up: cache hits and requests turned away by the
+// admission queue must not move round_robin or lru along. It returns nil
+// when the mode doesn't sample.
+func peekSample(mode string) []provider {
+	return sampleProviders(mode, false)
+}
+
+// takeSample picks the sample an admitted request runs with and records
+// the pick. Another request may have taken the peeked one meanwhile, so it
+// can differ from peekSample's.
+func takeSample(mode string) []provider {
+	return sampleProviders(mode, true)
+}
+
+// sampleProviders picks sample_size of the mode's providers for one request,
+// or returns nil when the mode doesn't sample. The pick keeps config order.
+// With take, the rotation advances and the picks are stamped for lru.
+func sampleProviders(mode string, take bool) []provider {
+	mc := cfg.Modes[mode]
+	k, n := mc.SampleSize, len(mc.Providers)
+	if k <= 0 || k >= n {
+		return nil
+	}
+
+	sampleMu.Lock()
+	defer sampleMu.Unlock()
+
+	var idx []int
+	switch mc.SampleStrategy {
+	case sampleRoundRobin:
+		start := sampleNext[mode]
+		for i := range k {
+			idx = append(idx, (start+i)%n)
+		}
+		if take {
+			sampleNext[mode] = (start + k) % n
+		}
+	case sampleLRU:
+		idx = make([]int, n)
+		for i := range idx {
+			idx[i] = i
+		}
+		// never-used providers have the zero time and go first
+		slices.SortStableFunc(idx, func(a, b int) int {
+			return sampleUsed[mode+"/"+mc.Providers[a].displayName()].Compare(sampleUsed[mode+"/"+mc.Providers[b].displayName()])
+		})
+		idx = idx[:k]
+	case sampleWeighted:
+		idx = weightedPick(mc.Providers, k)
+	default:
+		idx = rand.Perm(n)[:k]
+	}
+
+	slices.Sort(idx)
+	now := time.Now()
+	out := make([]provider, 0, k)
+	for _, i := range idx {
+		out = append(out, mc.Providers[i])
+		if take {
+			sampleUsed[mode+"/"+mc.Providers[i].displayName()] = now
+		}
+	}
+	return out
+}
+
+// weightedPick draws k distinct provider indexes with probability in
+// proportion to their weights (Efraimidis-Spirakis: the k largest
+// u^(1/weight) for uniform u).
+func weightedPick(ps []provider, k int) []int {
+	keys := make([]float64, len(ps))
+	idx := make([]int, len(ps))
+	for i, p := range ps {
+		w := p.Weight
+		if w == 0 {
+			w = 1
+		}
+		keys[i] = math.Pow(rand.Float64(), 1/w)
+		idx[i] = i
+	}
+	slices.SortFunc(idx, func(a, b int) int { return cmp.Compare(keys[b], keys[a]) })
+	return idx[:k]
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSamplingRotatesOnlyForAdmittedMisses(t *testing.T) {
+	testConfig(t, func(c *config) {
+		m := c.Modes["fast"]
+		m.Providers = []provider{{Model: "a"}, {Model: "b"}, {Model: "c"}, {Model: "d"}}
+		m.MinProviders = 2
+		m.SampleSize = 2
+		m.SampleStrategy = sampleRoundRobin
+		c.Modes["fast"] = m
+	})
+	sampleMu.Lock()
+	delete(sampleNext, "fast")
+	sampleMu.Unlock()
+	useGenerator(t, ensemble(map[string]string{"a": "Paris.", "b": "Paris.", "c": "Paris.", "d": "Paris."}, nil, ""))
+
+	next := func() int {
+		sampleMu.Lock()
+		defer sampleMu.Unlock()
+		return sampleNext["fast"]
+	}
+	body := `{"prompt":"capital?","mode":"fast"}`
+
+	for i, want := range []struct {
+		cached bool
+		next   int
+		first  string
+	}{
+		{false, 2, "a"}, // miss: runs a,b
+		{false, 0, "c"}, // miss: runs c,d
+		{true, 0, "a"},  // hit on a,b: the rotation stays put
+		{true, 0, "a"},
+	} {
+		code, resp := postAnswer(t, handleAnswer, body)
+		if code != http.StatusOK || resp.Cached != want.cached || len(resp.Candidates) != 2 {
+			t.Fatalf("request %d: status %d cached %v with %d candidates", i, code, resp.Cached, len(resp.Candidates))
+		}
+		if !resp.Cached && resp.Candidates[0].Provider != want.first && resp.Candidates[1].Provider != want.first {
+			t.Errorf("request %d ran %+v, want %s in the sample", i, resp.Candidates, want.first)
+		}
+		if got := next(); got != want.next {
+			t.Errorf("request %d: rotation at %d, want %d", i, got, want.next)
+		}
+	}
+
+	sample := peekSample("fast")
+	if got := next(); got != 0 || len(sample) != 2 || sample[0].Model != "a" {
+		t.Errorf("peek moved the rotation to %d or picked %+v", got, sample)
+	}
+}
+
+func TestPeekSampleDoesNotStampLRU(t *testing.T) {
+	testConfig(t, func(c *config) {
+		m := c.Modes["fast"]
+		m.Providers = []provider{{Model: "a"}, {Model: "b"}, {Model: "c"}}
+		m.MinProviders = 1
+		m.SampleSize = 1
+		m.SampleStrategy = sampleLRU
+		c.Modes["fast"] = m
+	})
+	sampleMu.Lock()
+	for _, p := range []string{"a", "b", "c"} {
+		delete(sampleUsed, "fast/"+p)
+	}
+	sampleMu.Unlock()
+
+	for range 3 {
+		if s := peekSample("fast"); s[0].Model != "a" {
+			t.Fatalf("peek picked %s, want a every time", s[0].Model)
+		}
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		if s := takeSample("fast"); s[0].Model != want {
+			t.Errorf("take picked %s, want %s", s[0].Model, want)
+		}
+	}
+}
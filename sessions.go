@@ -0,0 +1,94 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// -------------------- Session context reuse --------------------
+//
+// Ollama returns a "context" token array with each generation; sending it
+// back with the next prompt continues the conversation without resending
+// (and re-processing) the history. With session_context on, a request
+// carrying session_id gets each provider's context from that session's
+// previous turn, and its prompt should hold only the new turn.
+//
+// The tokens are only meaningful to the model that produced them, so a
+// context is reused only for the same provider name and model within the
+// same session. A provider whose model changed, or an expired session,
+// starts over from the prompt alone. Sessions belong to the caller that
+// started them (see caller): another client sending the same session_id
+// gets a session of its own.
+//
+// Contexts are kept in least-recently-saved order. Every save renews the
+// TTL, so that is also expiry order: a save drops expired entries from the
+// old end, and entries past max_sessions, without scanning the rest.
+
+type sessionKey struct{ caller, session, provider string }
+
+type sessionEntry struct {
+	key     sessionKey
+	model   string
+	context []int
+	exp     time.Time
+}
+
+var (
+	sessionMu   sync.Mutex
+	sessionCtxs = map[sessionKey]*list.Element{} // values are *sessionEntry
+	sessionLRU  = list.New()                     // most recently saved first
+)
+
+// sessionContext returns the stored context for p in req's session, or nil.
+func sessionContext(req AnswerRequest, p provider) []int {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	el, ok := sessionCtxs[sessionKey{req.caller, req.SessionID, p.displayName()}]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*sessionEntry)
+	if e.model != p.Model || time.Now().After(e.exp) {
+		return nil
+	}
+	return e.context
+}
+
+// saveSessionContext stores p's latest context for req's session, then
+// drops expired entries and any over cfg.MaxSessions, oldest first.
+func saveSessionContext(req AnswerRequest, p provider, context []int) {
+	if len(context) == 0 {
+		return
+	}
+	now := time.Now()
+	k := sessionKey{req.caller, req.SessionID, p.displayName()}
+	e := &sessionEntry{key: k, model: p.Model, context: context, exp: now.Add(time.Duration(cfg.SessionTTL))}
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	if el, ok := sessionCtxs[k]; ok {
+		el.Value = e
+		sessionLRU.MoveToFront(el)
+	} else {
+		sessionCtxs[k] = sessionLRU.PushFront(e)
+	}
+	for el := sessionLRU.Back(); el != nil; el = sessionLRU.Back() {
+		old := el.Value.(*sessionEntry)
+		if sessionLRU.Len() <= cfg.MaxSessions && !now.After(old.exp) {
+			break
+		}
+		sessionLRU.Remove(el)
+		delete(sessionCtxs, old.key)
+	}
+}
+
+// sessionOptions wires session context into a provider's generation when
+// the request has a session and session_context is on.
+func sessionOptions(o *genOptions, req AnswerRequest, p provider) {
+	if !cfg.SessionContext || req.SessionID == "" {
+		return
+	}
+	o.Context = sessionContext(req, p)
+	o.OnContext = func(c []int) { saveSessionContext(req, p, c) }
+}
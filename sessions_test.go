@@ -0,0 +1,73 @@
+package main
+
+import (
+	"container/list"
+	"slices"
+	"testing"
+	"time"
+)
+
+// freshSessions empties the session store for the rest of the test.
+func freshSessions(t *testing.T) {
+	t.Helper()
+	sessionMu.Lock()
+	sessionCtxs, sessionLRU = map[sessionKey]*list.Element{}, list.New()
+	sessionMu.Unlock()
+}
+
+func TestSessionContextIsScopedToCaller(t *testing.T) {
+	testConfig(t, nil)
+	freshSessions(t)
+	p := provider{Model: "llama3.2"}
+	alice := AnswerRequest{SessionID: "s1", caller: "alice"}
+	mallory := AnswerRequest{SessionID: "s1", caller: "mallory"}
+
+	saveSessionContext(alice, p, []int{1, 2, 3})
+	if got := sessionContext(alice, p); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Fatalf("owner got %v", got)
+	}
+	if got := sessionContext(mallory, p); got != nil {
+		t.Errorf("another caller with the same session_id got %v", got)
+	}
+	if got := sessionContext(alice, provider{Model: "qwen2.5", Name: "llama3.2"}); got != nil {
+		t.Errorf("a provider whose model changed got %v", got)
+	}
+}
+
+func TestSessionContextEvictsLeastRecentlySaved(t *testing.T) {
+	testConfig(t, func(c *config) { c.MaxSessions = 2 })
+	freshSessions(t)
+	p := provider{Model: "llama3.2"}
+	s := func(id string) AnswerRequest { return AnswerRequest{SessionID: id} }
+
+	saveSessionContext(s("a"), p, []int{1})
+	saveSessionContext(s("b"), p, []int{2})
+	saveSessionContext(s("a"), p, []int{3}) // a is now the most recent
+	saveSessionContext(s("c"), p, []int{4})
+
+	if got := sessionContext(s("b"), p); got != nil {
+		t.Errorf("b should have been evicted, got %v", got)
+	}
+	if got := sessionContext(s("a"), p); !slices.Equal(got, []int{3}) {
+		t.Errorf("a = %v, want its latest context", got)
+	}
+	if n := len(sessionCtxs); n != 2 || sessionLRU.Len() != 2 {
+		t.Errorf("%d entries (%d listed), want 2", n, sessionLRU.Len())
+	}
+}
+
+func TestSessionContextExpires(t *testing.T) {
+	testConfig(t, func(c *config) { c.SessionTTL = duration(20 * time.Millisecond) })
+	freshSessions(t)
+	p := provider{Model: "llama3.2"}
+
+	saveSessionContext(AnswerRequest{SessionID: "old"}, p, []int{1})
+	time.Sleep(30 * time.Millisecond)
+	if got := sessionContext(AnswerRequest{SessionID: "old"}, p); got != nil {
+		t.Errorf("expired session returned %v", got)
+	}
+	saveSessionContext(AnswerRequest{SessionID: "new"}, p, []int{2})
+	if _, ok := sessionCtxs[sessionKey{"", "old", "llama3.2"}]; ok || len(sessionCtxs) != 1 {
+		t.Errorf("the next save should drop the expired entry, have %d", len(sessionCtxs))
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+// -------------------- Source preservation --------------------
+
+const (
+	// preserveSourcesRule replaces the usual "be concise" pressure on
+	// references: synthesis otherwise tends to drop them.
+	preserveSourcesRule = "Keep every citation, reference and link from the answers that supports what you keep; merge duplicates and do not invent new ones.\n"
+	sourcesSectionRule  = "End with a \"Sources\" section listing each distinct source once.\n"
+)
+
+// sourcesRule is the synthesis instruction for preserve_sources and
+// sources_section; empty (the terse default) when neither is set.
+func (r AnswerRequest) sourcesRule() string {
+	switch {
+	case r.SourcesSection:
+		return preserveSourcesRule + sourcesSectionRule
+	case r.PreserveSources:
+		return preserveSourcesRule
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// -------------------- Speculative synthesis --------------------
+
+var (
+	specHits    atomic.Int64
+	specMisses  atomic.Int64
+	specSavedMs atomic.Int64 // judge time overlapped by adopted speculations
+)
+
+// speculation is a synthesis started on a provisional top two while the
+// judge is still scoring. Deltas queue up (the producer blocks once the
+// buffer is full) until the handler adopts the run because the judge picked
+// the same two answers, or discards it. The speculative prompt carries no
+// rank hints, since there are no scores yet.
+type speculation struct {
+	pair    [2]string
+	started time.Time
+	cancel  context.CancelFunc
+	deltas  chan string // closed when generation ends
+
+	// set before deltas is closed
+	raw string
+	err error
+}
+
+// speculate reports whether a request should synthesize speculatively: only
+// when enabled, in quality mode (where synthesis always runs), and for a
+// single final answer.
+func speculate(mode string, req AnswerRequest) bool {
+	return cfg.SpeculativeSynth && mode == "quality" && req.synthEnabled() && req.finalCount() == 1
+}
+
+// startSpeculation synthesizes the two longest candidates, on the theory that
+// the judge tends to favour the fuller answers.
+func startSpeculation(ctx context.Context, g Generator, model string, req AnswerRequest, cands []Candidate) *speculation {
+	if len(cands) < 2 {
+		return nil
+	}
+	top := slices.Clone(cands)
+	slices.SortStableFunc(top, func(a, b Candidate) int { return len(b.Text) - len(a.Text) })
+	top = top[:2]
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &speculation{pair: [2]string{top[0].Provider, top[1].Provider}, started: time.Now(), cancel: cancel, deltas: make(chan string, 256)}
+	o := synthOptions()
+	o.Format = req.ResponseSchema
+	go func() {
+		defer close(s.deltas)
+		s.raw, s.err = g.GenerateStream(ctx, model, synthPrompt(req, top, nil), o, func(d string) error {
+			select {
+			case s.deltas <- d:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return s
+}
+
+// adopt keeps the speculation if top (the judged top two) is the pair it
+// synthesized, in either order; otherwise it is cancelled.
+func (s *speculation) adopt(top []Candidate) bool {
+	if s == nil {
+		return false
+	}
+	if len(top) == 2 && (top[0].Provider == s.pair[0] && top[1].Provider == s.pair[1] ||
+		top[0].Provider == s.pair[1] && top[1].Provider == s.pair[0]) {
+		saved := time.Since(s.started).Milliseconds()
+		specHits.Add(1)
+		specSavedMs.Add(saved)
+		log.Printf("speculative synthesis adopted (%s + %s); %dms head start", s.pair[0], s.pair[1], saved)
+		return true
+	}
+	specMisses.Add(1)
+	s.discard()
+	return false
+}
+
+// discard cancels the run. Safe to call more than once, and on nil.
+func (s *speculation) discard() {
+	if s != nil {
+		s.cancel()
+	}
+}
+
+// result waits for an adopted speculation to finish.
+func (s *speculation) result() (text, raw string, err error) {
+	for range s.deltas {
+	}
+	if s.err != nil {
+		return "", s.raw, s.err
+	}
+	text = stripReasoning(s.raw)
+	if strings.TrimSpace(text) == "" {
+		return "", s.raw, errors.New("speculative synthesis returned no text")
+	}
+	return text, s.raw, nil
+}
+
+// stream feeds an adopted speculation through emit the way a live streamed
+// synthesis would be, queued deltas first.
+func (s *speculation) stream(emit func(string) error) (string, error) {
+	filter := newReasoningFilter(cfg.ReasoningTags)
+	for d := range s.deltas {
+		if err := emit(filter.Write(d)); err != nil {
+			s.discard()
+			for range s.deltas {
+			}
+			if errors.Is(err, errAnswerCapped) {
+				return s.raw, nil
+			}
+			return s.raw, err
+		}
+	}
+	if s.err != nil {
+		return s.raw, s.err
+	}
+	if err := emit(filter.Flush()); err != nil && !errors.Is(err, errAnswerCapped) {
+		return s.raw, err
+	}
+	return s.raw, nil
+}
+
+// synthesizeTop merges top, reusing spec when the judge agreed with it, and
+// applies the request's validators.
+func synthesizeTop(ctx context.Context, g Generator, model string, req AnswerRequest, top []Candidate, scores []scored, spec *speculation) (text, raw string, err error) {
+	prompt := synthPrompt(req, top, scores[:len(top)])
+	if spec.adopt(top) {
+		if text, raw, err := spec.result(); err == nil && (!req.CodeFormatting || fencesOK(text)) {
+			return validateSynth(ctx, g, model, prompt, req, text, raw, nil)
+		}
+	}
+	text, raw, err = synthesize(ctx, g, model, prompt, req)
+	return validateSynth(ctx, g, model, prompt, req, text, raw, err)
+}
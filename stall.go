@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// -------------------- Synthesis stall watchdog --------------------
+
+var errSynthStalled = errors.New("synthesis stalled")
+
+// stallWatchdog cancels a streamed generation that goes quiet: if kick
+// isn't called within d, the context it hands out is cancelled with
+// errSynthStalled, which aborts the in-flight Ollama request.
+type stallWatchdog struct {
+	d time.Duration
+	t *time.Timer
+}
+
+// watchStall derives a context from ctx that the watchdog can cancel. With
+// d <= 0 there is no watchdog and kick is a no-op. Call stop when the
+// generation is over.
+func watchStall(ctx context.Context, d time.Duration) (context.Context, *stallWatchdog, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	w := &stallWatchdog{d: d}
+	if d > 0 {
+		w.t = time.AfterFunc(d, func() { cancel(errSynthStalled) })
+	}
+	return ctx, w, func() {
+		if w.t != nil {
+			w.t.Stop()
+		}
+		cancel(nil)
+	}
+}
+
+// kick records progress, restarting the countdown.
+func (w *stallWatchdog) kick() {
+	if w.t != nil {
+		w.t.Reset(w.d)
+	}
+}
+
+// stalled reports whether ctx (from watchStall) was cancelled by the watchdog.
+func stalled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errSynthStalled)
+}
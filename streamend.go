@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// -------------------- Stream termination --------------------
+//
+// Every /answer/stream response that gets past admission ends with a meta
+// line carrying state, so clients can tell how it ended:
+//
+//	complete   an answer was produced (meta holds it as usual)
+//	cancelled  the request deadline (mode timeout or X-Timeout-Ms) ran out
+//	           first; a "cancelled" line with the reason comes before it
+//	failed     the pipeline gave up; an "error" line says why
+//
+// A client that disconnected gets nothing: there is no one to tell (unless
+// the stream is resumable, in which case the transcript records the end
+// for the resume endpoint). A stalled synthesis (synth_stall_timeout) is
+// not a cancellation: the stream carries on with the best candidate and
+// ends complete.
+//
+// Deltas already sent can be taken back: when a synthesis is abandoned after
+// some of it went out (it stalled, failed midway, or regressed below
+// synth_min_ratio), a "reset" line says why, and the client drops every
+// delta it has so far. The deltas after it are the whole answer.
+
+// stream states, on the closing meta line
+const (
+	streamComplete  = "complete"
+	streamCancelled = "cancelled"
+	streamFailed    = "failed"
+)
+
+// resetStream tells the client to discard the deltas it has so far.
+func resetStream(w http.ResponseWriter, reason string) {
+	_ = writeNDJSON(w, streamMsg{Type: "reset", Text: reason})
+}
+
+// endStream sends the closing lines for a stream that returned without its
+// closing meta.
+func endStream(w http.ResponseWriter, ctx, conn context.Context, resumable bool) {
+	if conn.Err() != nil && !resumable {
+		return
+	}
+	if ctx.Err() == nil {
+		_ = writeNDJSON(w, streamMsg{Type: "meta", State: streamFailed})
+		return
+	}
+	reason := "cancelled"
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		reason = "request deadline exceeded"
+	}
+	_ = writeNDJSON(w, streamMsg{Type: "cancelled", Text: reason})
+	_ = writeNDJSON(w, streamMsg{Type: "meta", State: streamCancelled})
+}
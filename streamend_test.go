@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamResetsRegressedSynthesis(t *testing.T) {
+	testConfig(t, nil)
+	best := "Paris is the capital of France. " + strings.Repeat("It sits on the Seine and has been the capital for centuries. ", 5)
+	useGenerator(t, ensemble(
+		map[string]string{"llama3.2": best, "qwen2.5": "The capital is Paris.", "mistral": "Paris."},
+		map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 3},
+		"Paris.",
+	))
+
+	msgs := postStream(t, `{"prompt":"capital?","mode":"quality"}`)
+	var sawPartial, sawReset bool
+	for _, m := range msgs {
+		switch m.Type {
+		case "delta":
+			sawPartial = sawPartial || !sawReset
+		case "reset":
+			sawReset = true
+		}
+	}
+	if !sawPartial || !sawReset {
+		t.Fatalf("want the synthesis deltas taken back by a reset, got %+v", msgs)
+	}
+	if got := deltas(msgs); strings.TrimSpace(got) != strings.TrimSpace(best) {
+		t.Errorf("answer after reset = %q, want the best candidate", got)
+	}
+}
+
+// brokenSynth streams part of the synthesis and then stalls until the
+// watchdog cancels it, or fails outright with fail set.
+type brokenSynth struct {
+	*fakeGenerator
+	fail bool
+}
+
+func (b brokenSynth) GenerateStream(ctx context.Context, model, prompt string, o genOptions, onDelta func(string) error) (string, error) {
+	if !strings.HasPrefix(prompt, "Combine the best parts") {
+		return b.fakeGenerator.GenerateStream(ctx, model, prompt, o, onDelta)
+	}
+	if err := onDelta("Partial merge of "); err != nil {
+		return "", err
+	}
+	if b.fail {
+		return "Partial merge of ", errors.New("model crashed")
+	}
+	<-ctx.Done()
+	return "Partial merge of ", ctx.Err()
+}
+
+func TestStreamResetsAbandonedSynthesis(t *testing.T) {
+	const best = "Paris is the capital of France."
+	for _, fail := range []bool{false, true} {
+		testConfig(t, func(c *config) {
+			c.SynthStallTimeout = duration(50 * time.Millisecond)
+			c.SynthRetries = 0
+		})
+		useGenerator(t, brokenSynth{ensemble(
+			map[string]string{"llama3.2": best, "qwen2.5": "The capital is Paris.", "mistral": "Paris."},
+			map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 3},
+			"",
+		), fail})
+
+		msgs := postStream(t, `{"prompt":"capital?","mode":"quality"}`)
+		resets := 0
+		for _, m := range msgs {
+			if m.Type == "reset" {
+				resets++
+			}
+		}
+		if resets != 1 {
+			t.Errorf("fail=%v: %d reset lines, want 1: %+v", fail, resets, msgs)
+		}
+		if got := deltas(msgs); got != best {
+			t.Errorf("fail=%v: answer after reset = %q, want the best candidate", fail, got)
+		}
+	}
+}
+
+func TestStreamStallsAdoptedSpeculation(t *testing.T) {
+	const best = "Paris is the capital of France."
+	testConfig(t, func(c *config) {
+		c.SpeculativeSynth = true
+		c.SynthStallTimeout = duration(50 * time.Millisecond)
+		m := c.Modes["quality"]
+		m.Timeout = duration(5 * time.Second)
+		c.Modes["quality"] = m
+	})
+	useGenerator(t, brokenSynth{ensemble(
+		map[string]string{"llama3.2": best, "qwen2.5": "The capital is Paris.", "mistral": "Paris."},
+		map[string]int{"llama3.2": 9, "qwen2.5": 7, "mistral": 3},
+		"",
+	), false})
+
+	hits := specHits.Load()
+	start := time.Now()
+	msgs := postStream(t, `{"prompt":"capital?","mode":"quality"}`)
+	if specHits.Load() == hits {
+		t.Fatal("the speculation wasn't adopted")
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("stalled speculation held the stream for %v", took)
+	}
+	if got := deltas(msgs); got != best {
+		t.Errorf("answer after the stall = %q, want the best candidate; %+v", got, msgs)
+	}
+	if last := msgs[len(msgs)-1]; last.State != streamComplete {
+		t.Errorf("stream ended %q, want complete", last.State)
+	}
+}
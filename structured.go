@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// -------------------- Structured output --------------------
+
+// errNotJSON is reported when a structured request's answer doesn't parse.
+var errNotJSON = errors.New("model output is not valid JSON")
+
+// structured reports whether the request asked for JSON output.
+func (r AnswerRequest) structured() bool {
+	return len(r.ResponseSchema) > 0 && string(r.ResponseSchema) != "null"
+}
+
+// checkStructured unwraps a Markdown code fence some models put around JSON
+// even in format mode, then makes sure Final and every Finals entry parse.
+func checkStructured(resp *AnswerResponse) error {
+	resp.Final = unfenceJSON(resp.Final)
+	if !json.Valid([]byte(resp.Final)) {
+		return errNotJSON
+	}
+	for i := range resp.Finals {
+		resp.Finals[i].Text = unfenceJSON(resp.Finals[i].Text)
+		if !json.Valid([]byte(resp.Finals[i].Text)) {
+			return errNotJSON
+		}
+	}
+	return nil
+}
+
+func unfenceJSON(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "```") || !strings.HasSuffix(s, "```") || len(s) < 6 {
+		return s
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "```"), "```")
+	s = strings.TrimPrefix(s, "json")
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,43 @@
+package main
+
+import "fmt"
+
+// -------------------- Reasoning style --------------------
+
+// reasoning_style values
+const (
+	styleAuto     = "auto" // leave step-by-step to the model (the default)
+	styleDirect   = "direct"
+	styleStepwise = "stepwise"
+)
+
+func checkReasoningStyle(req AnswerRequest) error {
+	switch req.ReasoningStyle {
+	case "", styleAuto, styleDirect, styleStepwise:
+		return nil
+	}
+	return fmt.Errorf("reasoning_style must be %q, %q, or %q", styleAuto, styleDirect, styleStepwise)
+}
+
+// styleRule is the candidate-prompt instruction for reasoning_style; auto
+// adds none.
+func (r AnswerRequest) styleRule() string {
+	switch r.ReasoningStyle {
+	case styleDirect:
+		return "Answer directly; do not break the answer into numbered steps.\n"
+	case styleStepwise:
+		return "Explain step by step, as numbered steps.\n"
+	}
+	return ""
+}
+
+// synthStyleRule is the synthesis-prompt counterpart of styleRule.
+func (r AnswerRequest) synthStyleRule() string {
+	switch r.ReasoningStyle {
+	case styleDirect:
+		return "Give the answer directly; drop any numbered steps the answers use.\n"
+	case styleStepwise:
+		return "Present the answer as numbered steps.\n"
+	}
+	return "If a step-by-step explanation is helpful, include it.\n"
+}
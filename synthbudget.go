@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// -------------------- Synthesis input budget --------------------
+
+// synthLabelChars allows for each answer's separator and rank label.
+const synthLabelChars = 64
+
+// synthCutMarker ends an answer synthBudget shortened.
+const synthCutMarker = "\n[...]"
+
+// synthBudget fits top into cfg.SynthMaxInputChars of synthesis prompt by
+// cutting the lowest-ranked answers first, each down to nothing before the
+// next one up is touched. The top answer is never cut. It returns a trimmed
+// copy and the providers that were shortened.
+func synthBudget(req AnswerRequest, top []Candidate) ([]Candidate, []string) {
+	if cfg.SynthMaxInputChars <= 0 || len(top) < 2 {
+		return top, nil
+	}
+	over := len([]rune(synthPrompt(req, nil, nil))) - cfg.SynthMaxInputChars
+	for _, c := range top {
+		over += synthLabelChars + len([]rune(c.Text))
+	}
+	if over <= 0 {
+		return top, nil
+	}
+	out := append([]Candidate(nil), top...)
+	var cut []string
+	marker := len([]rune(synthCutMarker))
+	for i := len(out) - 1; i > 0 && over > 0; i-- {
+		// the marker takes room too, so cut that much more
+		r := []rune(out[i].Text)
+		n := min(over+marker, len(r))
+		out[i].Text = strings.TrimSpace(string(r[:len(r)-n])) + synthCutMarker
+		over -= n - marker
+		cut = append(cut, out[i].Provider)
+	}
+	return out, cut
+}
+
+// synthBudgetNote is the response note for answers synthBudget shortened.
+func synthBudgetNote(req AnswerRequest, top []Candidate) string {
+	_, cut := synthBudget(req, top)
+	if len(cut) == 0 {
+		return ""
+	}
+	log.Printf("synthesis input over %d chars; shortened %s", cfg.SynthMaxInputChars, strings.Join(cut, ", "))
+	return "shortened for synthesis: " + strings.Join(cut, ", ")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// synthInputChars is what synthBudget counts for cands: the prompt's fixed
+// text plus each answer with its label allowance.
+func synthInputChars(req AnswerRequest, cands []Candidate) int {
+	n := utf8.RuneCountInString(synthPrompt(req, nil, nil))
+	for _, c := range cands {
+		n += synthLabelChars + utf8.RuneCountInString(c.Text)
+	}
+	return n
+}
+
+func TestSynthBudgetFitsOversizedCandidates(t *testing.T) {
+	top := []Candidate{
+		{Provider: "a", Text: strings.Repeat("alpha ", 400)},
+		{Provider: "b", Text: strings.Repeat("béta ", 400)},
+		{Provider: "c", Text: strings.Repeat("gamma ", 400)},
+	}
+	// the top answer and three labels take 2592, two markers 12 more
+	for _, extra := range []int{4000, 3000, 2700, 2604} {
+		testConfig(t, nil)
+		budget := utf8.RuneCountInString(synthPrompt(AnswerRequest{}, nil, nil)) + extra
+		cfg.SynthMaxInputChars = budget
+		out, cut := synthBudget(AnswerRequest{}, top)
+		if len(cut) == 0 {
+			t.Fatalf("budget %d: nothing was cut", budget)
+		}
+		if n := synthInputChars(AnswerRequest{}, out); n > budget {
+			t.Errorf("budget %d: trimmed input is %d chars", budget, n)
+		}
+		if out[0].Text != top[0].Text {
+			t.Errorf("budget %d: the top answer was cut", budget)
+		}
+		for _, c := range out[1:] {
+			if c.Text != top[1].Text && c.Text != top[2].Text && !strings.HasSuffix(c.Text, synthCutMarker) {
+				t.Errorf("budget %d: %s was cut without the marker", budget, c.Provider)
+			}
+		}
+		if top[2].Text != strings.Repeat("gamma ", 400) {
+			t.Fatal("synthBudget changed its input")
+		}
+	}
+}
+
+func TestSynthBudgetLeavesFittingInputAlone(t *testing.T) {
+	testConfig(t, func(c *config) { c.SynthMaxInputChars = 100000 })
+	top := []Candidate{{Provider: "a", Text: "one"}, {Provider: "b", Text: "two"}}
+	if out, cut := synthBudget(AnswerRequest{}, top); cut != nil || out[1].Text != "two" {
+		t.Errorf("got %+v, cut %v", out, cut)
+	}
+}
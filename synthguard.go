@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+)
+
+// -------------------- Synthesis regression guard --------------------
+
+// synthRegressed reports whether the synthesized answer looks clearly worse
+// than the best candidate it was built from: shorter than SynthMinRatio of the
+// candidate's length and, when SynthRegressionRejudge is set, also losing a
+// head-to-head judge comparison. limit is the request's answer cap (0 = none),
+// since a capped synthesis can't be longer than that.
+func synthRegressed(ctx context.Context, g Generator, judgeModel, userPrompt, synth string, best Candidate, limit int) (bool, string) {
+	if cfg.SynthMinRatio <= 0 {
+		return false, ""
+	}
+	sn := utf8.RuneCountInString(normalizeText(synth))
+	bn := utf8.RuneCountInString(normalizeText(best.Text))
+	if limit > 0 && bn > limit {
+		bn = limit
+	}
+	if bn == 0 || float64(sn) >= cfg.SynthMinRatio*float64(bn) {
+		return false, ""
+	}
+
+	note := fmt.Sprintf("synthesis was %d chars vs %d for %s; returned %s instead", sn, bn, best.Provider, best.Provider)
+	if cfg.SynthRegressionRejudge {
+		synthWon, err := compareCandidates(ctx, g, judgeModel, userPrompt, Candidate{Provider: sourceSynthesis, Text: synth}, best)
+		if err != nil || synthWon {
+			return false, ""
+		}
+		note += " (judge preferred the candidate)"
+	}
+	return true, note
+}
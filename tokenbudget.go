@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// -------------------- Per-request token budget --------------------
+
+var errTokenBudget = errors.New("request token budget exhausted")
+
+// tokenBudget is the Generator a request's pipeline runs through. It adds
+// up the tokens every generation reports (Ollama's eval_count) and, once a
+// mode's max_tokens is spent, refuses to start new generations. The stages
+// then fall back as they would on any model error (fastPick instead of the
+// judge, the best candidate instead of synthesis), so the request finishes
+// with what it has. Generations already running are left to complete.
+type tokenBudget struct {
+	g     Generator
+	limit int64 // 0 = unlimited
+	used  atomic.Int64
+}
+
+func newTokenBudget(g Generator, limit int) *tokenBudget {
+	return &tokenBudget{g: g, limit: int64(limit)}
+}
+
+// exceeded reports whether the budget ran out during the request.
+func (b *tokenBudget) exceeded() bool {
+	return b.limit > 0 && b.used.Load() >= b.limit
+}
+
+func (b *tokenBudget) count(o genOptions) genOptions {
+	next := o.OnUsage
+	o.OnUsage = func(tokens int) {
+		b.used.Add(int64(tokens))
+		if next != nil {
+			next(tokens)
+		}
+	}
+	return o
+}
+
+func (b *tokenBudget) Generate(ctx context.Context, model, prompt string, o genOptions) (string, error) {
+	if b.exceeded() {
+		return "", errTokenBudget
+	}
+	return b.g.Generate(ctx, model, prompt, b.count(o))
+}
+
+func (b *tokenBudget) GenerateStream(ctx context.Context, model, prompt string, o genOptions, onDelta func(string) error) (string, error) {
+	if b.exceeded() {
+		return "", errTokenBudget
+	}
+	return b.g.GenerateStream(ctx, model, prompt, b.count(o), onDelta)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// -------------------- Stream transcripts --------------------
+
+// A streaming request sent with "X-Record-Transcript: true" (or ?transcript=1)
+// has every NDJSON line it receives recorded under a random ID the server
+// picks and returns in X-Transcript-ID. GET /answer/transcript/{id} returns
+// the lines recorded so far for TranscriptTTL, but only to the caller that
+// made the stream (same cache_tenant_header value); anyone else gets a 404.
+// At most MaxTranscripts are kept (oldest dropped first) and each is capped
+// at maxTranscriptLines lines.
+//
+// Recorded lines carry a seq number (1, 2, ...). A stream sent with
+// "X-Resumable: true" (or ?resumable=1) is recorded the same way, and also
+// keeps running when the client drops: GET /answer/stream/resume/{id}, with
+// the server's ID and from the same caller, replays the lines after
+// ?after=N (or a Last-Event-ID header) and then follows the stream live
+// until it ends.
+
+const maxTranscriptLines = 20000
+
+type transcript struct {
+	mu        sync.Mutex
+	lines     [][]byte
+	truncated bool
+	exp       time.Time
+	seq       int
+	done      bool
+	owner     string        // caller(r) of the stream's request
+	changed   chan struct{} // closed and replaced on every add, and on finish
+}
+
+var (
+	transcriptMu    sync.Mutex
+	transcripts     = map[string]*transcript{}
+	transcriptOrder []string // insertion order, for evicting the oldest
+)
+
+// newTranscriptID returns a fresh random transcript ID. Clients never choose
+// their own, so one can't be guessed or reused to overwrite another's.
+func newTranscriptID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// caller identifies who sent r: a hash of its cfg.CacheTenantHeader value
+// (the API key, by default), or "" for anonymous callers.
+func caller(r *http.Request) string {
+	v := r.Header.Get(cfg.CacheTenantHeader)
+	if v == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(sum[:])
+}
+
+func wantsTranscript(r *http.Request) bool {
+	if v, err := strconv.ParseBool(r.Header.Get("X-Record-Transcript")); err == nil {
+		return v
+	}
+	v, _ := strconv.ParseBool(r.URL.Query().Get("transcript"))
+	return v
+}
+
+func wantsResumable(r *http.Request) bool {
+	if v, err := strconv.ParseBool(r.Header.Get("X-Resumable")); err == nil {
+		return v
+	}
+	v, _ := strconv.ParseBool(r.URL.Query().Get("resumable"))
+	return v
+}
+
+func newTranscript(id, owner string) *transcript {
+	t := &transcript{exp: time.Now().Add(time.Duration(cfg.TranscriptTTL)), owner: owner, changed: make(chan struct{})}
+
+	transcriptMu.Lock()
+	defer transcriptMu.Unlock()
+
+	now := time.Now()
+	kept := transcriptOrder[:0]
+	for _, k := range transcriptOrder {
+		if old, ok := transcripts[k]; ok && now.Before(old.exp) && k != id {
+			kept = append(kept, k)
+		} else if k != id {
+			delete(transcripts, k)
+		}
+	}
+	transcriptOrder = kept
+	for len(transcriptOrder) >= cfg.MaxTranscripts && len(transcriptOrder) > 0 {
+		delete(transcripts, transcriptOrder[0])
+		transcriptOrder = transcriptOrder[1:]
+	}
+
+	transcripts[id] = t
+	transcriptOrder = append(transcriptOrder, id)
+	return t
+}
+
+func (t *transcript) add(line []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.lines) >= maxTranscriptLines {
+		t.truncated = true
+		return
+	}
+	t.lines = append(t.lines, bytes.Clone(line))
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// nextSeq numbers the next recorded line; lines are written one at a time
+// by the stream's handler, so seq N is lines[N-1].
+func (t *transcript) nextSeq() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	return t.seq
+}
+
+// finish marks the stream as ended, releasing anyone following it.
+func (t *transcript) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done = true
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// transcriptWriter tees everything written to the client into a transcript.
+// A detached writer (resumable streams) hides client write errors so the
+// pipeline keeps going after a disconnect.
+type transcriptWriter struct {
+	http.ResponseWriter
+	t        *transcript
+	detached bool
+}
+
+func (tw transcriptWriter) Write(b []byte) (int, error) {
+	tw.t.add(b)
+	n, err := tw.ResponseWriter.Write(b)
+	if tw.detached {
+		return len(b), nil
+	}
+	return n, err
+}
+
+func (tw transcriptWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// lookupTranscript returns the live transcript id if it belongs to r's
+// caller. Someone else's looks the same as a missing one.
+func lookupTranscript(id string, r *http.Request) (*transcript, bool) {
+	transcriptMu.Lock()
+	t, ok := transcripts[id]
+	transcriptMu.Unlock()
+	if !ok || time.Now().After(t.exp) || t.owner != caller(r) {
+		return nil, false
+	}
+	return t, true
+}
+
+func handleTranscript(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	t, ok := lookupTranscript(id, r)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errResp{Error: "transcript not found or expired"})
+		return
+	}
+
+	t.mu.Lock()
+	lines, truncated := t.lines, t.truncated
+	t.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="transcript-`+id+`.ndjson"`)
+	if truncated {
+		w.Header().Set("X-Transcript-Truncated", "true")
+	}
+	for _, l := range lines {
+		_, _ = w.Write(l)
+	}
+}
+
+// handleResume replays a resumable stream after a cursor and follows it live.
+func handleResume(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	cursor := r.URL.Query().Get("after")
+	if cursor == "" {
+		cursor = r.Header.Get("Last-Event-ID")
+	}
+	after, err := strconv.Atoi(cursor)
+	if cursor != "" && (err != nil || after < 0) {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: "after must be a sequence number"})
+		return
+	}
+
+	t, ok := lookupTranscript(id, r)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errResp{Error: "stream not found or expired"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		t.mu.Lock()
+		var lines [][]byte
+		if after < len(t.lines) {
+			lines = t.lines[after:]
+		}
+		done, changed := t.done, t.changed
+		t.mu.Unlock()
+
+		for _, l := range lines {
+			if _, err := w.Write(l); err != nil {
+				return
+			}
+		}
+		after += len(lines)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// streamWithTranscript runs a recorded stream as the caller with apiKey and
+// returns the transcript ID the server issued.
+func streamWithTranscript(t *testing.T, apiKey string) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/answer/stream", strings.NewReader(`{"prompt":"capital of France?","mode":"fast"}`))
+	req.Header.Set("X-Record-Transcript", "true")
+	req.Header.Set("X-Request-ID", "chosen-by-client")
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	rec := httptest.NewRecorder()
+	handleAnswerStream(rec, req)
+	id := rec.Header().Get("X-Transcript-ID")
+	if id == "" {
+		t.Fatal("no X-Transcript-ID on a recorded stream")
+	}
+	return id
+}
+
+// getTranscript fetches id as the caller with apiKey.
+func getTranscript(id, apiKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/answer/transcript/"+id, nil)
+	req.SetPathValue("id", id)
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	rec := httptest.NewRecorder()
+	handleTranscript(rec, req)
+	return rec
+}
+
+func TestTranscriptIDsAreIssuedByTheServer(t *testing.T) {
+	testConfig(t, nil)
+	useGenerator(t, ensemble(map[string]string{"llama3.2": "Paris.", "qwen2.5": "Paris."}, nil, "Paris."))
+
+	a, b := streamWithTranscript(t, "key-a"), streamWithTranscript(t, "key-a")
+	if a == "chosen-by-client" || a == b || len(a) != 32 {
+		t.Fatalf("transcript IDs %q and %q should be fresh random ones", a, b)
+	}
+	if rec := getTranscript(a, "key-a"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"type"`) {
+		t.Fatalf("owner got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTranscriptIsScopedToItsCaller(t *testing.T) {
+	testConfig(t, nil)
+	useGenerator(t, ensemble(map[string]string{"llama3.2": "Paris.", "qwen2.5": "Paris."}, nil, "Paris."))
+
+	id := streamWithTranscript(t, "key-a")
+	for _, other := range []string{"key-b", ""} {
+		if rec := getTranscript(id, other); rec.Code != http.StatusNotFound {
+			t.Errorf("caller %q got %d for another caller's transcript, want 404", other, rec.Code)
+		}
+	}
+
+	anon := streamWithTranscript(t, "")
+	if rec := getTranscript(anon, "key-a"); rec.Code != http.StatusNotFound {
+		t.Errorf("keyed caller got %d for an anonymous transcript, want 404", rec.Code)
+	}
+	if rec := getTranscript(anon, ""); rec.Code != http.StatusOK {
+		t.Errorf("anonymous owner got %d for its own transcript", rec.Code)
+	}
+}
+
+func TestResumeIsScopedToItsCaller(t *testing.T) {
+	testConfig(t, nil)
+	useGenerator(t, ensemble(map[string]string{"llama3.2": "Paris.", "qwen2.5": "Paris."}, nil, "Paris."))
+
+	req := httptest.NewRequest(http.MethodPost, "/answer/stream", strings.NewReader(`{"prompt":"capital of France?","mode":"fast"}`))
+	req.Header.Set("X-Resumable", "true")
+	req.Header.Set("X-API-Key", "key-a")
+	rec := httptest.NewRecorder()
+	handleAnswerStream(rec, req)
+	id := rec.Header().Get("X-Transcript-ID")
+
+	resume := func(id, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/answer/stream/resume/"+id+"?after=1", nil)
+		req.SetPathValue("id", id)
+		req.Header.Set("X-API-Key", apiKey)
+		rec := httptest.NewRecorder()
+		handleResume(rec, req)
+		return rec
+	}
+	if got := resume(id, "key-a"); got.Code != http.StatusOK || got.Body.Len() == 0 || got.Body.Len() >= rec.Body.Len() {
+		t.Errorf("owner resume got %d with %d of %d bytes", got.Code, got.Body.Len(), rec.Body.Len())
+	}
+	if got := resume(id, "key-b"); got.Code != http.StatusNotFound {
+		t.Errorf("another caller resumed the stream: %d", got.Code)
+	}
+	if got := resume("chosen-by-client", "key-a"); got.Code != http.StatusNotFound {
+		t.Errorf("resume by a client-chosen ID got %d", got.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// -------------------- Answer translation --------------------
+
+// languageNames maps ISO 639-1 codes to names for the prompts below.
+var languageNames = map[string]string{
+	"en": "English", "es": "Spanish", "fr": "French", "de": "German",
+	"it": "Italian", "pt": "Portuguese", "nl": "Dutch",
+}
+
+// stopwords are frequent short words that identify a language cheaply.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "is", "of", "to", "in", "that", "it", "with", "for"},
+	"es": {"el", "la", "de", "que", "y", "en", "los", "es", "por", "con"},
+	"fr": {"le", "la", "les", "de", "et", "est", "que", "des", "une", "pour"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "mit", "den", "ein", "zu"},
+	"it": {"il", "di", "che", "e", "la", "per", "non", "un", "sono", "con"},
+	"pt": {"o", "de", "que", "e", "do", "da", "em", "um", "para", "não"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "met"},
+}
+
+// languageCode normalizes "en", "EN", or "English" to "en". Unknown names
+// are returned lowercased, so they still work in prompts and cache keys.
+func languageCode(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	for code, name := range languageNames {
+		if lang == strings.ToLower(name) {
+			return code
+		}
+	}
+	return lang
+}
+
+func languageName(code string) string {
+	if name, ok := languageNames[code]; ok {
+		return name
+	}
+	return code
+}
+
+// detectLanguage guesses the language of text from stopword hits, returning
+// "" when the text is too short or no language clearly leads.
+func detectLanguage(text string) string {
+	counts := map[string]int{}
+	words := 0
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r > 127)
+	}) {
+		words++
+		for code, sw := range stopwords {
+			for _, s := range sw {
+				if w == s {
+					counts[code]++
+				}
+			}
+		}
+	}
+	best, bestN, secondN := "", 0, 0
+	for code, n := range counts {
+		switch {
+		case n > bestN:
+			best, bestN, secondN = code, n, bestN
+		case n > secondN:
+			secondN = n
+		}
+	}
+	if words < 8 || bestN < max(words/10, 1) || bestN < 2*secondN {
+		return ""
+	}
+	return best
+}
+
+// translateAnswer rewrites Final (and any Finals) into the request's
+// target_language, skipping text already detected as that language. A
+// failed translation keeps the original text and adds a note. Structured
+// answers are left alone: a translated document may no longer parse or
+// match the schema.
+func translateAnswer(ctx context.Context, g Generator, resp *AnswerResponse, req AnswerRequest) {
+	target := languageCode(req.TargetLanguage)
+	if target == "" || req.structured() {
+		return
+	}
+	tr := func(text string) string {
+		if detectLanguage(text) == target {
+			return text
+		}
+		out, err := g.Generate(ctx, defaultJudgeModel, translatePrompt(text, languageName(target)), synthOptions())
+		if out = stripReasoning(out); err != nil || strings.TrimSpace(out) == "" {
+			resp.Notes = append(resp.Notes, "translation to "+languageName(target)+" failed; answer left as generated")
+			return text
+		}
+		return out
+	}
+	if len(resp.Finals) == 0 {
+		resp.Final = tr(resp.Final)
+		return
+	}
+	// Final is Finals[0]
+	for i := range resp.Finals {
+		resp.Finals[i].Text = tr(resp.Finals[i].Text)
+	}
+	resp.Final = resp.Finals[0].Text
+}
+
+func translatePrompt(text, language string) string {
+	return fmt.Sprintf("Translate the text below into %s. Keep code blocks, names, numbers and Markdown formatting unchanged. "+
+		"If it is already written in %s, return it unchanged. Reply with the translation only.\n\nText:\n%s", language, language, text)
+}
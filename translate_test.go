@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTranslateSkipsStructuredAnswers(t *testing.T) {
+	testConfig(t, nil)
+	answer := `{"city":"Paris","country":"France","note":"the capital and the largest city of the country"}`
+	g := ensemble(map[string]string{"llama3.2": answer, "qwen2.5": answer}, map[string]int{"llama3.2": 8, "qwen2.5": 7}, answer)
+	ans := g.respond
+	g.respond = func(model, prompt string) (string, error) {
+		if strings.HasPrefix(prompt, "Translate the text") {
+			return `{"Stadt": "Paris"`, nil
+		}
+		return ans(model, prompt)
+	}
+	useGenerator(t, g)
+
+	code, resp := postAnswer(t, handleAnswer, `{"prompt":"capital of France as JSON","mode":"fast","response_schema":"json","target_language":"de"}`)
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if n := len(g.prompts("Translate the text")); n != 0 {
+		t.Errorf("%d translation calls for a structured answer, want none", n)
+	}
+	if !json.Valid([]byte(resp.Final)) {
+		t.Errorf("final is not JSON: %q", resp.Final)
+	}
+}
+
+func TestTranslateRewritesFreeText(t *testing.T) {
+	testConfig(t, nil)
+	answer := "The capital of France is Paris, and it is also the largest city in the country."
+	g := ensemble(map[string]string{"llama3.2": answer, "qwen2.5": answer}, map[string]int{"llama3.2": 8, "qwen2.5": 7}, answer)
+	ans := g.respond
+	g.respond = func(model, prompt string) (string, error) {
+		if strings.HasPrefix(prompt, "Translate the text") {
+			return "Die Hauptstadt von Frankreich ist Paris.", nil
+		}
+		return ans(model, prompt)
+	}
+	useGenerator(t, g)
+
+	if _, resp := postAnswer(t, handleAnswer, `{"prompt":"capital of France?","mode":"fast","target_language":"de"}`); resp.Final != "Die Hauptstadt von Frankreich ist Paris." {
+		t.Errorf("final = %q, want the translation", resp.Final)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// -------------------- Answer validators --------------------
+
+// answerValidator checks a synthesized answer against a shape the client
+// asked for. reason is fed back to the synth model on retry, so it should
+// say what to fix.
+type answerValidator interface {
+	Validate(final string) (ok bool, reason string)
+}
+
+// validateSpec is the request's validate object; every set rule applies.
+type validateSpec struct {
+	MinItems  int    `json:"min_items,omitempty"`  // list items (numbered or bulleted lines)
+	MaxItems  int    `json:"max_items,omitempty"`  // 0 = no upper bound
+	MustMatch string `json:"must_match,omitempty"` // regexp the answer must contain a match for
+	JSON      bool   `json:"json,omitempty"`       // the answer must be valid JSON
+}
+
+type itemCount struct{ min, max int }
+
+// listItem matches a numbered ("1." / "1)") or bulleted ("-", "*", "•") line.
+var listItem = regexp.MustCompile(`^\s*(\d+[.)]|[-*•])\s+\S`)
+
+func (v itemCount) Validate(final string) (bool, string) {
+	n := 0
+	for _, line := range strings.Split(final, "\n") {
+		if listItem.MatchString(line) {
+			n++
+		}
+	}
+	switch {
+	case n < v.min:
+		return false, fmt.Sprintf("the answer must be a list of at least %d items, but it has %d", v.min, n)
+	case v.max > 0 && n > v.max:
+		return false, fmt.Sprintf("the answer must be a list of at most %d items, but it has %d", v.max, n)
+	}
+	return true, ""
+}
+
+type mustMatch struct{ re *regexp.Regexp }
+
+func (v mustMatch) Validate(final string) (bool, string) {
+	if v.re.MatchString(final) {
+		return true, ""
+	}
+	return false, fmt.Sprintf("the answer must contain text matching the pattern %s", v.re)
+}
+
+type validJSON struct{}
+
+func (validJSON) Validate(final string) (bool, string) {
+	if json.Valid([]byte(unfenceJSON(final))) {
+		return true, ""
+	}
+	return false, "the answer must be valid JSON only, with no other text"
+}
+
+// validators builds the request's validators; nil when it asked for none.
+// checkValidate has already vetted the spec.
+func (r AnswerRequest) validators() []answerValidator {
+	s := r.Validate
+	if s == nil {
+		return nil
+	}
+	var vs []answerValidator
+	if s.MinItems > 0 || s.MaxItems > 0 {
+		vs = append(vs, itemCount{s.MinItems, s.MaxItems})
+	}
+	if s.MustMatch != "" {
+		vs = append(vs, mustMatch{regexp.MustCompile(s.MustMatch)})
+	}
+	if s.JSON {
+		vs = append(vs, validJSON{})
+	}
+	return vs
+}
+
+func checkValidate(req AnswerRequest) error {
+	s := req.Validate
+	if s == nil {
+		return nil
+	}
+	if s.MinItems < 0 || s.MaxItems < 0 || (s.MaxItems > 0 && s.MaxItems < s.MinItems) {
+		return fmt.Errorf("validate: need 0 <= min_items <= max_items")
+	}
+	if s.MustMatch != "" {
+		if _, err := regexp.Compile(s.MustMatch); err != nil {
+			return fmt.Errorf("validate.must_match: %v", err)
+		}
+	}
+	return nil
+}
+
+// validationError is returned when synthesis keeps failing validation.
+type validationError struct{ reason string }
+
+func (e *validationError) Error() string { return "answer failed validation: " + e.reason }
+
+// validateSynth runs the request's validators over a synthesis result and,
+// while they fail, re-synthesizes up to cfg.ValidateRetries times with the
+// reason appended to the prompt.
+func validateSynth(ctx context.Context, g Generator, model, prompt string, req AnswerRequest, text, raw string, err error) (string, string, error) {
+	vs := req.validators()
+	for attempt := 0; err == nil && len(vs) > 0; attempt++ {
+		ok, reason := true, ""
+		for _, v := range vs {
+			if ok, reason = v.Validate(text); !ok {
+				break
+			}
+		}
+		if ok {
+			break
+		}
+		if attempt >= cfg.ValidateRetries || ctx.Err() != nil {
+			return "", raw, &validationError{reason}
+		}
+		log.Printf("synthesis failed validation (%s); retrying", reason)
+		text, raw, err = synthesize(ctx, g, model, prompt+"\nA previous attempt was rejected: "+reason+". Fix that.\n", req)
+	}
+	return text, raw, err
+}
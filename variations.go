@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -------------------- Alternative phrasings --------------------
+
+// maxVariations bounds a request's variations.
+const maxVariations = 5
+
+// variationStyles give each rephrasing a different angle, so they don't
+// all come out alike.
+var variationStyles = []string{
+	"more concise and direct",
+	"warmer and more conversational",
+	"more formal, structured with short headings or bullets where they help",
+	"plainer, for a reader new to the topic",
+	"livelier, with a different opening and order",
+}
+
+func checkVariations(req AnswerRequest) error {
+	if req.Variations < 0 || req.Variations > maxVariations {
+		return fmt.Errorf("variations must be between 0 and %d", maxVariations)
+	}
+	if req.Variations > 0 && req.structured() {
+		return fmt.Errorf("variations can't be combined with response_schema")
+	}
+	return nil
+}
+
+// addVariations rephrases resp.Final req.Variations times, in parallel, into
+// resp.Variations. It runs after the answer is cached, so the variations
+// never are: every request, hit or miss, gets fresh ones. Failed rephrasings
+// are left out; if time is already short none are attempted.
+func addVariations(ctx context.Context, g Generator, resp *AnswerResponse, req AnswerRequest, short bool) {
+	if req.Variations <= 0 || strings.TrimSpace(resp.Final) == "" {
+		return
+	}
+	if short {
+		resp.Notes = append(resp.Notes, "variations skipped: request deadline nearly spent")
+		return
+	}
+	out := make([]string, req.Variations)
+	var wg sync.WaitGroup
+	for i := range out {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o := synthOptions()
+			o.Options = map[string]any{"temperature": 0.9, "seed": i + 1}
+			text, err := g.Generate(ctx, req.judgeModel(), variationPrompt(req.Prompt, resp.Final, variationStyles[i%len(variationStyles)]), o)
+			if text = strings.TrimSpace(stripReasoning(text)); err == nil {
+				out[i] = text
+			}
+		}()
+	}
+	wg.Wait()
+	for _, v := range out {
+		if v != "" {
+			resp.Variations = append(resp.Variations, v)
+		}
+	}
+	if len(resp.Variations) < req.Variations {
+		resp.Notes = append(resp.Notes, fmt.Sprintf("%d of %d variations failed", req.Variations-len(resp.Variations), req.Variations))
+	}
+}
+
+// addCachedVariations is addVariations for a cache hit. Rephrasing calls a
+// model, so like a miss it takes an admission slot and runs under the mode's
+// timeout (or X-Timeout-Ms); when the queue turns it away the hit is served
+// without variations.
+func addCachedVariations(r *http.Request, resp *AnswerResponse, req AnswerRequest, mode string, clientTimeout time.Duration) {
+	if req.Variations <= 0 || strings.TrimSpace(resp.Final) == "" {
+		return
+	}
+	release, ok := admit(r.Context())
+	if !ok {
+		resp.Notes = append(resp.Notes, "variations skipped: server busy")
+		return
+	}
+	defer release()
+	timeout := time.Duration(cfg.Modes[mode].Timeout)
+	if clientTimeout > 0 {
+		timeout = clientTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	addVariations(ctx, gen, resp, req, false)
+}
+
+func variationPrompt(question, answer, style string) string {
+	return "Rephrase the answer below so it says the same thing, with the same facts, code and numbers, but reads " + style +
+		". Reply with the rephrased answer only.\n\nQuestion:\n" + question + "\n\nAnswer:\n" + answer + "\n"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowRephrasing answers like g but holds every rephrasing until ctx ends.
+type slowRephrasing struct{ *fakeGenerator }
+
+func (s slowRephrasing) Generate(ctx context.Context, model, prompt string, o genOptions) (string, error) {
+	if strings.HasPrefix(prompt, "Rephrase the answer") {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	return s.fakeGenerator.Generate(ctx, model, prompt, o)
+}
+
+func variationsEnsemble() *fakeGenerator {
+	g := ensemble(map[string]string{"llama3.2": "Paris.", "qwen2.5": "It is Paris."}, map[string]int{"llama3.2": 8, "qwen2.5": 6}, "Paris.")
+	answer := g.respond
+	g.respond = func(model, prompt string) (string, error) {
+		if strings.HasPrefix(prompt, "Rephrase the answer") {
+			return "Rephrased by " + model, nil
+		}
+		return answer(model, prompt)
+	}
+	return g
+}
+
+func TestVariationsUseTheRequestsJudgeModel(t *testing.T) {
+	testConfig(t, nil)
+	g := variationsEnsemble()
+	useGenerator(t, g)
+
+	_, resp := postAnswer(t, handleAnswer, `{"prompt":"capital of France?","mode":"fast","variations":1,"judge_model":"qwen2.5"}`)
+	if len(resp.Variations) != 1 || resp.Variations[0] != "Rephrased by qwen2.5" {
+		t.Errorf("variations %q, want one from the request's judge model", resp.Variations)
+	}
+}
+
+func TestCachedVariationsWaitForAdmission(t *testing.T) {
+	testConfig(t, func(c *config) {
+		c.MaxInFlight = 1
+		c.QueueWait = duration(20 * time.Millisecond)
+	})
+	old := admitSlots
+	initAdmission(cfg.MaxInFlight)
+	t.Cleanup(func() { admitSlots = old })
+	g := variationsEnsemble()
+	useGenerator(t, g)
+
+	body := `{"prompt":"capital of France?","mode":"fast","variations":2}`
+	postAnswer(t, handleAnswer, body)
+	calls := len(g.prompts("Rephrase the answer"))
+
+	release, _ := admit(context.Background())
+	defer release()
+	code, resp := postAnswer(t, handleAnswer, body)
+	if code != http.StatusOK || !resp.Cached {
+		t.Fatalf("got %d cached=%v, want the hit served", code, resp.Cached)
+	}
+	if len(resp.Variations) != 0 || !slices.Contains(resp.Notes, "variations skipped: server busy") {
+		t.Errorf("variations %q notes %q, want none while every slot is taken", resp.Variations, resp.Notes)
+	}
+	if len(g.prompts("Rephrase the answer")) != calls {
+		t.Error("rephrased without an admission slot")
+	}
+}
+
+func TestCachedVariationsKeepTheDeadline(t *testing.T) {
+	testConfig(t, nil)
+	g := variationsEnsemble()
+	useGenerator(t, g)
+	body := `{"prompt":"capital of France?","mode":"fast","variations":1}`
+	postAnswer(t, handleAnswer, body)
+
+	useGenerator(t, slowRephrasing{g})
+	req := httptest.NewRequest(http.MethodPost, "/answer", strings.NewReader(body))
+	req.Header.Set("X-Timeout-Ms", "50")
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	handleAnswer(rec, req)
+	if rec.Code != http.StatusOK || time.Since(start) > 2*time.Second {
+		t.Fatalf("got %d after %v, want the hit within the client's deadline", rec.Code, time.Since(start))
+	}
+	if !strings.Contains(rec.Body.String(), "1 of 1 variations failed") {
+		t.Errorf("body %s", rec.Body)
+	}
+}
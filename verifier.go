@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+// -------------------- Fast-mode verifier --------------------
+
+// verifyFast double-checks a fast-path answer with cfg.FastVerifierModel
+// before the judge is skipped: one short yes/no call. It reports whether
+// the fast path may stand; a "no" sends the request down the full judge
+// path. Without a verifier, or if the verifier fails or is unclear, the
+// fast path stands.
+func verifyFast(ctx context.Context, g Generator, req AnswerRequest, cands []Candidate) bool {
+	if cfg.FastVerifierModel == "" {
+		return true
+	}
+	best := fastPick(cands)
+	o := judgeOptions()
+	o.Options = map[string]any{"temperature": 0, "seed": 0, "num_predict": 4}
+	out, err := g.Generate(ctx, cfg.FastVerifierModel, verifyPrompt(req.groundedPrompt(), best.Text), o)
+	if err != nil {
+		log.Printf("fast verifier: %v; keeping fast answer", err)
+		return true
+	}
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(out)), "no") {
+		log.Printf("fast verifier rejected %s's answer; escalating to judge", best.Provider)
+		return false
+	}
+	return true
+}
+
+func verifyPrompt(question, answer string) string {
+	return "Is the answer below plausibly correct for the question? Reply with only yes or no.\n\n" +
+		"Question:\n" + question + "\n\nAnswer:\n" + answer + "\n"
+}